@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+
+	"valorzard/gopher-combat/sound"
+)
+
+// the sound a hit that just landed makes
+func hitSound(result hitResult, defender *Fighter) sound.Effect {
+	switch {
+	case result.Blocked:
+		return sound.Block
+	case defender.Health == 0:
+		return sound.KO
+	}
+	return sound.Hit
+}
+
+// the music for a stage
+func stageTrack(stage Stage) string {
+	return strings.ToLower(stage.Name)
+}
+
+// loops a music track, leaving the reason it can't in the status message
+func playMusic(track string) {
+	if err := sound.PlayMusic(track); err != nil {
+		setStatus(err.Error())
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"valorzard/gopher-combat/signaling"
+)
+
+// a walk back and forth with the odd attack and block, looped by scripted bots
+var botScript = []struct {
+	input uint8
+	ticks int
+}{
+	{inputRight, 60},
+	{inputAttack, 1},
+	{0, 15},
+	{inputLeft, 60},
+	{inputBlock, 30},
+	{inputUp, 20},
+	{inputDown, 20},
+}
+
+// how one bot got on
+type botResult struct {
+	id int
+	// from asking to join to having a player id
+	joinTime time.Duration
+	// from asking to join to the data channel opening
+	connectTime time.Duration
+	err         error
+	sent        int
+	received    int
+}
+
+// settings shared by every bot
+type botConfig struct {
+	signalingURL string
+	lobbyId      string
+	password     string
+	duration     time.Duration
+	timeout      time.Duration
+	inputs       string
+	ready        bool
+}
+
+// joins a lobby with a crowd of headless players, for soak testing hosts and the signaling server
+// run with `go run . bot -lobby <id> -n 8`
+func runBots(args []string) {
+	botFlags := flag.NewFlagSet("bot", flag.ExitOnError)
+	lobbyId := botFlags.String("lobby", "", "id of the lobby to join")
+	count := botFlags.Int("n", 4, "how many bots to run")
+	server := botFlags.String("signaling", signalingIP+":"+strconv.Itoa(port), "host:port of the signaling server")
+	password := botFlags.String("password", "", "password of the lobby, if it has one")
+	duration := botFlags.Duration("duration", 30*time.Second, "how long each bot stays once connected")
+	timeout := botFlags.Duration("timeout", 20*time.Second, "how long a bot gets to connect before it counts as failed")
+	stagger := botFlags.Duration("stagger", 100*time.Millisecond, "time between bots joining")
+	inputs := botFlags.String("inputs", "random", "what the bots press: random, script or idle")
+	ready := botFlags.Bool("ready", false, "tick the ready box once connected")
+	botFlags.Parse(args)
+
+	if *lobbyId == "" {
+		fmt.Println("bot: -lobby is required")
+		os.Exit(2)
+	}
+	if !slices.Contains([]string{"random", "script", "idle"}, *inputs) {
+		fmt.Println("bot: -inputs must be random, script or idle")
+		os.Exit(2)
+	}
+	config := botConfig{
+		signalingURL: "http://" + *server,
+		lobbyId:      *lobbyId,
+		password:     *password,
+		duration:     *duration,
+		timeout:      *timeout,
+		inputs:       *inputs,
+		ready:        *ready,
+	}
+
+	results := make([]botResult, *count)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runBot(config, i)
+			if results[i].err != nil {
+				fmt.Printf("bot %d: failed: %v\n", i, results[i].err)
+			} else {
+				fmt.Printf("bot %d: player %d, joined in %v, connected in %v, sent %d, received %d\n", i, results[i].id, results[i].joinTime, results[i].connectTime, results[i].sent, results[i].received)
+			}
+		}()
+		time.Sleep(*stagger)
+	}
+	wg.Wait()
+	reportBots(results)
+}
+
+// prints how the bots did as a whole
+func reportBots(results []botResult) {
+	var connectTimes []time.Duration
+	failures := make(map[string]int)
+	for _, result := range results {
+		if result.err != nil {
+			failures[result.err.Error()]++
+			continue
+		}
+		connectTimes = append(connectTimes, result.connectTime)
+	}
+	fmt.Printf("\n%d/%d bots connected\n", len(connectTimes), len(results))
+	if len(connectTimes) > 0 {
+		slices.Sort(connectTimes)
+		var total time.Duration
+		for _, t := range connectTimes {
+			total += t
+		}
+		fmt.Printf("connect time: min %v, median %v, mean %v, max %v\n",
+			connectTimes[0], connectTimes[len(connectTimes)/2], total/time.Duration(len(connectTimes)), connectTimes[len(connectTimes)-1])
+	}
+	for reason, n := range failures {
+		fmt.Printf("%d failed: %s\n", n, reason)
+	}
+	if len(failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+// one bot's whole life: join, offer, wait for the answer, play for a while and leave
+func runBot(config botConfig, n int) botResult {
+	result := botResult{id: -1}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	// join the same way the game does
+	query := url.Values{}
+	query.Set("id", config.lobbyId)
+	if config.password != "" {
+		query.Set("password_hash", signaling.HashPassword(config.password))
+	}
+	resp, err := httpClient.Get(config.signalingURL + "/lobby/join?" + query.Encode())
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		result.err = fmt.Errorf("cannot join: %s", strings.TrimSpace(string(reason)))
+		return result
+	}
+	var player PlayerData
+	err = json.NewDecoder(resp.Body).Decode(&player)
+	resp.Body.Close()
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.id = player.Id
+	result.joinTime = time.Since(start)
+	slot := url.Values{}
+	slot.Set("lobby_id", config.lobbyId)
+	slot.Set("player_id", strconv.Itoa(player.Id))
+	slot.Set("token", player.Token)
+	defer botLeave(config, player)
+
+	pc := newPeerConnection()
+	defer pc.Close()
+	dc, err := pc.CreateDataChannel("data", nil)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	opened := make(chan io.ReadWriteCloser, 1)
+	dc.OnOpen(func() {
+		raw, err := dc.Detach()
+		if err != nil {
+			panic(err)
+		}
+		opened <- raw
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		result.err = err
+		return result
+	}
+	select {
+	case <-ctx.Done():
+		result.err = errors.New("timed out gathering candidates")
+		return result
+	case <-gatherComplete:
+	}
+	offerJson, err := json.Marshal(pc.LocalDescription())
+	if err != nil {
+		panic(err)
+	}
+	resp, err = httpClient.Post(config.signalingURL+"/offer/post?"+slot.Encode(), "application/json", bytes.NewBuffer(offerJson))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	resp.Body.Close()
+
+	if err := botAwaitAnswer(ctx, config, slot, pc); err != nil {
+		result.err = err
+		return result
+	}
+
+	var raw io.ReadWriteCloser
+	select {
+	case <-ctx.Done():
+		result.err = errors.New("timed out waiting for the data channel")
+		return result
+	case raw = <-opened:
+	}
+	result.connectTime = time.Since(start)
+
+	c := newPeerChannel(raw, dc)
+	sent, received, err := playBot(config, n, player, c)
+	result.sent, result.received, result.err = sent, received, err
+	return result
+}
+
+// polls for the host's answer to our offer
+func botAwaitAnswer(ctx context.Context, config botConfig, slot url.Values, pc *webrtc.PeerConnection) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out waiting for an answer")
+		case <-ticker.C:
+		}
+		resp, err := httpClient.Get(config.signalingURL + "/answer/get?" + slot.Encode())
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			continue
+		}
+		answer := webrtc.SessionDescription{}
+		err = json.NewDecoder(resp.Body).Decode(&answer)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		return pc.SetRemoteDescription(answer)
+	}
+}
+
+// says hello, then sends snapshots of a gopher driven by the bot's inputs until time is up
+// answers the host's pings along the way, so it shows up in the lobby like anyone else
+func playBot(config botConfig, n int, player PlayerData, c *peerChannel) (int, int, error) {
+	defer c.rw.Close()
+	if err := c.send(messageHello, &HelloMessage{fmt.Sprintf("Bot %d", n)}); err != nil {
+		return 0, 0, err
+	}
+	if config.ready {
+		c.send(messageReady, &ReadyMessage{true})
+	}
+
+	var received atomic.Int64
+	closed := make(chan error, 1)
+	go func() {
+		buffer := make([]byte, maxMessageSize)
+		for {
+			n, err := c.rw.Read(buffer)
+			if err != nil {
+				closed <- err
+				return
+			}
+			received.Add(1)
+			message := buffer[:n]
+			switch message[0] {
+			case messageState:
+				c.snapshots.decode(message[1:])
+			case messagePing:
+				var ping PingMessage
+				if decodeMessage(message, &ping) == nil {
+					c.send(messagePong, &ping)
+				}
+			case messageKick:
+				closed <- errors.New("kicked by the host")
+				return
+			}
+		}
+	}()
+
+	rng := rand.New(rand.NewPCG(uint64(n), uint64(time.Now().UnixNano())))
+	fighter := newFighter(rng.Float64()*400, rng.Float64()*240, jab)
+	tick := time.NewTicker(frameDuration)
+	defer tick.Stop()
+	send := time.NewTicker(minSendInterval)
+	defer send.Stop()
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	done := time.After(config.duration)
+
+	var input uint8
+	sent, frame, step, stepLeft := 0, 0, 0, 0
+	for {
+		select {
+		case <-done:
+			c.send(messageLeave, &LeaveMessage{})
+			return sent, int(received.Load()), nil
+		case err := <-closed:
+			return sent, int(received.Load()), fmt.Errorf("the host hung up: %w", err)
+		case <-heartbeat.C:
+			botHeartbeat(config, player)
+		case <-tick.C:
+			frame++
+			switch config.inputs {
+			case "random":
+				// hold each random input for a bit, like a person would
+				if frame%10 == 0 {
+					input = uint8(rng.IntN(int(inputBlock) << 1))
+				}
+			case "script":
+				if stepLeft == 0 {
+					step = (step + 1) % len(botScript)
+					stepLeft = botScript[step].ticks
+				}
+				input = botScript[step].input
+				stepLeft--
+			}
+			advanceFighter(&fighter, input)
+		case <-send.C:
+			state := c.snapshots.encode([]EntityState{{Id: player.Id, Pos_x: fighter.X, Pos_y: fighter.Y}})
+			if err := c.write(append([]byte{messageState}, state...)); err != nil {
+				return sent, int(received.Load()), err
+			}
+			sent++
+		}
+	}
+}
+
+func botHeartbeat(config botConfig, player PlayerData) {
+	query := url.Values{}
+	query.Set("id", config.lobbyId)
+	query.Set("player_id", strconv.Itoa(player.Id))
+	query.Set("token", player.Token)
+	resp, err := httpClient.Get(config.signalingURL + "/lobby/heartbeat?" + query.Encode())
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+// gives the bot's slot back
+func botLeave(config botConfig, player PlayerData) {
+	query := url.Values{}
+	query.Set("id", config.lobbyId)
+	query.Set("player_id", strconv.Itoa(player.Id))
+	query.Set("token", player.Token)
+	resp, err := httpClient.Get(config.signalingURL + "/lobby/leave?" + query.Encode())
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"valorzard/gopher-combat/signaling"
+)
+
+// asks the signaling server for every public lobby
+func listLobbies() ([]signaling.LobbyInfo, error) {
+	resp, err := httpClient.Get(getSignalingURL() + "/lobby/list")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot list lobbies: %s", resp.Status)
+	}
+	var lobbies []signaling.LobbyInfo
+	err = json.NewDecoder(resp.Body).Decode(&lobbies)
+	return lobbies, err
+}
+
+// lists the lobbies on the signaling server so you don't need an id to join one
+type LobbyBrowserScene struct {
+	ui   *ebitenui.UI
+	game *Game
+
+	list       *widget.Container
+	statusText *widget.Text
+
+	filter     string
+	hideFull   bool
+	hideLocked bool
+
+	// filled in by the goroutine fetching the list
+	mu       sync.Mutex
+	lobbies  []signaling.LobbyInfo
+	fetchErr error
+	fetched  atomic.Bool
+	fetching atomic.Bool
+}
+
+func newLobbyBrowserScene(g *Game) *LobbyBrowserScene {
+	s := &LobbyBrowserScene{game: g}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Lobbies"))
+
+	// filters by name, host or region
+	filterTextInput := newTextInput(g, "Filter", func(text string) {
+		s.filter = strings.ToLower(text)
+		s.fetched.Store(true)
+	})
+	rootContainer.AddChild(filterTextInput)
+
+	rootContainer.AddChild(newToggle(g, "Hide full lobbies", func(on bool) {
+		s.hideFull = on
+		s.fetched.Store(true)
+	}))
+	rootContainer.AddChild(newToggle(g, "Hide password protected lobbies", func(on bool) {
+		s.hideLocked = on
+		s.fetched.Store(true)
+	}))
+
+	s.list = widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+			widget.RowLayoutOpts.Spacing(5),
+		)),
+	)
+	rootContainer.AddChild(s.list)
+
+	rootContainer.AddChild(newButton(g, "Refresh", func(args *widget.ButtonClickedEventArgs) {
+		s.refresh()
+	}))
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Pop()
+	}))
+
+	s.statusText = newLabel(g, "")
+	rootContainer.AddChild(s.statusText)
+
+	return s
+}
+
+func (s *LobbyBrowserScene) Enter(g *Game) {
+	s.refresh()
+}
+
+func (s *LobbyBrowserScene) Exit(g *Game) {}
+
+func (s *LobbyBrowserScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	if s.fetched.Swap(false) {
+		s.rebuildList()
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *LobbyBrowserScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *LobbyBrowserScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
+
+// fetches the list in the background, the signaling server may be slow to answer
+func (s *LobbyBrowserScene) refresh() {
+	if s.fetching.Swap(true) {
+		return
+	}
+	s.statusText.Label = "Refreshing..."
+	go func() {
+		defer s.fetching.Store(false)
+		lobbies, err := listLobbies()
+		s.mu.Lock()
+		s.lobbies = lobbies
+		s.fetchErr = err
+		s.mu.Unlock()
+		s.fetched.Store(true)
+	}()
+}
+
+// whether a lobby makes it past the filters
+func (s *LobbyBrowserScene) shown(info signaling.LobbyInfo) bool {
+	if s.hideFull && info.Players >= info.MaxPlayers {
+		return false
+	}
+	if s.hideLocked && info.Password {
+		return false
+	}
+	if s.filter == "" {
+		return true
+	}
+	for _, field := range []string{info.Name, info.HostName, info.Region} {
+		if strings.Contains(strings.ToLower(field), s.filter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *LobbyBrowserScene) rebuildList() {
+	g := s.game
+	s.mu.Lock()
+	lobbies := s.lobbies
+	err := s.fetchErr
+	s.mu.Unlock()
+
+	s.list.RemoveChildren()
+	if err != nil {
+		s.statusText.Label = err.Error()
+		return
+	}
+
+	shown := 0
+	for _, info := range lobbies {
+		if !s.shown(info) {
+			continue
+		}
+		shown++
+
+		row := widget.NewContainer(
+			widget.ContainerOpts.Layout(widget.NewRowLayout(
+				widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+				widget.RowLayoutOpts.Spacing(15),
+			)),
+		)
+		description := fmt.Sprintf("%s (%s)  %d/%d  %s", info.Name, info.HostName, info.Players, info.MaxPlayers, info.Region)
+		if info.Password {
+			description += "  [locked]"
+		}
+		row.AddChild(newLabel(g, description))
+		row.AddChild(newButton(g, "Join", func(args *widget.ButtonClickedEventArgs) {
+			// no point trying without a password when we already know it needs one
+			if info.Password {
+				g.scenes.Push(newPasswordScene(g, info.Id, false, ""))
+				return
+			}
+			if err := joinLobby(g, info.Id, false); err != nil {
+				s.statusText.Label = err.Error()
+			}
+		}))
+		s.list.AddChild(row)
+	}
+
+	s.statusText.Label = fmt.Sprintf("%d of %d lobbies shown", shown, len(lobbies))
+}
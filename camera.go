@@ -0,0 +1,134 @@
+package main
+
+import (
+	"image"
+	"math/rand/v2"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// the part of the world a stage covers, the camera never shows anything outside it
+var stageBounds = image.Rect(-400, -200, 1040, 680)
+
+const (
+	// space kept around the fighters, in world pixels
+	cameraMargin = 80
+	// the closest the camera zooms in
+	maxZoom = 1.25
+	// how much of the way to where it wants to be the camera moves each tick
+	cameraSmoothing = 0.1
+	// hits doing at least this much damage shake the screen
+	heavyHitDamage = 10
+	// how far a heavy hit throws the screen about, in screen pixels, and for how long
+	hitShakeStrength = 8
+	hitShakeFrames   = 12
+)
+
+// which part of the world is on screen
+// world coordinates are the ones fighters move in, the UI is drawn straight onto the screen
+type Camera struct {
+	// the world point in the middle of the screen
+	X    float64
+	Y    float64
+	Zoom float64
+
+	// size of the screen, from Layout
+	width  float64
+	height float64
+	// whether the camera has been anywhere yet, before that it jumps straight to the fighters
+	placed bool
+
+	shakeStrength float64
+	shakeFrames   int
+	shakeTotal    int
+	shakeX        float64
+	shakeY        float64
+}
+
+func newCamera() *Camera {
+	return &Camera{Zoom: 1}
+}
+
+// called from Layout, so the camera knows how much screen it has to fill
+func (c *Camera) resize(width int, height int) {
+	c.width, c.height = float64(width), float64(height)
+}
+
+// the zoom at which the stage just fills the screen, the camera never zooms out further
+func (c *Camera) minZoom() float64 {
+	return max(c.width/float64(stageBounds.Dx()), c.height/float64(stageBounds.Dy()))
+}
+
+// the world space a gopher's sprite covers, with its top left corner at x, y
+func spriteAt(x float64, y float64) image.Rectangle {
+	return img.Bounds().Add(image.Pt(int(x), int(y)))
+}
+
+// moves the camera towards showing every target, zoomed in as far as it can while doing so
+// called once a tick, which is also when the shake wears off
+func (c *Camera) follow(targets ...image.Rectangle) {
+	if len(targets) == 0 || c.width == 0 || c.height == 0 {
+		return
+	}
+	box := targets[0]
+	for _, target := range targets[1:] {
+		box = box.Union(target)
+	}
+	box = box.Inset(-cameraMargin)
+
+	zoom := min(c.width/float64(box.Dx()), c.height/float64(box.Dy()), maxZoom)
+	zoom = max(zoom, c.minZoom())
+	x := float64(box.Min.X+box.Max.X) / 2
+	y := float64(box.Min.Y+box.Max.Y) / 2
+	if c.placed {
+		c.X += (x - c.X) * cameraSmoothing
+		c.Y += (y - c.Y) * cameraSmoothing
+		c.Zoom += (zoom - c.Zoom) * cameraSmoothing
+	} else {
+		c.X, c.Y, c.Zoom = x, y, zoom
+		c.placed = true
+	}
+	c.clamp()
+
+	// the shake dies down over its frames, it's only for show so it doesn't need to be deterministic
+	c.shakeX, c.shakeY = 0, 0
+	if c.shakeFrames > 0 {
+		strength := c.shakeStrength * float64(c.shakeFrames) / float64(c.shakeTotal)
+		c.shakeX = (rand.Float64()*2 - 1) * strength
+		c.shakeY = (rand.Float64()*2 - 1) * strength
+		c.shakeFrames--
+	}
+}
+
+// keeps the view inside the stage
+func (c *Camera) clamp() {
+	c.Zoom = max(c.Zoom, c.minZoom())
+	halfWidth := c.width / 2 / c.Zoom
+	halfHeight := c.height / 2 / c.Zoom
+	c.X = min(max(c.X, float64(stageBounds.Min.X)+halfWidth), float64(stageBounds.Max.X)-halfWidth)
+	c.Y = min(max(c.Y, float64(stageBounds.Min.Y)+halfHeight), float64(stageBounds.Max.Y)-halfHeight)
+}
+
+// throws the screen about for a few frames, a weaker shake doesn't cut a stronger one short
+func (c *Camera) shake(strength float64, frames int) {
+	if c.shakeFrames > 0 && c.shakeStrength*float64(c.shakeFrames)/float64(c.shakeTotal) > strength {
+		return
+	}
+	c.shakeStrength, c.shakeFrames, c.shakeTotal = strength, frames, frames
+}
+
+// shakes the screen if a hit was a heavy one
+func (c *Camera) hit(result hitResult) {
+	if !result.Blocked && result.Move.Damage >= heavyHitDamage {
+		c.shake(hitShakeStrength, hitShakeFrames)
+	}
+}
+
+// turns world coordinates into screen coordinates
+func (c *Camera) view() ebiten.GeoM {
+	var view ebiten.GeoM
+	view.Translate(-c.X, -c.Y)
+	view.Scale(c.Zoom, c.Zoom)
+	view.Translate(c.width/2+c.shakeX, c.height/2+c.shakeY)
+	return view
+}
@@ -0,0 +1,59 @@
+//go:build !js
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// the programs that can reach the clipboard, tried in order
+// there's no clipboard in the standard library, so we borrow the system's tools
+func clipboardCommands(write bool) [][]string {
+	switch runtime.GOOS {
+	case "windows":
+		if write {
+			return [][]string{{"clip"}}
+		}
+		return [][]string{{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}}
+	case "darwin":
+		if write {
+			return [][]string{{"pbcopy"}}
+		}
+		return [][]string{{"pbpaste"}}
+	}
+	if write {
+		return [][]string{{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	}
+	return [][]string{{"wl-paste", "--no-newline"}, {"xclip", "-selection", "clipboard", "-o"}, {"xsel", "--clipboard", "--output"}}
+}
+
+// puts text on the clipboard, it's printed too in case there's no clipboard to be had
+func copyToClipboard(text string) {
+	fmt.Println(text)
+	for _, args := range clipboardCommands(true) {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if cmd.Run() == nil {
+			return
+		}
+	}
+	fmt.Println("cannot copy to the clipboard, copy the text above instead")
+}
+
+// reads the clipboard in the background, done is called from another goroutine
+func pasteFromClipboard(done func(text string, err error)) {
+	go func() {
+		for _, args := range clipboardCommands(false) {
+			out, err := exec.Command(args[0], args[1:]...).Output()
+			if err == nil {
+				done(strings.TrimSpace(string(out)), nil)
+				return
+			}
+		}
+		done("", errors.New("cannot read the clipboard"))
+	}()
+}
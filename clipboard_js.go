@@ -0,0 +1,44 @@
+//go:build js
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall/js"
+)
+
+// puts text on the clipboard, it's printed too in case there's no clipboard to be had
+func copyToClipboard(text string) {
+	fmt.Println(text)
+	clipboard := js.Global().Get("navigator").Get("clipboard")
+	if clipboard.IsUndefined() {
+		fmt.Println("cannot copy to the clipboard, copy the text above instead")
+		return
+	}
+	clipboard.Call("writeText", text)
+}
+
+// reads the clipboard in the background, done is called from another goroutine
+func pasteFromClipboard(done func(text string, err error)) {
+	clipboard := js.Global().Get("navigator").Get("clipboard")
+	if clipboard.IsUndefined() {
+		done("", errors.New("cannot read the clipboard"))
+		return
+	}
+	// the browser asks for permission, so the text only turns up later
+	var then, catch js.Func
+	then = js.FuncOf(func(this js.Value, args []js.Value) any {
+		then.Release()
+		catch.Release()
+		go done(args[0].String(), nil)
+		return nil
+	})
+	catch = js.FuncOf(func(this js.Value, args []js.Value) any {
+		then.Release()
+		catch.Release()
+		go done("", errors.New("cannot read the clipboard: "+args[0].Call("toString").String()))
+		return nil
+	})
+	clipboard.Call("readText").Call("then", then).Call("catch", catch)
+}
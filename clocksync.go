@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// how long one tick of a match lasts, at ebiten's default 60 ticks a second
+const frameDuration = time.Second / 60
+
+// players ask the host for the time a few times in quick succession to get a first
+// estimate of the offset, and every couple of seconds after that to follow drift
+const (
+	clockSyncBurst         = 8
+	clockSyncBurstInterval = 100 * time.Millisecond
+	clockSyncInterval      = 2 * time.Second
+)
+
+// samples kept for estimating drift, and how many of the newest the offset is picked from
+const (
+	clockSamples       = 32
+	clockFilterSamples = 8
+)
+
+// drift is only estimated once the samples span this long, before that it's mostly noise
+const minDriftSpan = 10 * time.Second
+
+// frame reports older than this say nothing about where the other side is now
+const frameReportTimeout = 3 * time.Second
+
+// whoever gets more than maxFrameAdvantage frames ahead of the other side
+// sits out one tick in every stallEvery until they are back in line
+const (
+	maxFrameAdvantage = 1.0
+	stallEvery        = 10
+)
+
+// the host's clock is the match clock, so how far ahead it is of ours gets estimated
+// with NTP style exchanges: t0 we send, t1 the host receives, t2 the host replies, t3 we receive
+type clockSample struct {
+	// local time halfway through the exchange
+	at     time.Time
+	offset time.Duration
+	rtt    time.Duration
+}
+
+type clockSync struct {
+	mu      sync.Mutex
+	samples []clockSample
+
+	// the host's clock is offset+drift*(now-ref) ahead of ours
+	offset time.Duration
+	drift  float64
+	ref    time.Time
+}
+
+// the host's clock as seen by players, the host itself never uses it
+var hostClock clockSync
+
+// where a peer's match was at some point on the host's clock
+type frameReport struct {
+	frame int32
+	at    time.Time
+}
+
+var (
+	// the latest frame reports from the other side, by player id
+	// players only ever have the host's, the host has every player's
+	frameReports = make(map[int]frameReport)
+	// when the match started, on the host's clock
+	matchStartAt time.Time
+	// guards frameReports and matchStartAt
+	framesMutex sync.Mutex
+)
+
+// forgets everything learned about the last host's clock
+func (s *clockSync) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = nil
+	s.offset = 0
+	s.drift = 0
+	s.ref = time.Time{}
+}
+
+// adds the result of one exchange, all times in unix nanoseconds
+func (s *clockSync) addSample(t0, t1, t2, t3 int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample := clockSample{
+		at:     time.Unix(0, t0+(t3-t0)/2),
+		offset: time.Duration(((t1 - t0) + (t2 - t3)) / 2),
+		rtt:    time.Duration((t3 - t0) - (t2 - t1)),
+	}
+	s.samples = append(s.samples, sample)
+	if len(s.samples) > clockSamples {
+		s.samples = s.samples[len(s.samples)-clockSamples:]
+	}
+
+	// the exchange with the shortest round trip had the least room for asymmetric delays
+	recent := s.samples[max(0, len(s.samples)-clockFilterSamples):]
+	best := recent[0]
+	for _, sample := range recent[1:] {
+		if sample.rtt < best.rtt {
+			best = sample
+		}
+	}
+	s.offset = best.offset
+	s.ref = best.at
+	s.drift = s.estimateDrift()
+}
+
+// the slope of the offsets over time, by least squares over the best half of the samples
+// s.mu must be held
+func (s *clockSync) estimateDrift() float64 {
+	if len(s.samples) < 4 || s.samples[len(s.samples)-1].at.Sub(s.samples[0].at) < minDriftSpan {
+		return 0
+	}
+	samples := append([]clockSample(nil), s.samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i].rtt < samples[j].rtt })
+	samples = samples[:len(samples)/2]
+
+	origin := s.samples[0].at
+	var sumX, sumY, sumXX, sumXY float64
+	for _, sample := range samples {
+		x := sample.at.Sub(origin).Seconds()
+		y := sample.offset.Seconds()
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	n := float64(len(samples))
+	denominator := n*sumXX - sumX*sumX
+	if math.Abs(denominator) < 1e-9 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+// our best guess at what the host's clock says right now
+func (s *clockSync) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	local := time.Now()
+	if s.ref.IsZero() {
+		return local
+	}
+	drift := time.Duration(s.drift * float64(local.Sub(s.ref)))
+	return local.Add(s.offset + drift)
+}
+
+// the estimated offset, drift in parts per million, and the round trip of the best recent sample
+func (s *clockSync) stats() (time.Duration, float64, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rtt time.Duration
+	recent := s.samples[max(0, len(s.samples)-clockFilterSamples):]
+	for i, sample := range recent {
+		if i == 0 || sample.rtt < rtt {
+			rtt = sample.rtt
+		}
+	}
+	return s.offset, s.drift * 1e6, rtt
+}
+
+// the match clock, which is the host's
+func hostNow() time.Time {
+	if isHost.Load() {
+		return time.Now()
+	}
+	return hostClock.now()
+}
+
+// the frame the match should be on right now, going by the match clock
+func sharedFrame() int32 {
+	framesMutex.Lock()
+	start := matchStartAt
+	framesMutex.Unlock()
+	if start.IsZero() || isSpectator {
+		return 0
+	}
+	return int32(max(0, hostNow().Sub(start)/frameDuration))
+}
+
+func setMatchStart(at time.Time) {
+	framesMutex.Lock()
+	defer framesMutex.Unlock()
+	clear(frameReports)
+	matchStartAt = at
+}
+
+func getMatchStart() time.Time {
+	framesMutex.Lock()
+	defer framesMutex.Unlock()
+	return matchStartAt
+}
+
+// asks the host for the time until the channel goes away, players only
+func clockSyncLoop(ctx context.Context, c *peerChannel) {
+	hostClock.reset()
+	for i := 0; ; i++ {
+		interval := clockSyncInterval
+		if i < clockSyncBurst {
+			interval = clockSyncBurstInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		request := TimeRequestMessage{
+			SentAt:  time.Now().UnixNano(),
+			Frame:   matchClock.Load(),
+			FrameAt: hostClock.now().UnixNano(),
+		}
+		if err := c.send(messageTimeRequest, &request); err != nil {
+			return
+		}
+	}
+}
+
+// keeps track of where the other side's match is, at on the host's clock
+func reportFrame(player_id int, frame int32, at time.Time) {
+	framesMutex.Lock()
+	defer framesMutex.Unlock()
+	frameReports[player_id] = frameReport{frame, at}
+}
+
+// how many frames we are ahead of whoever is furthest behind, going by their latest reports
+// projected forward on the match clock, 0 if we don't know
+func frameAdvantage() float64 {
+	now := hostNow()
+	local := float64(matchClock.Load())
+	framesMutex.Lock()
+	defer framesMutex.Unlock()
+	advantage := 0.0
+	for _, report := range frameReports {
+		elapsed := now.Sub(report.at)
+		if elapsed > frameReportTimeout {
+			continue
+		}
+		remote := float64(report.frame) + float64(elapsed)/float64(frameDuration)
+		advantage = max(advantage, local-remote)
+	}
+	return advantage
+}
+
+// makes our clock the match clock, once we have taken over from the host
+// the match start moves from the old host's clock onto ours, and their players' reports are dropped
+func adoptHostClock() {
+	offset := hostClock.now().Sub(time.Now())
+	start := getMatchStart()
+	if !start.IsZero() {
+		start = start.Add(-offset)
+	}
+	setMatchStart(start)
+	hostClock.reset()
+}
+
+// answers a player asking for the time, host only
+func answerTimeRequest(player_id int, c *peerChannel, request TimeRequestMessage, receivedAt time.Time) error {
+	reportFrame(player_id, request.Frame, time.Unix(0, request.FrameAt))
+	response := TimeResponseMessage{
+		SentAt:     request.SentAt,
+		ReceivedAt: receivedAt.UnixNano(),
+		RepliedAt:  time.Now().UnixNano(),
+		Frame:      matchClock.Load(),
+	}
+	return c.send(messageTimeResponse, &response)
+}
+
+// takes in the host's answer to one of our requests, players only
+func handleTimeResponse(response TimeResponseMessage, receivedAt time.Time) {
+	hostClock.addSample(response.SentAt, response.ReceivedAt, response.RepliedAt, receivedAt.UnixNano())
+	reportFrame(host_player_id, response.Frame, time.Unix(0, response.RepliedAt))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// one exchange with a host whose clock is offset ahead of ours, the request taking out to get there,
+// the host processing before it replies, and the reply taking back to return
+func exchange(s *clockSync, sentAt time.Time, offset time.Duration, out time.Duration, back time.Duration, processing time.Duration) {
+	t0 := sentAt
+	t1 := t0.Add(out).Add(offset)
+	t2 := t1.Add(processing)
+	t3 := t2.Add(back).Add(-offset)
+	s.addSample(t0.UnixNano(), t1.UnixNano(), t2.UnixNano(), t3.UnixNano())
+}
+
+func TestClockSample(t *testing.T) {
+	var s clockSync
+	start := time.Now()
+	exchange(&s, start, 5*time.Second, 20*time.Millisecond, 20*time.Millisecond, time.Millisecond)
+	offset, drift, rtt := s.stats()
+	if offset != 5*time.Second {
+		t.Errorf("offset %v", offset)
+	}
+	// the time the host took to reply isn't part of the round trip
+	if rtt != 40*time.Millisecond {
+		t.Errorf("rtt %v", rtt)
+	}
+	if drift != 0 {
+		t.Errorf("drift %v after one sample", drift)
+	}
+}
+
+func TestClockPicksShortestRoundTrip(t *testing.T) {
+	var s clockSync
+	start := time.Now()
+	// delays that are lopsided throw the offset off by half the difference
+	exchange(&s, start, time.Second, 80*time.Millisecond, 10*time.Millisecond, 0)
+	exchange(&s, start.Add(100*time.Millisecond), time.Second, 15*time.Millisecond, 15*time.Millisecond, 0)
+	exchange(&s, start.Add(200*time.Millisecond), time.Second, 10*time.Millisecond, 60*time.Millisecond, 0)
+	offset, _, rtt := s.stats()
+	if offset != time.Second || rtt != 30*time.Millisecond {
+		t.Errorf("offset %v, rtt %v", offset, rtt)
+	}
+
+	// once it's older than the newest clockFilterSamples, a good sample is forgotten
+	for i := range clockFilterSamples {
+		exchange(&s, start.Add(time.Duration(i+3)*100*time.Millisecond), time.Second, 60*time.Millisecond, 40*time.Millisecond, 0)
+	}
+	offset, _, rtt = s.stats()
+	if offset != time.Second+10*time.Millisecond || rtt != 100*time.Millisecond {
+		t.Errorf("offset %v, rtt %v", offset, rtt)
+	}
+}
+
+func TestClockDrift(t *testing.T) {
+	// the host's clock gains 100 microseconds a second on ours
+	const ppm = 100
+	var s clockSync
+	start := time.Now()
+	for i := range clockSamples {
+		at := time.Duration(i) * time.Second
+		offset := time.Second + at*ppm/1e6
+		// every other exchange has a slow and lopsided trip out, which the fit should ignore
+		out := 10 * time.Millisecond
+		if i%2 == 1 {
+			out = 90 * time.Millisecond
+		}
+		exchange(&s, start.Add(at), offset, out, 10*time.Millisecond, 0)
+		if _, drift, _ := s.stats(); at < minDriftSpan && drift != 0 {
+			t.Fatalf("drift %v ppm after only %v", drift, at)
+		}
+	}
+	if _, drift, _ := s.stats(); math.Abs(drift-ppm) > 1 {
+		t.Errorf("drift %v ppm, want %v", drift, ppm)
+	}
+
+	// the host clock keeps gaining after the last sample
+	// what it should read is only known between the local times on either side of asking,
+	// the test can be held up for a while in between
+	s.mu.Lock()
+	ref, offset := s.ref, s.offset
+	s.mu.Unlock()
+	host := func(local time.Time) time.Time {
+		return local.Add(offset + time.Duration(ppm*float64(local.Sub(ref))/1e6))
+	}
+	before := time.Now()
+	got := s.now()
+	after := time.Now()
+	if got.Before(host(before).Add(-time.Millisecond)) || got.After(host(after).Add(time.Millisecond)) {
+		t.Errorf("host clock %v off", got.Sub(host(before)))
+	}
+
+	s.reset()
+	before = time.Now()
+	got = s.now()
+	after = time.Now()
+	if got.Before(before) || got.After(after) {
+		t.Errorf("host clock %v off after a reset", got.Sub(before))
+	}
+}
+
+func TestFrameAdvantage(t *testing.T) {
+	wasHost := isHost.Load()
+	isHost.Store(true)
+	defer func() {
+		isHost.Store(wasHost)
+		matchClock.Store(0)
+		setMatchStart(time.Time{})
+	}()
+
+	setMatchStart(time.Now())
+	matchClock.Store(100)
+	if advantage := frameAdvantage(); advantage != 0 {
+		t.Errorf("advantage %v with nobody reporting", advantage)
+	}
+
+	now := time.Now()
+	reportFrame(2, 95, now)
+	// half a second ago at frame 60 is frame 90 by now
+	reportFrame(3, 60, now.Add(-500*time.Millisecond))
+	// too old to say anything
+	reportFrame(4, 0, now.Add(-2*frameReportTimeout))
+	// they move on a frame for every frame's worth of time the test takes in between
+	advantage := frameAdvantage()
+	late := float64(time.Since(now)) / float64(frameDuration)
+	if advantage > 10.5 || advantage < 9.5-late {
+		t.Errorf("advantage %v, want 10 over the player furthest behind", advantage)
+	}
+
+	// nobody gets a negative advantage for being behind
+	reportFrame(3, 200, time.Now())
+	reportFrame(2, 200, time.Now())
+	if advantage := frameAdvantage(); advantage != 0 {
+		t.Errorf("advantage %v while behind", advantage)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// something that decides a fighter's input every tick, like the keyboard or the CPU
+// both sides of an offline match go through one, so the CPU plays by the same rules as a person
+// online the CPU plays a guest slot instead, see addCpuGuest
+type inputSource interface {
+	nextInput(self *Fighter, opponent *Fighter) uint8
+}
+
+// the local player's keyboard
+type keyboardSource struct{}
+
+func (keyboardSource) nextInput(self *Fighter, opponent *Fighter) uint8 {
+	return keyboardInput()
+}
+
+// how well the CPU plays
+type cpuDifficulty struct {
+	Name string
+	// how many ticks late the CPU sees what its opponent is doing
+	Reaction int
+	// chance of picking the best action rather than a random one
+	Accuracy float64
+	// chance of seeing an attack coming and blocking it
+	Blocking float64
+	// how much the CPU likes to close in and attack rather than hang back
+	Aggression float64
+}
+
+var cpuDifficulties = []cpuDifficulty{
+	{"Easy", 30, 0.5, 0.2, 0.4},
+	{"Normal", 18, 0.75, 0.5, 0.7},
+	{"Hard", 8, 0.95, 0.85, 1},
+}
+
+// what the CPU can decide to do
+type cpuAction int
+
+const (
+	cpuWait cpuAction = iota
+	cpuApproach
+	cpuRetreat
+	cpuAlign
+	cpuAttack
+	cpuBlock
+	cpuActions
+)
+
+// an action is kept for at least this many ticks, so the CPU doesn't jitter between them
+const cpuCommitFrames = 6
+
+// a CPU opponent, which picks whichever action scores best against what it can see
+type cpuPlayer struct {
+	difficulty cpuDifficulty
+	rng        *rand.Rand
+
+	// what the opponent looked like over the last few ticks, oldest first
+	seen []Fighter
+
+	action    cpuAction
+	committed int
+	lastInput uint8
+}
+
+func newCpuPlayer(difficulty cpuDifficulty, seed uint64) *cpuPlayer {
+	return &cpuPlayer{difficulty: difficulty, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (c *cpuPlayer) nextInput(self *Fighter, opponent *Fighter) uint8 {
+	// the CPU reacts to the opponent as they were Reaction ticks ago
+	c.seen = append(c.seen, *opponent)
+	if len(c.seen) > c.difficulty.Reaction+1 {
+		c.seen = c.seen[1:]
+	}
+	seen := c.seen[0]
+
+	c.committed--
+	if c.committed <= 0 && self.actionable() {
+		c.action = c.decide(self, &seen)
+		c.committed = cpuCommitFrames
+	}
+	input := c.inputFor(c.action, self, &seen)
+	c.lastInput = input
+	return input
+}
+
+// scores every action for the situation and picks one
+func (c *cpuPlayer) decide(self *Fighter, opponent *Fighter) cpuAction {
+	var scores [cpuActions]float64
+	dx := math.Abs(opponent.X - self.X)
+	dy := opponent.Y - self.Y
+	inRange := self.box(self.Move.Hitbox).Overlaps(opponent.hurtbox())
+	_, opponentActive := opponent.hitbox()
+	threatened := opponentActive || (opponent.MoveFrame > 0 && opponent.MoveFrame <= opponent.Move.Startup)
+	opponentInRange := opponent.box(opponent.Move.Hitbox).Overlaps(self.hurtbox())
+
+	scores[cpuWait] = 0.1
+	if inRange {
+		scores[cpuAttack] = 0.6 + 0.4*c.difficulty.Aggression
+		// punish an attack that's already over
+		if opponent.MoveFrame > opponent.Move.Startup+opponent.Move.Active {
+			scores[cpuAttack] += 0.5
+		}
+	} else {
+		scores[cpuApproach] = min(1, dx/300) * c.difficulty.Aggression
+	}
+	if threatened && opponentInRange {
+		scores[cpuBlock] = c.difficulty.Blocking * 1.5
+	}
+	if math.Abs(dy) > 20 {
+		scores[cpuAlign] = min(1, math.Abs(dy)/100)
+	}
+	// a losing CPU gets more careful
+	if self.Health < opponent.Health {
+		scores[cpuRetreat] = 0.3 * (1 - c.difficulty.Aggression)
+	}
+
+	if c.rng.Float64() > c.difficulty.Accuracy {
+		return cpuAction(c.rng.IntN(int(cpuActions)))
+	}
+	best := cpuWait
+	for action, score := range scores {
+		if score > scores[best] {
+			best = cpuAction(action)
+		}
+	}
+	return best
+}
+
+// turns an action into the input bits a player would press for it
+func (c *cpuPlayer) inputFor(action cpuAction, self *Fighter, opponent *Fighter) uint8 {
+	toward, away := inputRight, inputLeft
+	if opponent.X < self.X {
+		toward, away = inputLeft, inputRight
+	}
+	switch action {
+	case cpuApproach:
+		return toward
+	case cpuRetreat:
+		return away
+	case cpuAlign:
+		if opponent.Y < self.Y {
+			return inputUp
+		}
+		return inputDown
+	case cpuAttack:
+		// attacks happen on a press, so let go in between
+		if c.lastInput&inputAttack != 0 {
+			return 0
+		}
+		return inputAttack
+	case cpuBlock:
+		return inputBlock
+	}
+	return 0
+}
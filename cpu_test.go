@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+// the CPU against a dummy that only stands there, both through the same simulation
+func TestCpuFightsThroughInputs(t *testing.T) {
+	for seed, difficulty := range cpuDifficulties {
+		cpu := newCpuPlayer(difficulty, uint64(seed))
+		self := newFighter(trainingPlayerX, trainingY, jab)
+		dummy := newFighter(trainingDummyX, trainingY+60, jab)
+		dummy.Facing = -1
+		fighters := [2]*Fighter{&self, &dummy}
+
+		var last uint8
+		for range 60 * 30 {
+			input := cpu.nextInput(&self, &dummy)
+			if input >= inputBlock<<1 {
+				t.Fatalf("%s: input %08b isn't one a player can press", difficulty.Name, input)
+			}
+			// attacks only come out on a press, so holding it would get the CPU nowhere
+			if input&last&inputAttack != 0 {
+				t.Fatalf("%s: held attack", difficulty.Name)
+			}
+			last = input
+			stepFighters(fighters, [2]uint8{input, 0})
+			if dummy.Health == 0 {
+				break
+			}
+		}
+		if dummy.Health == maxHealth {
+			t.Errorf("%s: never landed a hit in 30 seconds", difficulty.Name)
+		}
+	}
+}
+
+// the CPU sees its opponent Reaction ticks late
+func TestCpuReaction(t *testing.T) {
+	difficulty := cpuDifficulties[1]
+	cpu := newCpuPlayer(difficulty, 1)
+	self := newFighter(0, 0, jab)
+	opponent := newFighter(100, 0, jab)
+	for tick := range difficulty.Reaction * 2 {
+		opponent.X = float64(tick)
+		cpu.nextInput(&self, &opponent)
+		if seen := cpu.seen[0].X; seen != float64(max(0, tick-difficulty.Reaction)) {
+			t.Fatalf("tick %d: the CPU sees x %v", tick, seen)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// every this many snapshots, the sender sends a checksum of what it put in the last one
+const checksumInterval = 10
+
+// frames of history kept for desync reports, the other side gets as many of the newest as fit in a message
+const desyncHistoryFrames = 60
+
+// one frame of our side of the match, as recorded by the match scene
+type HistoryFrame struct {
+	Frame    int32
+	Input    uint8
+	Pos_x    float64
+	Pos_y    float64
+	Remote_x float64
+	Remote_y float64
+	// stateChecksum of our gophers on this frame, which the other side checks against
+	// what our snapshot from this frame did to their game
+	Checksum uint32
+}
+
+var (
+	// the last desyncHistoryFrames frames of the match
+	desyncHistory []HistoryFrame
+	// our gophers on the newest frame in desyncHistory, which snapshots are taken from
+	latestGophers []EntityState
+	historyMutex  sync.Mutex
+
+	// set once a report has been written this match, for the match scene to say so
+	desyncReported atomic.Bool
+)
+
+// what a desync report says about one side
+type desyncSide struct {
+	Player int
+	// the snapshot the checksums disagree on, as this side sent or decoded it
+	Snapshot []EntityState
+	Checksum uint32
+	History  []HistoryFrame
+}
+
+// written to a file by both sides once their checksums disagree
+type desyncReport struct {
+	Tick   uint16
+	Frame  int32
+	Time   time.Time
+	Local  desyncSide
+	Remote desyncSide
+}
+
+// a checksum of where gophers are, in id order, for comparing the game state on both sides
+// positions are rounded the way snapshots round them, and velocities are left out,
+// since they don't go over the wire on a bad link
+func stateChecksum(gophers []EntityState) uint32 {
+	sorted := slices.Clone(gophers)
+	slices.SortFunc(sorted, func(a, b EntityState) int {
+		return compareGophers(a.gopher(), b.gopher())
+	})
+	h := fnv.New32a()
+	buf := make([]byte, 0, 13)
+	for _, gopher := range sorted {
+		buf = binary.LittleEndian.AppendUint32(buf[:0], uint32(gopher.Id))
+		buf = append(buf, gopher.Slot)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(quantize(gopher.Pos_x, positionScale, positionBits)))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(quantize(gopher.Pos_y, positionScale, positionBits)))
+		h.Write(buf)
+	}
+	return h.Sum32()
+}
+
+// records a frame of the match, with our gophers as they were on it
+func recordFrame(frame HistoryFrame, gophers []EntityState) {
+	frame.Checksum = stateChecksum(gophers)
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	desyncHistory = append(desyncHistory, frame)
+	if len(desyncHistory) > desyncHistoryFrames {
+		desyncHistory = desyncHistory[len(desyncHistory)-desyncHistoryFrames:]
+	}
+	latestGophers = gophers
+}
+
+// the frame recorded last and our gophers on it, so a snapshot and its checksum agree on the frame
+// outside of a match nothing is recorded, so they are taken as they are now
+func localFrame() (int32, []EntityState) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if len(desyncHistory) == 0 {
+		return matchClock.Load(), localEntities()
+	}
+	return desyncHistory[len(desyncHistory)-1].Frame, slices.Clone(latestGophers)
+}
+
+func recentHistory() []HistoryFrame {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	return append([]HistoryFrame(nil), desyncHistory...)
+}
+
+func resetHistory() {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	desyncHistory = nil
+	latestGophers = nil
+	desyncReported.Store(false)
+}
+
+// notes the checksum of the game state the snapshot that just went out was taken from on frame,
+// and sends it on for every checksumInterval snapshots
+func sendChecksum(c *peerChannel, frame int32, checksum uint32) error {
+	tick := c.snapshots.setChecksum(true, checksum)
+	if tick%checksumInterval != 0 {
+		return nil
+	}
+	return c.send(messageChecksum, &ChecksumMessage{tick, frame, checksum})
+}
+
+// history frames and entities are mostly their four float64s once encoded, so this many bytes
+// each is how many of them to drop at the least to get a desync message down by so much
+const (
+	historyFrameSize = 4 * 8
+	entityStateSize  = 4 * 8
+)
+
+// cuts a desync message down to fit in one message, oldest history first, then the snapshot
+func fitDesyncMessage(desync *DesyncMessage) {
+	for {
+		encoded, err := encodeMessage(messageDesync, desync)
+		if err != nil {
+			panic(err)
+		}
+		over := len(encoded) - maxMessageSize
+		switch {
+		case over <= 0:
+			return
+		case len(desync.History) > 0:
+			desync.History = desync.History[min(len(desync.History), over/historyFrameSize+1):]
+		default:
+			desync.Snapshot = desync.Snapshot[:max(0, len(desync.Snapshot)-over/entityStateSize-1)]
+		}
+	}
+}
+
+// handles checksums and desync reports from the other end of c
+func handleDesyncMessage(player_id int, c *peerChannel, message []byte) {
+	switch message[0] {
+	case messageChecksum:
+		var checksum ChecksumMessage
+		if err := decodeMessage(message, &checksum); err != nil {
+			fmt.Printf("Bad checksum from %d: %v\n", player_id, err)
+			return
+		}
+		decoded, ours, ok := c.snapshots.lookup(checksum.Tick, false)
+		if !ok || ours == checksum.Checksum || c.desynced.Swap(true) {
+			return
+		}
+		fmt.Printf("Desync with %d on tick %d, frame %d: checksum %08x, theirs %08x\n", player_id, checksum.Tick, checksum.Frame, ours, checksum.Checksum)
+		// they write their report with our history, then send theirs back for ours
+		desync := DesyncMessage{
+			Tick:     checksum.Tick,
+			Frame:    checksum.Frame,
+			Snapshot: decoded,
+			Checksum: ours,
+			History:  recentHistory(),
+		}
+		fitDesyncMessage(&desync)
+		c.send(messageDesync, &desync)
+
+	case messageDesync:
+		var desync DesyncMessage
+		if err := decodeMessage(message, &desync); err != nil {
+			fmt.Printf("Bad desync report from %d: %v\n", player_id, err)
+			return
+		}
+		// the snapshot in question was one of ours, unless this is the reply to our own report
+		sent, checksum, _ := c.snapshots.lookup(desync.Tick, !desync.Reply)
+		history := recentHistory()
+		writeDesyncReport(desyncReport{
+			Tick:   desync.Tick,
+			Frame:  desync.Frame,
+			Time:   time.Now(),
+			Local:  desyncSide{local_player_id, sent, checksum, history},
+			Remote: desyncSide{player_id, desync.Snapshot, desync.Checksum, desync.History},
+		})
+		if !desync.Reply {
+			c.desynced.Store(true)
+			reply := DesyncMessage{
+				Tick:     desync.Tick,
+				Frame:    desync.Frame,
+				Snapshot: sent,
+				Checksum: checksum,
+				History:  history,
+				Reply:    true,
+			}
+			fitDesyncMessage(&reply)
+			c.send(messageDesync, &reply)
+		}
+	}
+}
+
+// writes a report to the working directory for diffing offline
+// browsers can't write files, so there it ends up in the console
+func writeDesyncReport(report desyncReport) {
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	name := fmt.Sprintf("desync-%d-frame%d-player%d.json", report.Time.Unix(), report.Frame, report.Local.Player)
+	if err := os.WriteFile(name, encoded, 0o644); err != nil {
+		fmt.Printf("cannot write %s: %v\n%s\n", name, err, encoded)
+		return
+	}
+	fmt.Println("Desync report written to", name)
+	desyncReported.Store(true)
+}
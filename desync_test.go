@@ -0,0 +1,103 @@
+package main
+
+import "testing"
+
+func TestStateChecksum(t *testing.T) {
+	gophers := []EntityState{{Id: 1, Pos_x: 100, Pos_y: 200, Vel_x: 1}, {Id: 257, Pos_x: 300.5, Pos_y: 200}}
+	checksum := stateChecksum(gophers)
+
+	same := []struct {
+		name    string
+		gophers []EntityState
+	}{
+		{"other order", []EntityState{gophers[1], gophers[0]}},
+		// velocities are left out on a bad link, so they don't count
+		{"other velocity", []EntityState{{Id: 1, Pos_x: 100, Pos_y: 200}, gophers[1]}},
+		// what's lost rounding for a snapshot doesn't count either
+		{"rounded", []EntityState{{Id: 1, Pos_x: 100.01, Pos_y: 199.99}, gophers[1]}},
+	}
+	for _, test := range same {
+		if got := stateChecksum(test.gophers); got != checksum {
+			t.Errorf("%s: checksum %08x, want %08x", test.name, got, checksum)
+		}
+	}
+
+	different := []struct {
+		name    string
+		gophers []EntityState
+	}{
+		{"moved a step", []EntityState{{Id: 1, Pos_x: 100 + 1.0/positionScale, Pos_y: 200}, gophers[1]}},
+		{"other id", []EntityState{{Id: 2, Pos_x: 100, Pos_y: 200}, gophers[1]}},
+		{"swapped", []EntityState{{Id: 1, Pos_x: 300.5, Pos_y: 200}, {Id: 257, Pos_x: 100, Pos_y: 200}}},
+		{"missing one", gophers[:1]},
+	}
+	for _, test := range different {
+		if got := stateChecksum(test.gophers); got == checksum {
+			t.Errorf("%s: checksum didn't change", test.name)
+		}
+	}
+}
+
+// the state a snapshot was taken from and the state it ends up in on the other side checksum the same
+func TestStateChecksumAcrossSnapshot(t *testing.T) {
+	var sender, receiver snapshotCodec
+	gophers := []EntityState{{Id: 1, Pos_x: 100.3, Pos_y: 200.77, Vel_x: 0.4}, {Id: 2, Pos_x: -30.01, Pos_y: 12}}
+	snapshot := sender.encode(gophers)
+	tick := sender.setChecksum(true, stateChecksum(gophers))
+	decoded, err := receiver.decode(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receiver.setChecksum(false, stateChecksum(decoded)); got != tick {
+		t.Fatalf("decoded tick %d, sent %d", got, tick)
+	}
+	_, theirs, _ := sender.lookup(tick, true)
+	_, ours, ok := receiver.lookup(tick, false)
+	if !ok || ours != theirs {
+		t.Errorf("checksum %08x, theirs %08x", ours, theirs)
+	}
+}
+
+func TestFitDesyncMessage(t *testing.T) {
+	history := make([]HistoryFrame, desyncHistoryFrames)
+	for i := range history {
+		history[i] = HistoryFrame{Frame: int32(1000 + i), Input: inputLeft, Pos_x: 123.456, Pos_y: 654.321, Remote_x: 1e6, Remote_y: -1e6, Checksum: 0xdeadbeef}
+	}
+	snapshot := make([]EntityState, 255)
+	for i := range snapshot {
+		snapshot[i] = EntityState{Id: 1 << 20, Pos_x: 1.5, Pos_y: 2.5, Vel_x: 3.5, Vel_y: 4.5}
+	}
+
+	tests := []struct {
+		name     string
+		snapshot []EntityState
+		// whether all of the history should make it
+		whole bool
+	}{
+		{"small snapshot", snapshot[:4], true},
+		{"big snapshot", snapshot[:64], false},
+		{"huge snapshot", snapshot, false},
+	}
+	for _, test := range tests {
+		desync := DesyncMessage{Tick: 1, Frame: 1059, Snapshot: test.snapshot, Checksum: 1, History: history}
+		fitDesyncMessage(&desync)
+		encoded, err := encodeMessage(messageDesync, &desync)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encoded) > maxMessageSize {
+			t.Errorf("%s: %d bytes", test.name, len(encoded))
+		}
+		if test.whole && len(desync.History) != len(history) {
+			t.Errorf("%s: cut down to %d frames", test.name, len(desync.History))
+		}
+		// whatever is left is the newest
+		if n := len(desync.History); n > 0 && desync.History[n-1] != history[len(history)-1] {
+			t.Errorf("%s: history ends on frame %d", test.name, desync.History[n-1].Frame)
+		}
+		var decoded DesyncMessage
+		if err := decodeMessage(encoded, &decoded); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/ebitenui/ebitenui/input"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// the size everything is drawn at, whatever the size of the window or the browser's canvas
+// the world doesn't depend on it either, the camera decides how much of the world fits in it
+const (
+	logicalWidth  = 640
+	logicalHeight = 480
+)
+
+// how the logical screen is blown up to fill the window
+type scaleMode int
+
+const (
+	// as big as fits, with black bars along the sides that don't
+	scaleFit scaleMode = iota
+	// the biggest whole multiple that fits, so every pixel comes out the same size
+	scaleInteger
+	scaleModes
+)
+
+var scaleModeNames = []string{"Fit", "Integer"}
+
+var screenScale = scaleFit
+
+// the cursor ebitenui reads while scaling to whole multiples
+var integerCursor = &scaledCursor{fitScale: 1, scale: 1}
+
+func setScaleMode(mode scaleMode) {
+	screenScale = mode
+	// Ebitengine maps the cursor as if the screen was scaled to fit, which is only right in that mode
+	if mode == scaleInteger {
+		input.SetCursorUpdater(integerCursor)
+	} else {
+		input.SetCursorUpdater(nil)
+	}
+}
+
+// F11 or Alt+Enter, in any scene
+func updateFullscreen() {
+	altEnter := ebiten.IsKeyPressed(ebiten.KeyAlt) && inpututil.IsKeyJustPressed(ebiten.KeyEnter)
+	if inpututil.IsKeyJustPressed(ebiten.KeyF11) || altEnter {
+		ebiten.SetFullscreen(!ebiten.IsFullscreen())
+	}
+}
+
+// DrawFinalScreen implements ebiten.FinalScreenDrawer
+// geoM is Ebitengine's own scale to fit, which is all the fit mode needs
+func (g *Game) DrawFinalScreen(screen ebiten.FinalScreen, offscreen *ebiten.Image, geoM ebiten.GeoM) {
+	screen.Fill(color.Black)
+	op := &ebiten.DrawImageOptions{}
+	if screenScale == scaleFit {
+		op.GeoM = geoM
+		op.Filter = ebiten.FilterLinear
+		screen.DrawImage(offscreen, op)
+		return
+	}
+
+	fit := geoM.Element(0, 0)
+	scale := math.Floor(fit)
+	// a window smaller than the logical screen can't fit a whole multiple of it
+	if scale < 1 {
+		scale = fit
+	}
+	bounds := screen.Bounds()
+	x := math.Floor((float64(bounds.Dx()) - logicalWidth*scale) / 2)
+	y := math.Floor((float64(bounds.Dy()) - logicalHeight*scale) / 2)
+	op.GeoM.Scale(scale, scale)
+	op.GeoM.Translate(x, y)
+	op.Filter = ebiten.FilterNearest
+	screen.DrawImage(offscreen, op)
+
+	integerCursor.fitScale, integerCursor.fitX, integerCursor.fitY = fit, geoM.Element(0, 2), geoM.Element(1, 2)
+	integerCursor.scale, integerCursor.x, integerCursor.y = scale, x, y
+}
+
+// an ebitenui cursor that knows the logical screen was drawn at a whole multiple,
+// not where Ebitengine thinks it was
+type scaledCursor struct {
+	// how Ebitengine thinks the logical screen was drawn
+	fitScale float64
+	fitX     float64
+	fitY     float64
+	// how it actually was
+	scale float64
+	x     float64
+	y     float64
+
+	cursorX int
+	cursorY int
+	// by button, for the left, right and middle ones
+	pressed     [3]bool
+	justPressed [3]bool
+}
+
+// moves a point from where Ebitengine put it onto where it really is on the logical screen
+func (c *scaledCursor) unscale(x int, y int) (int, int) {
+	screenX := float64(x)*c.fitScale + c.fitX
+	screenY := float64(y)*c.fitScale + c.fitY
+	return int((screenX - c.x) / c.scale), int((screenY - c.y) / c.scale)
+}
+
+func (c *scaledCursor) Update() {
+	// ebitenui's own handler also reads the keyboard and text input, so it still has to run
+	input.SetCursorUpdater(nil)
+	input.Update()
+	input.SetCursorUpdater(c)
+
+	var pressed [3]bool
+	x, y := ebiten.CursorPosition()
+	// a touch counts as the left button
+	if touches := ebiten.AppendTouchIDs(nil); len(touches) > 0 {
+		x, y = ebiten.TouchPosition(touches[0])
+		pressed[ebiten.MouseButtonLeft] = true
+	} else {
+		for button := range pressed {
+			pressed[button] = ebiten.IsMouseButtonPressed(ebiten.MouseButton(button))
+		}
+	}
+	for i := range pressed {
+		c.justPressed[i] = pressed[i] && !c.pressed[i]
+	}
+	c.pressed = pressed
+	c.cursorX, c.cursorY = c.unscale(x, y)
+}
+
+func (c *scaledCursor) Draw(screen *ebiten.Image) {}
+
+func (c *scaledCursor) AfterDraw(screen *ebiten.Image) {
+	// lets ebitenui's own handler forget the text typed this frame
+	input.SetCursorUpdater(nil)
+	input.AfterDraw(screen)
+	input.SetCursorUpdater(c)
+}
+
+func (c *scaledCursor) MouseButtonPressed(b ebiten.MouseButton) bool {
+	return int(b) < len(c.pressed) && c.pressed[b]
+}
+
+func (c *scaledCursor) MouseButtonJustPressed(b ebiten.MouseButton) bool {
+	return int(b) < len(c.justPressed) && c.justPressed[b]
+}
+
+func (c *scaledCursor) CursorPosition() (int, int) {
+	return c.cursorX, c.cursorY
+}
+
+// the system cursor is used as it is
+func (c *scaledCursor) GetCursorImage(name string) *ebiten.Image {
+	return nil
+}
+
+func (c *scaledCursor) GetCursorOffset(name string) image.Point {
+	return image.Point{}
+}
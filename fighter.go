@@ -0,0 +1,211 @@
+package main
+
+import (
+	"image"
+)
+
+// bits of a fighter's input on one tick
+const (
+	inputUp uint8 = 1 << iota
+	inputDown
+	inputLeft
+	inputRight
+	inputAttack
+	inputBlock
+)
+
+// an attack, with its timing in ticks and its hitbox as if facing right
+// relative to the fighter's top left corner
+type Move struct {
+	Name     string
+	Startup  int
+	Active   int
+	Recovery int
+	Hitbox   image.Rectangle
+	Damage   int
+	// how long the fighter that got hit, or blocked, can't act
+	Hitstun   int
+	Blockstun int
+}
+
+func (m Move) totalFrames() int {
+	return m.Startup + m.Active + m.Recovery
+}
+
+// frame advantage when the move connects on its first active frame and leaves the defender stuck for stun
+func (m Move) advantage(stun int) int {
+	return stun - (m.Active - 1 + m.Recovery)
+}
+
+var (
+	jab      = Move{"Jab", 4, 3, 8, image.Rect(170, 80, 260, 130), 5, 14, 10}
+	heavyJab = Move{"Heavy Jab", 8, 4, 16, image.Rect(170, 60, 280, 140), 12, 22, 12}
+	quickJab = Move{"Quick Jab", 3, 2, 7, image.Rect(170, 90, 240, 125), 3, 11, 8}
+)
+
+// where a gopher can be hit, relative to its top left corner
+var hurtbox = image.Rect(40, 30, 200, 230)
+
+const maxHealth = 100
+
+// one gopher in the simulation, the same for every kind of match
+type Fighter struct {
+	X, Y float64
+	// 1 facing right, -1 facing left
+	Facing int
+	Health int
+	Move   Move
+	// ticks into Move, 0 when not attacking
+	MoveFrame int
+	// whether Move has already connected, so it only does once
+	Connected bool
+	Hitstun   int
+	Blockstun int
+	Blocking  bool
+	// the input on the last tick, to tell presses from holds
+	LastInput uint8
+}
+
+func newFighter(x, y float64, move Move) Fighter {
+	return Fighter{X: x, Y: y, Facing: 1, Health: maxHealth, Move: move}
+}
+
+// whether the fighter can move, attack or block on this tick
+func (f *Fighter) actionable() bool {
+	return f.MoveFrame == 0 && f.Hitstun == 0 && f.Blockstun == 0
+}
+
+// the fighter's hurtbox in stage coordinates
+func (f *Fighter) hurtbox() image.Rectangle {
+	return f.box(hurtbox)
+}
+
+// the hitbox of the move, while it's active
+func (f *Fighter) hitbox() (image.Rectangle, bool) {
+	if f.MoveFrame <= f.Move.Startup || f.MoveFrame > f.Move.Startup+f.Move.Active {
+		return image.Rectangle{}, false
+	}
+	return f.box(f.Move.Hitbox), true
+}
+
+// a box relative to the fighter, mirrored when facing left
+func (f *Fighter) box(r image.Rectangle) image.Rectangle {
+	width := img.Bounds().Dx()
+	if f.Facing < 0 {
+		r = image.Rect(width-r.Max.X, r.Min.Y, width-r.Min.X, r.Max.Y)
+	}
+	return r.Add(image.Pt(int(f.X), int(f.Y)))
+}
+
+// what happened when a move connected, for the frame data readout
+type hitResult struct {
+	Move    Move
+	Blocked bool
+	// ticks the defender is stuck for minus ticks the attacker still has to go
+	Advantage int
+	// where the hitbox met the hurtbox
+	Contact image.Rectangle
+}
+
+// how many more ticks the fighter has to sit through before it can act again
+func (f *Fighter) busyFor() int {
+	if f.MoveFrame > 0 {
+		return f.Move.totalFrames() - f.MoveFrame
+	}
+	return f.Hitstun + f.Blockstun
+}
+
+// advances a fighter by one tick on its own input, without anyone to fight
+func advanceFighter(f *Fighter, input uint8) {
+	pressed := input &^ f.LastInput
+	f.LastInput = input
+	f.Blocking = false
+
+	switch {
+	case f.Hitstun > 0:
+		f.Hitstun--
+	case f.Blockstun > 0:
+		f.Blockstun--
+		f.Blocking = true
+	case f.MoveFrame > 0:
+		f.MoveFrame++
+	case pressed&inputAttack != 0:
+		f.MoveFrame = 1
+		f.Connected = false
+	case input&inputBlock != 0:
+		f.Blocking = true
+	default:
+		if input&inputUp != 0 {
+			f.Y -= 1
+		}
+		if input&inputDown != 0 {
+			f.Y += 1
+		}
+		if input&inputLeft != 0 {
+			f.X -= 1
+		}
+		if input&inputRight != 0 {
+			f.X += 1
+		}
+	}
+	// that was the move's last frame, so the next tick is free
+	if f.MoveFrame >= f.Move.totalFrames() {
+		f.MoveFrame = 0
+	}
+}
+
+// advances two fighters by one tick against each other
+// both go from where they were on the last tick, so it makes no difference which one is first,
+// and two moves connecting on the same tick trade
+// returns what each one's move did, and whether it connected
+func stepFighters(fighters [2]*Fighter, inputs [2]uint8) ([2]hitResult, [2]bool) {
+	before := [2]Fighter{*fighters[0], *fighters[1]}
+	for i, f := range fighters {
+		advanceFighter(f, inputs[i])
+	}
+
+	// turn to face the other gopher, but not in the middle of something
+	for i, f := range fighters {
+		if !f.actionable() {
+			continue
+		}
+		if before[1-i].X < f.X {
+			f.Facing = -1
+		} else {
+			f.Facing = 1
+		}
+	}
+
+	var results [2]hitResult
+	var hits [2]bool
+	for i, f := range fighters {
+		other := fighters[1-i]
+		hitbox, active := f.hitbox()
+		if active && !f.Connected && hitbox.Overlaps(other.hurtbox()) {
+			hits[i] = true
+			results[i] = hitResult{Move: f.Move, Blocked: other.Blocking, Contact: hitbox.Intersect(other.hurtbox())}
+		}
+	}
+
+	for i, f := range fighters {
+		if !hits[i] {
+			continue
+		}
+		other := fighters[1-i]
+		f.Connected = true
+		other.MoveFrame = 0
+		if results[i].Blocked {
+			other.Blockstun = f.Move.Blockstun
+			continue
+		}
+		other.Hitstun = f.Move.Hitstun
+		other.Health = max(0, other.Health-f.Move.Damage)
+	}
+	// the defender's first free tick against the attacker's, once both moves are in
+	for i := range fighters {
+		if hits[i] {
+			results[i].Advantage = fighters[1-i].busyFor() - fighters[i].busyFor()
+		}
+	}
+	return results, hits
+}
@@ -0,0 +1,140 @@
+package main
+
+import "testing"
+
+// frame data worked out by hand from each move's startup, active, recovery and stun
+var moveTests = []struct {
+	move    Move
+	onHit   int
+	onBlock int
+}{
+	// 15 frames, hits on the 5th, 10 to go against 14 of hitstun and 10 of blockstun
+	{jab, 4, 0},
+	// 28 frames, hits on the 9th, 19 to go against 22 and 12
+	{heavyJab, 3, -7},
+	// 12 frames, hits on the 4th, 8 to go against 11 and 8
+	{quickJab, 3, 0},
+}
+
+func TestMoveAdvantage(t *testing.T) {
+	for _, test := range moveTests {
+		if got := test.move.advantage(test.move.Hitstun); got != test.onHit {
+			t.Errorf("%s: %+d on hit, want %+d", test.move.Name, got, test.onHit)
+		}
+		if got := test.move.advantage(test.move.Blockstun); got != test.onBlock {
+			t.Errorf("%s: %+d on block, want %+d", test.move.Name, got, test.onBlock)
+		}
+	}
+}
+
+// a fighter doing its move on its own is stuck for exactly as many ticks as the move has frames
+func TestMoveLength(t *testing.T) {
+	for _, test := range moveTests {
+		f := newFighter(0, 0, test.move)
+		advanceFighter(&f, inputAttack)
+		stuck := 1
+		for !f.actionable() {
+			advanceFighter(&f, 0)
+			stuck++
+		}
+		if stuck != test.move.totalFrames() {
+			t.Errorf("%s: stuck for %d ticks, want %d", test.move.Name, stuck, test.move.totalFrames())
+		}
+	}
+}
+
+// one fighter attacks the other, which stands or blocks, and the ticks until each can act again are counted
+// returns the advantage stepFighters reported and the one counted
+func exchangeBlows(t *testing.T, move Move, attacker int, blocked bool) (int, int) {
+	t.Helper()
+	a := newFighter(0, trainingY, move)
+	b := newFighter(150, trainingY, move)
+	b.Facing = -1
+	fighters := [2]*Fighter{&a, &b}
+	var inputs [2]uint8
+	inputs[attacker] = inputAttack
+	if blocked {
+		inputs[1-attacker] = inputBlock
+	}
+
+	reported := 0
+	for tick := 1; ; tick++ {
+		results, hits := stepFighters(fighters, inputs)
+		inputs[attacker] = 0
+		if hits[1-attacker] {
+			t.Fatalf("%s: the defender hit back", move.Name)
+		}
+		if hits[attacker] {
+			if tick != move.Startup+1 {
+				t.Fatalf("%s: connected on tick %d, want %d", move.Name, tick, move.Startup+1)
+			}
+			if results[attacker].Blocked != blocked {
+				t.Fatalf("%s: blocked %v", move.Name, results[attacker].Blocked)
+			}
+			reported = results[attacker].Advantage
+			break
+		}
+		if tick > move.totalFrames() {
+			t.Fatalf("%s: never connected", move.Name)
+		}
+	}
+
+	// the first tick after the hit that each of them can act on
+	var free [2]int
+	for tick := 1; free[0] == 0 || free[1] == 0; tick++ {
+		for i, f := range fighters {
+			if free[i] == 0 && f.actionable() {
+				free[i] = tick
+			}
+		}
+		stepFighters(fighters, inputs)
+	}
+	return reported, free[1-attacker] - free[attacker]
+}
+
+func TestFrameAdvantageOnHit(t *testing.T) {
+	for _, test := range moveTests {
+		// which side is stepped first makes no difference
+		for attacker := range 2 {
+			reported, counted := exchangeBlows(t, test.move, attacker, false)
+			if reported != test.onHit || counted != test.onHit {
+				t.Errorf("%s from side %d: reported %+d, counted %+d, want %+d", test.move.Name, attacker, reported, counted, test.onHit)
+			}
+		}
+	}
+}
+
+func TestFrameAdvantageOnBlock(t *testing.T) {
+	for _, test := range moveTests {
+		for attacker := range 2 {
+			reported, counted := exchangeBlows(t, test.move, attacker, true)
+			if reported != test.onBlock || counted != test.onBlock {
+				t.Errorf("%s from side %d: reported %+d, counted %+d, want %+d", test.move.Name, attacker, reported, counted, test.onBlock)
+			}
+		}
+	}
+}
+
+func TestTrade(t *testing.T) {
+	a := newFighter(0, trainingY, jab)
+	b := newFighter(150, trainingY, jab)
+	b.Facing = -1
+	fighters := [2]*Fighter{&a, &b}
+	for tick := 1; tick <= jab.Startup+1; tick++ {
+		results, hits := stepFighters(fighters, [2]uint8{inputAttack, inputAttack})
+		if tick < jab.Startup+1 {
+			continue
+		}
+		if !hits[0] || !hits[1] {
+			t.Fatalf("hits %v, both should connect", hits)
+		}
+		for i, result := range results {
+			if result.Advantage != 0 {
+				t.Errorf("side %d: %+d on a trade", i, result.Advantage)
+			}
+		}
+	}
+	if a.Health != maxHealth-jab.Damage || b.Health != maxHealth-jab.Damage {
+		t.Errorf("health %d and %d after a trade", a.Health, b.Health)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// the HUD is drawn straight onto the logical screen, so everything here is sized off the
+// logical resolution and scales up with it
+const (
+	hudMargin    = logicalWidth / 64
+	hudRowHeight = logicalHeight / 10
+	// the space in the middle the timer sits in
+	hudTimerWidth = logicalWidth / 8
+	hudBarHeight  = logicalHeight / 40
+	hudPipRadius  = logicalHeight / 120
+
+	hudFontSize    = logicalHeight / 30
+	hudTimerSize   = logicalHeight / 16
+	hudBannerSize  = logicalHeight / 8
+	hudIconBars    = 3
+	hudIconBarSize = logicalHeight / 160
+)
+
+// a line of ebitenutil's debug text, for debug text kept clear of the HUD
+const debugLineHeight = 16
+
+const (
+	// ticks the damage trail waits after a hit before it drains, so a combo adds up in it
+	trailDelay = 30
+	// health the damage trail loses a tick once it drains
+	trailDrain = 0.5
+	// the timer turns red with this many seconds left
+	lowTimeSeconds = 10
+	// round trips in milliseconds below which a connection gets three bars, or two
+	goodPing = 80
+	okPing   = 150
+)
+
+var (
+	hudBarBack   = color.NRGBA{40, 40, 40, 200}
+	hudBarFrame  = color.NRGBA{230, 230, 230, 255}
+	hudHealth    = color.NRGBA{250, 210, 40, 255}
+	hudLowHealth = color.NRGBA{240, 70, 40, 255}
+	hudTrail     = color.NRGBA{200, 30, 30, 255}
+	hudPipEmpty  = color.NRGBA{60, 60, 60, 220}
+	hudPipWon    = color.NRGBA{250, 210, 40, 255}
+	hudTimerLow  = color.NRGBA{240, 70, 40, 255}
+	hudIconOff   = color.NRGBA{90, 90, 90, 200}
+	hudGood      = color.NRGBA{80, 220, 80, 255}
+	hudOk        = color.NRGBA{240, 200, 40, 255}
+	hudBad       = color.NRGBA{240, 70, 40, 255}
+)
+
+// one player as the HUD shows them
+type hudPlayer struct {
+	Name string
+	Tint color.Color
+	// whether they play a Fighter, without one there's no health bar or rounds won to show
+	Fighter bool
+	Health  int
+	// rounds they have won so far
+	Rounds int
+	// round trip in milliseconds, below 0 for players at this machine, who don't have a connection
+	Ping         int64
+	Disconnected bool
+}
+
+// health bars, timer, rounds, names and connections over a match
+// the first half of the players go down the left side, the rest down the right
+type Hud struct {
+	nameFace   text.Face
+	timerFace  text.Face
+	bannerFace text.Face
+
+	// per player, the health last seen, the health the damage trail shows,
+	// and how many ticks until the trail starts draining
+	health []int
+	trail  []float64
+	wait   []int
+}
+
+func newHud(g *Game) *Hud {
+	return &Hud{nameFace: g.hudFace, timerFace: g.timerFace, bannerFace: g.bannerFace}
+}
+
+// how far down the screen the HUD reaches with this many players, for anything drawn under it
+func hudHeight(players int) int {
+	return hudMargin + (players+1)/2*hudRowHeight
+}
+
+// moves the damage trails on by a tick
+func (h *Hud) update(players []hudPlayer) {
+	if len(h.trail) != len(players) {
+		h.health = make([]int, len(players))
+		h.trail = make([]float64, len(players))
+		h.wait = make([]int, len(players))
+		for i, player := range players {
+			h.health[i] = player.Health
+			h.trail[i] = float64(player.Health)
+		}
+	}
+	for i, player := range players {
+		health := float64(player.Health)
+		if player.Health < h.health[i] {
+			h.wait[i] = trailDelay
+		}
+		h.health[i] = player.Health
+		switch {
+		case health >= h.trail[i]:
+			// healed, or a new round, so there is nothing to trail
+			h.trail[i] = health
+			h.wait[i] = 0
+		case h.wait[i] > 0:
+			h.wait[i]--
+		default:
+			h.trail[i] = max(health, h.trail[i]-trailDrain)
+		}
+	}
+}
+
+// the health a player's damage trail reaches
+func (h *Hud) trailOf(i int, player hudPlayer) float64 {
+	if i < len(h.trail) {
+		return max(h.trail[i], float64(player.Health))
+	}
+	return float64(player.Health)
+}
+
+// secondsLeft below 0 leaves the timer off, round 0 the round counter,
+// and roundsToWin 0 the rounds won under each bar
+func (h *Hud) draw(screen *ebiten.Image, players []hudPlayer, secondsLeft int, round int, roundsToWin int) {
+	left := (len(players) + 1) / 2
+	for i, player := range players {
+		right := i >= left
+		row := i
+		if right {
+			row -= left
+		}
+		h.drawPlayer(screen, i, player, right, float32(hudMargin+row*hudRowHeight), roundsToWin)
+	}
+
+	if secondsLeft >= 0 {
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(logicalWidth/2, hudMargin)
+		op.PrimaryAlign = text.AlignCenter
+		if secondsLeft <= lowTimeSeconds {
+			op.ColorScale.ScaleWithColor(hudTimerLow)
+		}
+		text.Draw(screen, fmt.Sprintf("%02d", secondsLeft), h.timerFace, op)
+	}
+	if round > 0 {
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(logicalWidth/2, hudMargin+hudTimerSize*1.2)
+		op.PrimaryAlign = text.AlignCenter
+		text.Draw(screen, fmt.Sprintf("Round %d", round), h.nameFace, op)
+	}
+}
+
+// one player's row: name and connection on top, the health bar, and the rounds they have won
+// the right side is mirrored, so both bars empty towards the edges
+func (h *Hud) drawPlayer(screen *ebiten.Image, i int, player hudPlayer, right bool, y float32, roundsToWin int) {
+	width := float32(logicalWidth-hudTimerWidth)/2 - 2*hudMargin
+	outer := float32(hudMargin)
+	// which way is towards the middle of the screen
+	inwards := float32(1)
+	if right {
+		outer = logicalWidth - hudMargin
+		inwards = -1
+	}
+
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(outer), float64(y))
+	if right {
+		op.PrimaryAlign = text.AlignEnd
+	}
+	if player.Tint != nil {
+		op.ColorScale.ScaleWithColor(player.Tint)
+	}
+	text.Draw(screen, player.Name, h.nameFace, op)
+
+	nameWidth := float32(text.Advance(player.Name, h.nameFace))
+	if player.Ping >= 0 || player.Disconnected {
+		h.drawConnection(screen, outer+inwards*(nameWidth+hudMargin), y+hudFontSize, right, player)
+	}
+
+	if !player.Fighter {
+		return
+	}
+	barY := y + hudFontSize*1.4
+	// the left of the bar, whichever side it's on
+	barX := outer
+	if right {
+		barX = outer - width
+	}
+	vector.DrawFilledRect(screen, barX, barY, width, hudBarHeight, hudBarBack, false)
+	fill := func(health float64, c color.Color) {
+		length := width * float32(max(health, 0)/maxHealth)
+		x := barX
+		if right {
+			x = barX + width - length
+		}
+		vector.DrawFilledRect(screen, x, barY, length, hudBarHeight, c, false)
+	}
+	fill(h.trailOf(i, player), hudTrail)
+	health := hudHealth
+	if player.Health <= maxHealth/4 {
+		health = hudLowHealth
+	}
+	fill(float64(player.Health), health)
+	vector.StrokeRect(screen, barX, barY, width, hudBarHeight, 1, hudBarFrame, false)
+
+	// rounds won, filled in from the middle of the screen outwards
+	inner := outer + inwards*width
+	for won := range roundsToWin {
+		x := inner - inwards*float32(hudPipRadius+won*hudPipRadius*3)
+		pipY := barY + hudBarHeight + hudPipRadius*2
+		c := hudPipEmpty
+		if won < player.Rounds {
+			c = hudPipWon
+		}
+		vector.DrawFilledCircle(screen, x, pipY, hudPipRadius, c, true)
+		vector.StrokeCircle(screen, x, pipY, hudPipRadius, 1, hudBarFrame, true)
+	}
+}
+
+// signal bars for how good a player's connection is, standing on x, bottom
+// a disconnected player gets them all dimmed with a red cross over them
+func (h *Hud) drawConnection(screen *ebiten.Image, x float32, bottom float32, right bool, player hudPlayer) {
+	bars, c := 1, hudBad
+	switch {
+	case player.Disconnected:
+		bars = 0
+	case player.Ping < goodPing:
+		bars, c = hudIconBars, hudGood
+	case player.Ping < okPing:
+		bars, c = 2, hudOk
+	}
+	width := float32(hudIconBars*hudIconBarSize*2 - hudIconBarSize)
+	if right {
+		x -= width
+	}
+	for bar := range hudIconBars {
+		height := float32((bar + 1) * hudIconBarSize * 2)
+		barColor := hudIconOff
+		if bar < bars {
+			barColor = c
+		}
+		vector.DrawFilledRect(screen, x+float32(bar*hudIconBarSize*2), bottom-height, hudIconBarSize, height, barColor, false)
+	}
+	if player.Disconnected {
+		top := bottom - hudIconBars*hudIconBarSize*2
+		vector.StrokeLine(screen, x, top, x+width, bottom, 2, hudBad, true)
+		vector.StrokeLine(screen, x, bottom, x+width, top, 2, hudBad, true)
+	}
+}
+
+// a big message across the middle of the screen, like a KO or the winner
+func (h *Hud) banner(screen *ebiten.Image, message string) {
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(logicalWidth/2, logicalHeight/3)
+	op.PrimaryAlign = text.AlignCenter
+	op.SecondaryAlign = text.AlignCenter
+	// a drop shadow, so it reads over any stage
+	shadow := *op
+	shadow.GeoM.Translate(2, 2)
+	shadow.ColorScale.ScaleWithColor(color.Black)
+	text.Draw(screen, message, h.bannerFace, &shadow)
+	text.Draw(screen, message, h.bannerFace, op)
+}
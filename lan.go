@@ -0,0 +1,115 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"valorzard/gopher-combat/signaling"
+)
+
+// the LAN host's own signaling server, started the first time a LAN game is hosted
+// nil until then, and again if it couldn't start or stopped, so the next LAN game tries again
+var (
+	lanServer      net.Listener
+	lanServerMutex sync.Mutex
+)
+
+// runs a signaling server on this machine for LAN players to offer and answer through,
+// and points our own signaling at it
+func startLanServer() error {
+	lanServerMutex.Lock()
+	defer lanServerMutex.Unlock()
+	if lanServer == nil {
+		l, err := net.Listen("tcp", ":"+strconv.Itoa(lanServerPort))
+		if err != nil {
+			return fmt.Errorf("cannot start the LAN server: %w", err)
+		}
+		lanServer = l
+		go func() {
+			if err := signaling.Serve(l); err != nil {
+				fmt.Println("LAN signaling server stopped:", err)
+			}
+			lanServerMutex.Lock()
+			if lanServer == l {
+				lanServer = nil
+			}
+			lanServerMutex.Unlock()
+		}()
+	}
+	lanSignalingAddr = "127.0.0.1:" + strconv.Itoa(lanServerPort)
+	return nil
+}
+
+// broadcasts a beacon for the lobby we host every second, so LAN players can find it
+func advertiseLobby(ctx context.Context) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4bcast, Port: lanDiscoveryPort})
+	if err != nil {
+		fmt.Println("Cannot advertise the lobby:", err)
+		return
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lobbyMutex.Lock()
+		beacon := LanBeacon{
+			Game:       lanBeaconGame,
+			LobbyId:    lobby_id,
+			Name:       lobbyName,
+			HostName:   playerName,
+			Players:    len(lobby.Players),
+			MaxPlayers: maxPlayers,
+			Password:   lobbyPassword != "",
+			Port:       lanServerPort,
+		}
+		lobbyMutex.Unlock()
+		encoded, err := json.Marshal(&beacon)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := conn.Write(encoded); err != nil {
+			fmt.Println("Cannot advertise the lobby:", err)
+		}
+	}
+}
+
+// listens for beacons from LAN hosts until ctx is done
+// found is called with the host's signaling address for every beacon
+func discoverLobbies(ctx context.Context, found func(addr string, beacon LanBeacon)) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: lanDiscoveryPort})
+	if err != nil {
+		return fmt.Errorf("cannot listen for LAN lobbies: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		buffer := make([]byte, maxMessageSize)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			var beacon LanBeacon
+			if json.Unmarshal(buffer[:n], &beacon) != nil || beacon.Game != lanBeaconGame {
+				continue
+			}
+			found(net.JoinHostPort(from.IP.String(), strconv.Itoa(beacon.Port)), beacon)
+		}
+	}()
+	return nil
+}
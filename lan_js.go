@@ -0,0 +1,21 @@
+//go:build js
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// browsers can't listen on ports or send UDP broadcasts
+var errNoLan = errors.New("LAN games aren't available in the browser")
+
+func startLanServer() error {
+	return errNoLan
+}
+
+func advertiseLobby(ctx context.Context) {}
+
+func discoverLobbies(ctx context.Context, found func(addr string, beacon LanBeacon)) error {
+	return errNoLan
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// LAN hosts broadcast beacons to this UDP port
+const lanDiscoveryPort = 47777
+
+// and run their own signaling server on this TCP port
+const lanServerPort = 47778
+
+// tells our beacons apart from anything else broadcast on the port
+const lanBeaconGame = "gopher-combat"
+
+// lobbies that haven't been heard from for this long are dropped from the list
+const lanBeaconTimeout = 3 * time.Second
+
+// set while hosting or joining a LAN game, to the host's own signaling server
+var lanSignalingAddr string
+
+// what a LAN host broadcasts about their lobby
+type LanBeacon struct {
+	Game       string
+	LobbyId    string
+	Name       string
+	HostName   string
+	Players    int
+	MaxPlayers int
+	Password   bool
+	// of the host's signaling server
+	Port int
+}
+
+// a lobby found on the LAN
+type lanLobby struct {
+	addr     string
+	beacon   LanBeacon
+	lastSeen time.Time
+}
+
+// lists lobbies hosted on the local network, no signaling server needed
+type LanScene struct {
+	ui   *ebitenui.UI
+	game *Game
+
+	list       *widget.Container
+	statusText *widget.Text
+	// the description in each row of the list by lobby, see lanKey
+	rows map[string]*widget.Text
+	// lobbies in the order their rows were built
+	rowKeys []string
+
+	// stops listening for beacons
+	stop context.CancelFunc
+
+	// filled in by the goroutine listening for beacons, by lanKey
+	mu      sync.Mutex
+	lobbies map[string]*lanLobby
+	changed atomic.Bool
+	// when the list was last refreshed, to drop lobbies that went quiet
+	refreshedAt time.Time
+}
+
+func newLanScene(g *Game) *LanScene {
+	s := &LanScene{
+		game:    g,
+		lobbies: make(map[string]*lanLobby),
+		rows:    make(map[string]*widget.Text),
+	}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "LAN Games"))
+
+	s.list = widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+			widget.RowLayoutOpts.Spacing(5),
+		)),
+	)
+	rootContainer.AddChild(s.list)
+
+	rootContainer.AddChild(newButton(g, "Host LAN Game", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newHostScene(g, true))
+	}))
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Pop()
+	}))
+
+	s.statusText = newLabel(g, "")
+	rootContainer.AddChild(s.statusText)
+
+	return s
+}
+
+func (s *LanScene) Enter(g *Game) {
+	// back from a join that didn't work out
+	lanSignalingAddr = ""
+
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+	err := discoverLobbies(ctx, func(addr string, beacon LanBeacon) {
+		s.mu.Lock()
+		s.lobbies[lanKey(addr, beacon)] = &lanLobby{addr, beacon, time.Now()}
+		s.mu.Unlock()
+		s.changed.Store(true)
+	})
+	if err != nil {
+		s.statusText.Label = err.Error()
+		return
+	}
+	s.statusText.Label = "Looking for games..."
+}
+
+func (s *LanScene) Exit(g *Game) {
+	s.stop()
+}
+
+func (s *LanScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	if s.changed.Swap(false) || time.Since(s.refreshedAt) > time.Second {
+		s.refreshList()
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *LanScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *LanScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
+
+// a lobby in the list, by where its host is and its id
+func lanKey(addr string, beacon LanBeacon) string {
+	return addr + "/" + beacon.LobbyId
+}
+
+// brings the list up to date with the lobbies heard from, dropping the ones that went quiet
+// only rebuilds the rows when lobbies come or go, so clicks on them aren't lost
+func (s *LanScene) refreshList() {
+	s.refreshedAt = time.Now()
+
+	s.mu.Lock()
+	lobbies := make([]lanLobby, 0, len(s.lobbies))
+	for key, l := range s.lobbies {
+		if time.Since(l.lastSeen) > lanBeaconTimeout {
+			delete(s.lobbies, key)
+			continue
+		}
+		lobbies = append(lobbies, *l)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(lobbies, func(a, b lanLobby) int {
+		return cmp.Or(strings.Compare(a.beacon.Name, b.beacon.Name), strings.Compare(lanKey(a.addr, a.beacon), lanKey(b.addr, b.beacon)))
+	})
+
+	keys := make([]string, len(lobbies))
+	for i, l := range lobbies {
+		keys[i] = lanKey(l.addr, l.beacon)
+	}
+	if !slices.Equal(keys, s.rowKeys) {
+		s.rebuildList(keys)
+	}
+
+	for _, l := range lobbies {
+		description := fmt.Sprintf("%s (%s)  %d/%d  %s", l.beacon.Name, l.beacon.HostName, l.beacon.Players, l.beacon.MaxPlayers, l.addr)
+		if l.beacon.Password {
+			description += "  [locked]"
+		}
+		s.rows[lanKey(l.addr, l.beacon)].Label = description
+	}
+}
+
+func (s *LanScene) rebuildList(keys []string) {
+	g := s.game
+	s.list.RemoveChildren()
+	clear(s.rows)
+	s.rowKeys = keys
+
+	for _, key := range keys {
+		row := widget.NewContainer(
+			widget.ContainerOpts.Layout(widget.NewRowLayout(
+				widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+				widget.RowLayoutOpts.Spacing(15),
+			)),
+		)
+		s.rows[key] = newLabel(g, "")
+		row.AddChild(s.rows[key])
+		row.AddChild(newButton(g, "Join", func(args *widget.ButtonClickedEventArgs) {
+			// whatever the lobby last said about itself
+			s.mu.Lock()
+			l, ok := s.lobbies[key]
+			var lobby lanLobby
+			if ok {
+				lobby = *l
+			}
+			s.mu.Unlock()
+			if !ok {
+				return
+			}
+			// the host is our signaling server for as long as we're in their lobby
+			lanSignalingAddr = lobby.addr
+			if lobby.beacon.Password {
+				g.scenes.Push(newPasswordScene(g, lobby.beacon.LobbyId, false, ""))
+				return
+			}
+			if err := joinLobby(g, lobby.beacon.LobbyId, false); err != nil {
+				lanSignalingAddr = ""
+				s.statusText.Label = err.Error()
+			}
+		}))
+		s.list.AddChild(row)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// beacons that only change what a lobby says about itself keep its row, and the Join button in it
+func TestLanListKeepsRows(t *testing.T) {
+	s := newLanScene(newTestGame(t))
+	beacon := LanBeacon{Game: lanBeaconGame, LobbyId: "abc", Name: "Dojo", HostName: "gopher", Players: 1, MaxPlayers: 4}
+	s.lobbies[lanKey("10.0.0.2", beacon)] = &lanLobby{"10.0.0.2", beacon, time.Now()}
+	s.refreshList()
+	row := s.list.Children()[0]
+
+	beacon.Players = 2
+	s.lobbies[lanKey("10.0.0.2", beacon)] = &lanLobby{"10.0.0.2", beacon, time.Now()}
+	s.refreshList()
+	if len(s.list.Children()) != 1 || s.list.Children()[0] != row {
+		t.Fatal("the row was rebuilt for a lobby that was already listed")
+	}
+	if label := s.rows[lanKey("10.0.0.2", beacon)].Label; label != "Dojo (gopher)  2/4  10.0.0.2" {
+		t.Errorf("row says %q", label)
+	}
+
+	// another lobby turning up does rebuild the list
+	other := LanBeacon{Game: lanBeaconGame, LobbyId: "def", Name: "Beach", HostName: "other", Players: 1, MaxPlayers: 2, Password: true}
+	s.lobbies[lanKey("10.0.0.3", other)] = &lanLobby{"10.0.0.3", other, time.Now()}
+	s.refreshList()
+	if len(s.list.Children()) != 2 || s.rows[lanKey("10.0.0.3", other)].Label != "Beach (other)  1/2  10.0.0.3  [locked]" {
+		t.Errorf("rows %v", s.rowKeys)
+	}
+
+	// and so does one going quiet
+	s.lobbies[lanKey("10.0.0.3", other)].lastSeen = time.Now().Add(-2 * lanBeaconTimeout)
+	s.refreshList()
+	if len(s.list.Children()) != 1 || len(s.lobbies) != 1 {
+		t.Errorf("%d rows for %d lobbies after one went quiet", len(s.list.Children()), len(s.lobbies))
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the first host always takes the first slot in the lobby
+const hostPlayerId = 0
+
+// seconds counted down once everyone is ready
+const countdownSeconds = 3
+
+// a match needs at least this many players before it can start
+const minPlayersToStart = 2
+
+// a player as shown on the lobby screen
+type LobbyPlayer struct {
+	Id        int
+	Name      string
+	Ping      int64 // round trip to the host in milliseconds
+	Ready     bool
+	Character int
+	// lost their connection, the host keeps their slot for a while in case they rejoin
+	Disconnected bool
+}
+
+// everything the lobby screen shows
+// owned by the host and mirrored to every player
+type LobbyState struct {
+	// player id of the host, which changes if the host leaves and another player takes over
+	Host       int
+	Players    []LobbyPlayer
+	Stage      int
+	Spectators int32
+	// seconds until the match starts, or -1 while not everyone is ready
+	Countdown int
+}
+
+type Character struct {
+	Name string
+	Tint color.Color
+	// what the character attacks with, see fighter.go
+	Attack Move
+}
+
+type Stage struct {
+	Name       string
+	Background color.Color
+}
+
+var characters = []Character{
+	{"Gopher", color.White, jab},
+	{"Ice Gopher", color.NRGBA{140, 200, 255, 255}, heavyJab},
+	{"Fire Gopher", color.NRGBA{255, 150, 120, 255}, jab},
+	{"Shadow Gopher", color.NRGBA{120, 120, 140, 255}, quickJab},
+}
+
+var stages = []Stage{
+	{"Dojo", color.NRGBA{0x3a, 0x2a, 0x1e, 0xff}},
+	{"Rooftop", color.NRGBA{0x1e, 0x2a, 0x44, 0xff}},
+	{"Beach", color.NRGBA{0x2a, 0x6a, 0x7a, 0xff}},
+}
+
+var playerName = "Gopher"
+
+// id the signaling server gave us, the first host is always hostPlayerId
+var local_player_id = hostPlayerId
+
+// id of the player hosting the lobby we are in
+var host_player_id = hostPlayerId
+
+var (
+	lobby      = LobbyState{Countdown: -1}
+	lobbyMutex sync.Mutex
+	// channels to every player in the lobby, used by the host
+	lobby_channels = make(map[int]*peerChannel)
+	// channel to the host, used by players
+	hostChannel *peerChannel
+
+	// set whenever the lobby changes so the lobby screen knows to refresh
+	lobbyChanged atomic.Bool
+	matchStarted atomic.Bool
+	kicked       atomic.Bool
+)
+
+// shown on the main menu, for things like being kicked
+// set from the network goroutines and read from the game loop, so only through setStatus and takeStatus
+var (
+	statusMessage string
+	statusMutex   sync.Mutex
+)
+
+func setStatus(message string) {
+	statusMutex.Lock()
+	statusMessage = message
+	statusMutex.Unlock()
+}
+
+// the status message, which is cleared once shown
+func takeStatus() string {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	message := statusMessage
+	statusMessage = ""
+	return message
+}
+
+// sets up the lobby with only the host in it and starts looking after it
+func hostLobby(ctx context.Context) {
+	lobbyMutex.Lock()
+	lobby = LobbyState{
+		Host:      local_player_id,
+		Players:   []LobbyPlayer{{Id: local_player_id, Name: playerName}},
+		Countdown: -1,
+	}
+	lobbyMutex.Unlock()
+	lobbyChanged.Store(true)
+
+	go lobbyLoop(ctx)
+}
+
+// forgets everything about the lobby we were in
+func resetLobby() {
+	lobbyMutex.Lock()
+	lobby = LobbyState{Countdown: -1}
+	clear(lobby_channels)
+	hostChannel = nil
+	lastSnapshot = MatchSnapshot{}
+	clear(player_positions)
+	lobbyMutex.Unlock()
+	clearRemoteGophers()
+	lobbyChanged.Store(true)
+	matchStarted.Store(false)
+	kicked.Store(false)
+	hostLost.Store(false)
+	local_player_id = hostPlayerId
+	host_player_id = hostPlayerId
+	setMatchStart(time.Time{})
+	hostClock.reset()
+}
+
+// pings every player once a second and runs the countdown once everyone is ready
+func lobbyLoop(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+
+		lobbyMutex.Lock()
+		channels := make([]*peerChannel, 0, len(lobby_channels))
+		for _, c := range lobby_channels {
+			channels = append(channels, c)
+		}
+		lobby.Spectators = spectatorCount.Load()
+
+		start := false
+		if !matchStarted.Load() {
+			if lobbyAllReady() {
+				if lobby.Countdown < 0 {
+					lobby.Countdown = countdownSeconds
+				} else {
+					lobby.Countdown--
+				}
+				start = lobby.Countdown <= 0
+			} else {
+				lobby.Countdown = -1
+			}
+		}
+		lobbyMutex.Unlock()
+
+		for _, c := range channels {
+			c.send(messagePing, &PingMessage{now.UnixNano()})
+		}
+		broadcastLobby()
+		if matchStarted.Load() {
+			broadcastSnapshot()
+		}
+
+		if start {
+			startMatch()
+		}
+	}
+}
+
+// how often players ping the host, as often as lobbyLoop pings them
+const pingInterval = time.Second
+
+// pings the host over c until the session ends, players only
+func pingLoop(ctx context.Context, c *peerChannel) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := c.send(messagePing, &PingMessage{now.UnixNano()}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// lobbyMutex must be held
+func lobbyAllReady() bool {
+	if len(lobby.Players) < minPlayersToStart {
+		return false
+	}
+	for _, player := range lobby.Players {
+		if !player.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// lobbyMutex must be held
+func lobbyPlayer(player_id int) *LobbyPlayer {
+	for i := range lobby.Players {
+		if lobby.Players[i].Id == player_id {
+			return &lobby.Players[i]
+		}
+	}
+	return nil
+}
+
+// sends the host's copy of the lobby to every player
+func broadcastLobby() {
+	lobbyMutex.Lock()
+	state := lobby
+	state.Players = append([]LobbyPlayer(nil), lobby.Players...)
+	channels := make([]*peerChannel, 0, len(lobby_channels))
+	for _, c := range lobby_channels {
+		channels = append(channels, c)
+	}
+	lobbyMutex.Unlock()
+	lobbyChanged.Store(true)
+
+	for _, c := range channels {
+		c.send(messageLobbyState, &state)
+	}
+}
+
+// handles everything but position updates coming in on a data channel
+func handleLobbyMessage(player_id int, c *peerChannel, message []byte) {
+	// players only listen to the host, and the host only listens to players
+	if !sentBothWays(message[0]) && isHost.Load() != sentToHost(message[0]) {
+		fmt.Printf("Ignoring lobby message %d from %d\n", message[0], player_id)
+		return
+	}
+
+	var err error
+	switch message[0] {
+	case messageHello:
+		var hello HelloMessage
+		if err = decodeMessage(message, &hello); err != nil {
+			break
+		}
+		lobbyMutex.Lock()
+		lobby_channels[player_id] = c
+		if player := lobbyPlayer(player_id); player != nil {
+			// a player rejoining keeps their picks
+			player.Name = hello.Name
+			player.Disconnected = false
+		} else {
+			lobby.Players = append(lobby.Players, LobbyPlayer{Id: player_id, Name: hello.Name})
+		}
+		lobbyMutex.Unlock()
+		broadcastLobby()
+		resyncPlayer(c)
+
+	case messageReady:
+		var ready ReadyMessage
+		if err = decodeMessage(message, &ready); err != nil {
+			break
+		}
+		lobbyMutex.Lock()
+		if player := lobbyPlayer(player_id); player != nil {
+			player.Ready = ready.Ready
+		}
+		lobbyMutex.Unlock()
+		broadcastLobby()
+
+	case messageSelection:
+		var selection SelectionMessage
+		if err = decodeMessage(message, &selection); err != nil {
+			break
+		}
+		lobbyMutex.Lock()
+		if player := lobbyPlayer(player_id); player != nil && selection.Character >= 0 && selection.Character < len(characters) {
+			player.Character = selection.Character
+		}
+		lobbyMutex.Unlock()
+		broadcastLobby()
+
+	case messagePing:
+		var ping PingMessage
+		if err = decodeMessage(message, &ping); err != nil {
+			break
+		}
+		err = c.send(messagePong, &ping)
+
+	case messagePong:
+		var pong PingMessage
+		if err = decodeMessage(message, &pong); err != nil {
+			break
+		}
+		rtt := time.Since(time.Unix(0, pong.SentAt))
+		c.rtt.Store(int64(rtt))
+		if !isHost.Load() {
+			break
+		}
+		lobbyMutex.Lock()
+		if player := lobbyPlayer(player_id); player != nil {
+			player.Ping = rtt.Milliseconds()
+		}
+		lobbyMutex.Unlock()
+
+	case messageLobbyState:
+		var state LobbyState
+		if err = decodeMessage(message, &state); err != nil {
+			break
+		}
+		if state.Stage < 0 || state.Stage >= len(stages) {
+			err = fmt.Errorf("unknown stage %d", state.Stage)
+			break
+		}
+		for _, player := range state.Players {
+			if player.Character < 0 || player.Character >= len(characters) {
+				err = fmt.Errorf("unknown character %d", player.Character)
+			}
+		}
+		if err != nil {
+			break
+		}
+		lobbyMutex.Lock()
+		lobby = state
+		lobbyMutex.Unlock()
+		host_player_id = state.Host
+		lobbyChanged.Store(true)
+
+	case messageSnapshot:
+		var snapshot MatchSnapshot
+		if err = decodeMessage(message, &snapshot); err != nil {
+			break
+		}
+		lobbyMutex.Lock()
+		lastSnapshot = snapshot
+		lobbyMutex.Unlock()
+		// a rejoining player picks the match back up from the host's snapshot
+		if resyncing.Swap(false) {
+			restoreSnapshot()
+		}
+
+	case messageLeave:
+		fmt.Printf("Player %d left\n", player_id)
+		leaveLobby(player_id)
+
+	case messageTimeRequest:
+		receivedAt := time.Now()
+		var request TimeRequestMessage
+		if err = decodeMessage(message, &request); err != nil {
+			break
+		}
+		err = answerTimeRequest(player_id, c, request, receivedAt)
+
+	case messageTimeResponse:
+		receivedAt := time.Now()
+		var response TimeResponseMessage
+		if err = decodeMessage(message, &response); err != nil {
+			break
+		}
+		handleTimeResponse(response, receivedAt)
+
+	case messageKick:
+		var kick KickMessage
+		if err = decodeMessage(message, &kick); err != nil {
+			break
+		}
+		setStatus("Kicked from lobby: " + kick.Reason)
+		kicked.Store(true)
+
+	case messageStart:
+		var start StartMessage
+		if err = decodeMessage(message, &start); err != nil {
+			break
+		}
+		if start.Stage < 0 || start.Stage >= len(stages) {
+			err = fmt.Errorf("unknown stage %d", start.Stage)
+			break
+		}
+		lobbyMutex.Lock()
+		lobby.Stage = start.Stage
+		lobbyMutex.Unlock()
+		setMatchStart(time.Unix(0, start.StartAt))
+		matchStarted.Store(true)
+	}
+
+	if err != nil {
+		fmt.Printf("Bad lobby message %d from %d: %v\n", message[0], player_id, err)
+	}
+}
+
+// whether a message is one players send to the host
+func sentToHost(kind byte) bool {
+	switch kind {
+	case messageHello, messageReady, messageSelection, messageLeave, messageTimeRequest:
+		return true
+	}
+	return false
+}
+
+// whether a message is one the host and players both send, pings and their pongs,
+// since both ends time their own round trips
+func sentBothWays(kind byte) bool {
+	return kind == messagePing || kind == messagePong
+}
+
+// removes a player whose data channel has closed
+func leaveLobby(player_id int) {
+	lobbyMutex.Lock()
+	delete(lobby_channels, player_id)
+	delete(player_positions, player_id)
+	for i, player := range lobby.Players {
+		if player.Id == player_id {
+			lobby.Players = append(lobby.Players[:i], lobby.Players[i+1:]...)
+			break
+		}
+	}
+	lobbyMutex.Unlock()
+	forgetGophers(player_id)
+	broadcastLobby()
+}
+
+// players can't tell the host anything until their channel to it has opened
+var errNotConnected = errors.New("not connected to the host")
+
+// the channel to the host, nil until it opens
+func currentHostChannel() *peerChannel {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	return hostChannel
+}
+
+// marks the local player as (not) ready
+func setReady(ready bool) error {
+	if !isHost.Load() {
+		c := currentHostChannel()
+		if c == nil {
+			return errNotConnected
+		}
+		return c.send(messageReady, &ReadyMessage{ready})
+	}
+	lobbyMutex.Lock()
+	if player := lobbyPlayer(local_player_id); player != nil {
+		player.Ready = ready
+	}
+	lobbyMutex.Unlock()
+	broadcastLobby()
+	return nil
+}
+
+// picks the local player's character
+func selectCharacter(character int) error {
+	if !isHost.Load() {
+		c := currentHostChannel()
+		if c == nil {
+			return errNotConnected
+		}
+		return c.send(messageSelection, &SelectionMessage{Character: character})
+	}
+	lobbyMutex.Lock()
+	if player := lobbyPlayer(local_player_id); player != nil {
+		player.Character = character
+	}
+	lobbyMutex.Unlock()
+	broadcastLobby()
+	return nil
+}
+
+// picks the stage, host only
+func selectStage(stage int) {
+	lobbyMutex.Lock()
+	lobby.Stage = stage
+	lobbyMutex.Unlock()
+	broadcastLobby()
+}
+
+// removes a player from the lobby and hangs up on them, host only
+func kickPlayer(player_id int) {
+	lobbyMutex.Lock()
+	c := lobby_channels[player_id]
+	lobbyMutex.Unlock()
+	if c != nil {
+		c.send(messageKick, &KickMessage{"removed by the host"})
+	}
+	leaveLobby(player_id)
+
+	peersMutex.Lock()
+	pc := peer_connections[player_id]
+	peersMutex.Unlock()
+	// give the kick message a moment to arrive before hanging up
+	time.AfterFunc(500*time.Millisecond, func() {
+		if pc != nil {
+			pc.Close()
+		}
+		// relayed players have no connection of their own to close
+		if c != nil {
+			c.rw.Close()
+		}
+	})
+}
+
+// tells everyone the match is on, host only
+func startMatch() {
+	lobbyMutex.Lock()
+	stage := lobby.Stage
+	channels := make([]*peerChannel, 0, len(lobby_channels))
+	for _, c := range lobby_channels {
+		channels = append(channels, c)
+	}
+	lobbyMutex.Unlock()
+
+	startAt := time.Now()
+	setMatchStart(startAt)
+	for _, c := range channels {
+		c.send(messageStart, &StartMessage{stage, startAt.UnixNano()})
+	}
+	matchStarted.Store(true)
+}
+
+// character picked by a player, or the default one if they aren't in the lobby
+func characterOf(player_id int) Character {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	if player := lobbyPlayer(player_id); player != nil {
+		return characters[player.Character]
+	}
+	return characters[0]
+}
+
+// id of the player the remote gopher belongs to
+func remotePlayerId() int {
+	if !isHost.Load() {
+		return host_player_id
+	}
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	for _, player := range lobby.Players {
+		if player.Id != local_player_id {
+			return player.Id
+		}
+	}
+	return local_player_id
+}
+
+func currentStage() Stage {
+	return stages[currentStageIndex()]
+}
+
+func currentStageIndex() int {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	return lobby.Stage
+}
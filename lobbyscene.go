@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// the screen players sit in between hosting/joining and the match starting
+type LobbyScene struct {
+	ui *ebitenui.UI
+
+	// the widgets are built lazily by rebuildRows, which needs the game
+	game *Game
+
+	lobbyIdInput *widget.TextInput
+	playerList   *widget.Container
+	// rows in the player list by player id
+	rows map[int]*lobbyRow
+	// player ids in the order their rows were built
+	rowIds []int
+	// whether the rows were built for the host, who gets kick buttons
+	rowsForHost bool
+
+	spectatorText   *widget.Text
+	characterButton *widget.Button
+	stageButton     *widget.Button
+	startButton     *widget.Button
+	countdownText   *widget.Text
+	// anything that went wrong, like trying to get ready before the host has answered
+	statusText *widget.Text
+}
+
+// one player in the lobby's player list
+type lobbyRow struct {
+	ready     *widget.Checkbox
+	name      *widget.Text
+	character *widget.Text
+	ping      *widget.Text
+}
+
+func newLobbyScene(g *Game) *LobbyScene {
+	s := &LobbyScene{
+		game: g,
+		rows: make(map[int]*lobbyRow),
+	}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Lobby"))
+
+	// the lobby id lives in a text input so the host can copy it
+	s.lobbyIdInput = newTextInput(g, "Lobby ID", nil)
+	rootContainer.AddChild(s.lobbyIdInput)
+
+	s.playerList = widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+			widget.RowLayoutOpts.Spacing(5),
+		)),
+	)
+	rootContainer.AddChild(s.playerList)
+
+	s.spectatorText = newLabel(g, "Spectators: 0")
+	rootContainer.AddChild(s.spectatorText)
+
+	s.characterButton = newButton(g, "Character: "+characters[0].Name, func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newCharacterSelectScene(g))
+	})
+	rootContainer.AddChild(s.characterButton)
+
+	// others at this machine join the match alongside us
+	rootContainer.AddChild(newButton(g, "Local Players", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newLocalScene(g, false))
+	}))
+
+	// clicking cycles through the stages, only the host gets to pick
+	s.stageButton = newButton(g, "Stage: "+stages[0].Name, func(args *widget.ButtonClickedEventArgs) {
+		if !isHost.Load() {
+			return
+		}
+		lobbyMutex.Lock()
+		next := (lobby.Stage + 1) % len(stages)
+		lobbyMutex.Unlock()
+		selectStage(next)
+	})
+	rootContainer.AddChild(s.stageButton)
+
+	// lets the host start without waiting for everyone to be ready, but not alone
+	s.startButton = newButton(g, "Start Match", func(args *widget.ButtonClickedEventArgs) {
+		lobbyMutex.Lock()
+		enough := len(lobby.Players) >= minPlayersToStart
+		lobbyMutex.Unlock()
+		if isHost.Load() && enough {
+			startMatch()
+		}
+	})
+	rootContainer.AddChild(s.startButton)
+
+	rootContainer.AddChild(newButton(g, "Leave", func(args *widget.ButtonClickedEventArgs) {
+		leaveSession()
+		g.scenes.Reset(newMainMenuScene(g))
+	}))
+
+	s.countdownText = newLabel(g, "")
+	rootContainer.AddChild(s.countdownText)
+
+	s.statusText = newLabel(g, "")
+	rootContainer.AddChild(s.statusText)
+
+	return s
+}
+
+func (s *LobbyScene) Enter(g *Game) {
+	// things may have changed while another scene was on top
+	s.refresh()
+}
+
+func (s *LobbyScene) Exit(g *Game) {}
+
+func (s *LobbyScene) Update(g *Game) error {
+	// being kicked, or losing the host for good, sends you back to the main menu
+	if kicked.Load() || hostLost.Load() {
+		leaveSession()
+		g.scenes.Reset(newMainMenuScene(g))
+		return nil
+	}
+
+	if matchStarted.Load() {
+		g.scenes.Replace(newMatchScene(g))
+		return nil
+	}
+
+	if lobbyChanged.Swap(false) {
+		s.refresh()
+	}
+
+	s.ui.Update()
+	return nil
+}
+
+func (s *LobbyScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *LobbyScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
+
+// brings the widgets up to date with the lobby state
+// must be called from Update, ebitenui widgets aren't safe to touch from the network goroutines
+func (s *LobbyScene) refresh() {
+	lobbyMutex.Lock()
+	state := lobby
+	state.Players = append([]LobbyPlayer(nil), lobby.Players...)
+	lobbyMutex.Unlock()
+
+	ids := make([]int, len(state.Players))
+	for i, player := range state.Players {
+		ids[i] = player.Id
+	}
+	// only rebuild the rows when players come or go, so clicks on them aren't lost
+	if !slices.Equal(ids, s.rowIds) || s.rowsForHost != isHost.Load() {
+		s.rebuildRows(ids)
+	}
+
+	for _, player := range state.Players {
+		row := s.rows[player.Id]
+		readyState := widget.WidgetUnchecked
+		if player.Ready {
+			readyState = widget.WidgetChecked
+		}
+		row.ready.SetState(readyState)
+		row.name.Label = player.Name
+		row.character.Label = characters[player.Character].Name
+		if player.Id == state.Host {
+			row.ping.Label = "host"
+		} else if player.Disconnected {
+			row.ping.Label = "reconnecting"
+		} else {
+			row.ping.Label = fmt.Sprintf("%d ms", player.Ping)
+		}
+		if player.Id == local_player_id {
+			s.characterButton.Text().Label = "Character: " + characters[player.Character].Name
+		}
+	}
+
+	if s.lobbyIdInput.GetText() != lobby_id {
+		s.lobbyIdInput.SetText(lobby_id)
+	}
+	s.spectatorText.Label = fmt.Sprintf("Spectators: %d", state.Spectators)
+	s.stageButton.Text().Label = "Stage: " + stages[state.Stage].Name
+	s.stageButton.GetWidget().Disabled = !isHost.Load()
+	s.startButton.GetWidget().Disabled = len(state.Players) < minPlayersToStart
+	if isHost.Load() {
+		s.startButton.GetWidget().Visibility = widget.Visibility_Show
+	} else {
+		s.startButton.GetWidget().Visibility = widget.Visibility_Hide
+	}
+
+	switch {
+	case migrating.Load():
+		s.countdownText.Label = "The host left, waiting for a new one..."
+	case state.Countdown > 0:
+		s.countdownText.Label = fmt.Sprintf("Starting in %d...", state.Countdown)
+	case len(state.Players) < minPlayersToStart:
+		s.countdownText.Label = "Waiting for players"
+	default:
+		s.countdownText.Label = "Waiting for everyone to be ready"
+	}
+}
+
+func (s *LobbyScene) rebuildRows(ids []int) {
+	g := s.game
+	s.playerList.RemoveChildren()
+	clear(s.rows)
+	s.rowIds = ids
+	s.rowsForHost = isHost.Load()
+
+	for _, id := range ids {
+		row := &lobbyRow{}
+		rowContainer := widget.NewContainer(
+			widget.ContainerOpts.Layout(widget.NewRowLayout(
+				widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+				widget.RowLayoutOpts.Spacing(15),
+			)),
+		)
+
+		row.ready = widget.NewCheckbox(
+			widget.CheckboxOpts.ButtonOpts(
+				widget.ButtonOpts.Image(g.buttonImage),
+				widget.ButtonOpts.DisableDefaultKeys(),
+			),
+			widget.CheckboxOpts.Image(g.checkboxImage),
+			// only your own ready state is yours to change
+			widget.CheckboxOpts.StateChangedHandler(func(args *widget.CheckboxChangedEventArgs) {
+				if id != local_player_id {
+					return
+				}
+				if err := setReady(args.State == widget.WidgetChecked); err != nil {
+					s.statusText.Label = "Cannot change ready: " + err.Error()
+					// puts the checkbox back the way the lobby has it
+					lobbyChanged.Store(true)
+				}
+			}),
+		)
+		row.ready.GetWidget().Disabled = id != local_player_id
+		rowContainer.AddChild(row.ready)
+
+		row.name = newLabel(g, "")
+		rowContainer.AddChild(row.name)
+		row.character = newLabel(g, "")
+		rowContainer.AddChild(row.character)
+		row.ping = newLabel(g, "")
+		rowContainer.AddChild(row.ping)
+
+		// the host can kick anyone but themselves
+		if isHost.Load() && id != local_player_id {
+			rowContainer.AddChild(newButton(g, "Kick", func(args *widget.ButtonClickedEventArgs) {
+				kickPlayer(id)
+			}))
+		}
+
+		s.rows[id] = row
+		s.playerList.AddChild(rowContainer)
+	}
+}
+
+// lets the local player pick who they are playing as
+type CharacterSelectScene struct {
+	ui         *ebitenui.UI
+	statusText *widget.Text
+}
+
+func newCharacterSelectScene(g *Game) *CharacterSelectScene {
+	s := &CharacterSelectScene{}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Choose your character"))
+
+	grid := widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewGridLayout(
+			widget.GridLayoutOpts.Columns(len(characters)),
+			widget.GridLayoutOpts.Spacing(20, 10),
+		)),
+	)
+	rootContainer.AddChild(grid)
+
+	// a preview of every character on top of a button to pick them
+	for _, character := range characters {
+		preview := ebiten.NewImage(img.Bounds().Dx(), img.Bounds().Dy())
+		op := &ebiten.DrawImageOptions{}
+		op.ColorScale.ScaleWithColor(character.Tint)
+		preview.DrawImage(img, op)
+		grid.AddChild(widget.NewGraphic(widget.GraphicOpts.Image(preview)))
+	}
+	for i, character := range characters {
+		grid.AddChild(newButton(g, character.Name, func(args *widget.ButtonClickedEventArgs) {
+			if err := selectCharacter(i); err != nil {
+				s.statusText.Label = "Cannot pick a character: " + err.Error()
+				return
+			}
+			g.scenes.Pop()
+		}))
+	}
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Pop()
+	}))
+
+	s.statusText = newLabel(g, "")
+	rootContainer.AddChild(s.statusText)
+
+	return s
+}
+
+func (s *CharacterSelectScene) Enter(g *Game) {}
+
+func (s *CharacterSelectScene) Exit(g *Game) {}
+
+func (s *CharacterSelectScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	// the match can start, or we can get kicked, while we're still picking
+	if matchStarted.Load() || kicked.Load() || hostLost.Load() {
+		g.scenes.Pop()
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *CharacterSelectScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *CharacterSelectScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
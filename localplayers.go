@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// the keys one player at this machine plays with
+type inputBinding struct {
+	Name   string
+	Up     ebiten.Key
+	Down   ebiten.Key
+	Left   ebiten.Key
+	Right  ebiten.Key
+	Attack ebiten.Key
+	Block  ebiten.Key
+}
+
+// one per player that can share a keyboard
+var inputBindings = []inputBinding{
+	{"Arrows, Z/X", ebiten.KeyUp, ebiten.KeyDown, ebiten.KeyLeft, ebiten.KeyRight, ebiten.KeyZ, ebiten.KeyX},
+	{"WASD, F/G", ebiten.KeyW, ebiten.KeyS, ebiten.KeyA, ebiten.KeyD, ebiten.KeyF, ebiten.KeyG},
+	{"IJKL, O/U", ebiten.KeyI, ebiten.KeyK, ebiten.KeyJ, ebiten.KeyL, ebiten.KeyO, ebiten.KeyU},
+	{"Numpad 8456, 0/.", ebiten.KeyNumpad8, ebiten.KeyNumpad5, ebiten.KeyNumpad4, ebiten.KeyNumpad6, ebiten.KeyNumpad0, ebiten.KeyNumpadDecimal},
+}
+
+// reads the binding's keys into input bits
+func (b inputBinding) read() uint8 {
+	var input uint8
+	if ebiten.IsKeyPressed(b.Up) {
+		input |= inputUp
+	}
+	if ebiten.IsKeyPressed(b.Down) {
+		input |= inputDown
+	}
+	if ebiten.IsKeyPressed(b.Left) {
+		input |= inputLeft
+	}
+	if ebiten.IsKeyPressed(b.Right) {
+		input |= inputRight
+	}
+	if ebiten.IsKeyPressed(b.Attack) {
+		input |= inputAttack
+	}
+	if ebiten.IsKeyPressed(b.Block) {
+		input |= inputBlock
+	}
+	return input
+}
+
+// which gopher is which: the player whose machine it's played on, and their slot at it,
+// 0 for the player themselves and counting up from 1 for their guests
+type gopherId struct {
+	Player int
+	Slot   uint8
+}
+
+func (e EntityState) gopher() gopherId {
+	return gopherId{e.Id, e.Slot}
+}
+
+func (p PlayerPosition) gopher() gopherId {
+	return gopherId{p.Id, p.Slot}
+}
+
+// in player order, then slot order
+func compareGophers(a, b gopherId) int {
+	if a.Player != b.Player {
+		return a.Player - b.Player
+	}
+	return int(a.Slot) - int(b.Slot)
+}
+
+// someone sharing the machine with the local player, in a slot after theirs
+type localGuest struct {
+	Binding int
+	// plays the guest instead of anyone at the keyboard when set, see addCpuGuest
+	Cpu   *cpuPlayer
+	Pos_x float64
+	Pos_y float64
+	Vel_x float64
+	Vel_y float64
+}
+
+var (
+	// the local player's keys, an index into inputBindings
+	local_binding = 0
+	// everyone else playing on this machine, kept between sessions
+	local_guests []localGuest
+	// every gopher played on another machine
+	remote_gophers = make(map[gopherId]Packet)
+	gophersMutex   sync.Mutex
+)
+
+// how many people are playing on this machine
+func localPlayerCount() int {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	return 1 + len(local_guests)
+}
+
+// gophersMutex must be held
+func bindingInUse(binding int) bool {
+	if binding == local_binding {
+		return true
+	}
+	for _, guest := range local_guests {
+		if guest.Cpu == nil && guest.Binding == binding {
+			return true
+		}
+	}
+	return false
+}
+
+// gives another player at this machine the first free binding, false once they have all been taken
+func addLocalGuest() bool {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	for binding := range inputBindings {
+		if !bindingInUse(binding) {
+			slot := len(local_guests) + 1
+			local_guests = append(local_guests, localGuest{Binding: binding, Pos_x: 40 + 100*float64(slot), Pos_y: 40})
+			return true
+		}
+	}
+	return false
+}
+
+// gives the CPU a slot at this machine, on Normal, false once there are as many players here as bindings
+// it presses its inputs into the same movement as a guest at the keyboard, and its gopher goes out
+// in snapshots with theirs, so to everyone else it's just another player
+func addCpuGuest() bool {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	if 1+len(local_guests) >= len(inputBindings) {
+		return false
+	}
+	slot := len(local_guests) + 1
+	cpu := newCpuPlayer(cpuDifficulties[1], uint64(time.Now().UnixNano()))
+	local_guests = append(local_guests, localGuest{Binding: -1, Cpu: cpu, Pos_x: 40 + 100*float64(slot), Pos_y: 40})
+	return true
+}
+
+func removeLocalGuest() {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	if len(local_guests) > 0 {
+		local_guests = local_guests[:len(local_guests)-1]
+	}
+}
+
+// moves the player in a slot onto the next binding nobody else at this machine uses,
+// or the CPU in it onto the next difficulty
+func cycleBinding(slot int) {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	if slot > 0 && local_guests[slot-1].Cpu != nil {
+		cpu := local_guests[slot-1].Cpu
+		for i, difficulty := range cpuDifficulties {
+			if difficulty.Name == cpu.difficulty.Name {
+				cpu.difficulty = cpuDifficulties[(i+1)%len(cpuDifficulties)]
+				break
+			}
+		}
+		return
+	}
+	current := local_binding
+	if slot > 0 {
+		current = local_guests[slot-1].Binding
+	}
+	for i := 1; i < len(inputBindings); i++ {
+		next := (current + i) % len(inputBindings)
+		if bindingInUse(next) {
+			continue
+		}
+		if slot == 0 {
+			local_binding = next
+		} else {
+			local_guests[slot-1].Binding = next
+		}
+		return
+	}
+}
+
+func bindingOf(slot int) inputBinding {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	if slot == 0 {
+		return inputBindings[local_binding]
+	}
+	return inputBindings[local_guests[slot-1].Binding]
+}
+
+// who plays a slot, as the Local Players menu shows it
+func localPlayerLabel(slot int) string {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	if slot > 0 && local_guests[slot-1].Cpu != nil {
+		return "CPU (" + local_guests[slot-1].Cpu.difficulty.Name + ")"
+	}
+	if slot == 0 {
+		return inputBindings[local_binding].Name
+	}
+	return inputBindings[local_guests[slot-1].Binding].Name
+}
+
+// moves every guest by their own keys, or the CPU's inputs, spectators don't have any
+func stepLocalGuests() {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	for i := range local_guests {
+		guest := &local_guests[i]
+		var input uint8
+		if guest.Cpu != nil {
+			input = cpuGuestInput(i + 1)
+		} else {
+			input = inputBindings[guest.Binding].read()
+		}
+		last_x, last_y := guest.Pos_x, guest.Pos_y
+		if input&inputUp != 0 {
+			guest.Pos_y -= 1
+		}
+		if input&inputDown != 0 {
+			guest.Pos_y += 1
+		}
+		if input&inputLeft != 0 {
+			guest.Pos_x -= 1
+		}
+		if input&inputRight != 0 {
+			guest.Pos_x += 1
+		}
+		guest.Vel_x, guest.Vel_y = guest.Pos_x-last_x, guest.Pos_y-last_y
+	}
+}
+
+// what the CPU playing the guest in a slot presses, going after the nearest other gopher
+// gophers online aren't fighters yet, so it sees them all as fighters standing where they are
+// gophersMutex must be held
+func cpuGuestInput(slot int) uint8 {
+	guest := &local_guests[slot-1]
+	self := newFighter(guest.Pos_x, guest.Pos_y, jab)
+	targets := []Packet{{pos_x, pos_y}}
+	for i, other := range local_guests {
+		if i != slot-1 {
+			targets = append(targets, Packet{other.Pos_x, other.Pos_y})
+		}
+	}
+	for _, packet := range remote_gophers {
+		targets = append(targets, packet)
+	}
+	nearest := targets[0]
+	for _, target := range targets[1:] {
+		if math.Hypot(target.Pos_x-self.X, target.Pos_y-self.Y) < math.Hypot(nearest.Pos_x-self.X, nearest.Pos_y-self.Y) {
+			nearest = target
+		}
+	}
+	opponent := newFighter(nearest.Pos_x, nearest.Pos_y, jab)
+	return guest.Cpu.nextInput(&self, &opponent)
+}
+
+// the gophers played on this machine, as they go out in snapshots
+func localEntities() []EntityState {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	entities := []EntityState{{local_player_id, 0, pos_x, pos_y, vel_x, vel_y}}
+	for i, guest := range local_guests {
+		entities = append(entities, EntityState{local_player_id, uint8(i + 1), guest.Pos_x, guest.Pos_y, guest.Vel_x, guest.Vel_y})
+	}
+	return entities
+}
+
+// the guests' gophers, for drawing and replays
+func localGuestPositions() []PlayerPosition {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	positions := make([]PlayerPosition, 0, len(local_guests))
+	for i, guest := range local_guests {
+		positions = append(positions, PlayerPosition{local_player_id, uint8(i + 1), guest.Pos_x, guest.Pos_y})
+	}
+	return positions
+}
+
+func setRemoteGopher(id gopherId, x float64, y float64) {
+	gophersMutex.Lock()
+	remote_gophers[id] = Packet{x, y}
+	gophersMutex.Unlock()
+}
+
+// these gophers played on other machines, as the game has them now
+func remoteGophers(ids []gopherId) []EntityState {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	gophers := make([]EntityState, 0, len(ids))
+	for _, id := range ids {
+		if packet, ok := remote_gophers[id]; ok {
+			gophers = append(gophers, EntityState{Id: id.Player, Slot: id.Slot, Pos_x: packet.Pos_x, Pos_y: packet.Pos_y})
+		}
+	}
+	return gophers
+}
+
+// drops a player's gophers, guests included, once they have left
+func forgetGophers(owner int) {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	for id := range remote_gophers {
+		if id.Player == owner {
+			delete(remote_gophers, id)
+		}
+	}
+}
+
+func clearRemoteGophers() {
+	gophersMutex.Lock()
+	clear(remote_gophers)
+	gophersMutex.Unlock()
+}
+
+// every gopher played on another machine
+func remoteGopherPositions() []PlayerPosition {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	positions := make([]PlayerPosition, 0, len(remote_gophers))
+	for id, packet := range remote_gophers {
+		positions = append(positions, PlayerPosition{id.Player, id.Slot, packet.Pos_x, packet.Pos_y})
+	}
+	return positions
+}
+
+// players only hear from the host, so the host passes everyone else's gophers on to them
+func forwardedEntities(player_id int) []EntityState {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	var entities []EntityState
+	for id, packet := range remote_gophers {
+		if id.Player != player_id {
+			entities = append(entities, EntityState{Id: id.Player, Slot: id.Slot, Pos_x: packet.Pos_x, Pos_y: packet.Pos_y})
+		}
+	}
+	return entities
+}
+
+// every gopher played on another machine, as the host sends them on to spectators
+func remoteEntities() []EntityState {
+	gophersMutex.Lock()
+	defer gophersMutex.Unlock()
+	entities := make([]EntityState, 0, len(remote_gophers))
+	for id, packet := range remote_gophers {
+		entities = append(entities, EntityState{Id: id.Player, Slot: id.Slot, Pos_x: packet.Pos_x, Pos_y: packet.Pos_y})
+	}
+	return entities
+}
+
+// guests play the characters after their owner's, so every machine draws them the same
+func characterOfGopher(id gopherId) Character {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	character := 0
+	if player := lobbyPlayer(id.Player); player != nil {
+		character = player.Character
+	}
+	return characters[(character+int(id.Slot))%len(characters)]
+}
+
+// a match with nobody but the players at this machine, which needs no network at all
+func startLocalMatch(g *Game) {
+	leaveSession()
+	isHost.Store(true)
+	lobbyMutex.Lock()
+	lobby = LobbyState{
+		Host:      local_player_id,
+		Players:   []LobbyPlayer{{Id: local_player_id, Name: playerName}},
+		Countdown: -1,
+	}
+	lobbyMutex.Unlock()
+	setMatchStart(time.Now())
+	matchStarted.Store(true)
+	g.scenes.Push(newMatchScene(g))
+}
+
+// sets up who is playing on this machine and which keys they use
+// from the main menu it can also start a match between just them
+type LocalScene struct {
+	ui      *ebitenui.UI
+	game    *Game
+	players *widget.Container
+}
+
+func newLocalScene(g *Game, offline bool) *LocalScene {
+	s := &LocalScene{game: g}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Local Players"))
+
+	// one button per player, clicking it moves them onto the next free binding
+	s.players = widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+			widget.RowLayoutOpts.Spacing(5),
+		)),
+	)
+	rootContainer.AddChild(s.players)
+
+	rootContainer.AddChild(newButton(g, "Add Player", func(args *widget.ButtonClickedEventArgs) {
+		addLocalGuest()
+		s.rebuild()
+	}))
+
+	rootContainer.AddChild(newButton(g, "Add CPU", func(args *widget.ButtonClickedEventArgs) {
+		addCpuGuest()
+		s.rebuild()
+	}))
+
+	rootContainer.AddChild(newButton(g, "Remove Player", func(args *widget.ButtonClickedEventArgs) {
+		removeLocalGuest()
+		s.rebuild()
+	}))
+
+	if offline {
+		rootContainer.AddChild(newButton(g, "Start Match", func(args *widget.ButtonClickedEventArgs) {
+			startLocalMatch(g)
+		}))
+	}
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Pop()
+	}))
+
+	return s
+}
+
+func (s *LocalScene) rebuild() {
+	s.players.RemoveChildren()
+	for slot := range localPlayerCount() {
+		label := fmt.Sprintf("Player %d: %s", slot+1, localPlayerLabel(slot))
+		s.players.AddChild(newButton(s.game, label, func(args *widget.ButtonClickedEventArgs) {
+			cycleBinding(slot)
+			s.rebuild()
+		}))
+	}
+}
+
+func (s *LocalScene) Enter(g *Game) {
+	s.rebuild()
+}
+
+func (s *LocalScene) Exit(g *Game) {}
+
+func (s *LocalScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *LocalScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *LocalScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
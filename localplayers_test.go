@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestForgetGophers(t *testing.T) {
+	defer clearRemoteGophers()
+	// player 1 and their guest, and player 257, whose ids used to overlap
+	setRemoteGopher(gopherId{1, 0}, 10, 0)
+	setRemoteGopher(gopherId{1, 1}, 20, 0)
+	setRemoteGopher(gopherId{257, 0}, 30, 0)
+
+	forgetGophers(1)
+	positions := remoteGopherPositions()
+	if len(positions) != 1 || positions[0].gopher() != (gopherId{257, 0}) || positions[0].Pos_x != 30 {
+		t.Errorf("left with %v", positions)
+	}
+}
+
+func TestForwardedEntities(t *testing.T) {
+	defer clearRemoteGophers()
+	setRemoteGopher(gopherId{2, 0}, 10, 0)
+	setRemoteGopher(gopherId{2, 1}, 20, 0)
+	setRemoteGopher(gopherId{258, 0}, 30, 0)
+
+	// player 2 gets everyone but their own gophers back
+	forwarded := forwardedEntities(2)
+	if len(forwarded) != 1 || forwarded[0].gopher() != (gopherId{258, 0}) {
+		t.Errorf("forwarded %v to player 2", forwarded)
+	}
+	forwarded = forwardedEntities(258)
+	slices.SortFunc(forwarded, func(a, b EntityState) int {
+		return compareGophers(a.gopher(), b.gopher())
+	})
+	if len(forwarded) != 2 || forwarded[0].gopher() != (gopherId{2, 0}) || forwarded[1].gopher() != (gopherId{2, 1}) {
+		t.Errorf("forwarded %v to player 258", forwarded)
+	}
+}
+
+func TestCharacterOfGuests(t *testing.T) {
+	// nobody in the lobby, so everyone plays from the first character on
+	for slot := range uint8(len(characters) + 1) {
+		want := characters[int(slot)%len(characters)]
+		if got := characterOfGopher(gopherId{300, slot}); got.Name != want.Name {
+			t.Errorf("slot %d plays %s, want %s", slot, got.Name, want.Name)
+		}
+	}
+}
+
+// a CPU guest moves by its own inputs and goes out in snapshots with everyone else at this machine
+func TestCpuGuest(t *testing.T) {
+	gophersMutex.Lock()
+	oldGuests, oldX, oldY := local_guests, pos_x, pos_y
+	local_guests = nil
+	gophersMutex.Unlock()
+	defer func() {
+		gophersMutex.Lock()
+		local_guests, pos_x, pos_y = oldGuests, oldX, oldY
+		gophersMutex.Unlock()
+	}()
+	pos_x, pos_y = 40, 300
+
+	if !addCpuGuest() {
+		t.Fatal("no room for the CPU")
+	}
+	if label := localPlayerLabel(1); label != "CPU (Normal)" {
+		t.Errorf("slot 1 is %q", label)
+	}
+	cycleBinding(1)
+	if label := localPlayerLabel(1); label != "CPU (Hard)" {
+		t.Errorf("slot 1 is %q after a click", label)
+	}
+	start := localGuestPositions()[0]
+	for range 600 {
+		stepLocalGuests()
+	}
+	moved := localGuestPositions()[0]
+	if math.Hypot(moved.Pos_x-pos_x, moved.Pos_y-pos_y) >= math.Hypot(start.Pos_x-pos_x, start.Pos_y-pos_y) {
+		t.Errorf("the CPU went from %v to %v, no closer to us at %v, %v", start, moved, pos_x, pos_y)
+	}
+
+	// the CPU doesn't take anyone's keys
+	if !addLocalGuest() || bindingOf(2).Name != inputBindings[1].Name {
+		t.Errorf("the next guest got %q", bindingOf(2).Name)
+	}
+
+	// the other end of a snapshot sees it like any guest
+	var sender, receiver snapshotCodec
+	entities, err := receiver.decode(sender.encode(localEntities()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 3 || entities[1].gopher() != (gopherId{local_player_id, 1}) {
+		t.Fatalf("sent %v", entities)
+	}
+	if entities[1].Pos_x != moved.Pos_x || entities[1].Pos_y != moved.Pos_y {
+		t.Errorf("sent the CPU at %v, %v, it's at %v, %v", entities[1].Pos_x, entities[1].Pos_y, moved.Pos_x, moved.Pos_y)
+	}
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	//"runtime"
 
@@ -14,17 +19,18 @@ import (
 	_ "image/png"
 	"io"
 	"log"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/webrtc/v4"
+	"valorzard/gopher-combat/signaling"
+	"valorzard/gopher-combat/sound"
 
-	"github.com/ebitenui/ebitenui"
 	"github.com/ebitenui/ebitenui/image"
 	"github.com/ebitenui/ebitenui/widget"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
-	"github.com/kelindar/binary"
 
 	//"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/text/v2"
@@ -38,19 +44,46 @@ var (
 	pos_y        = 40.0
 	remote_pos_x = 40.0
 	remote_pos_y = 40.0
+	// how far our gopher moved on the last tick
+	vel_x = 0.0
+	vel_y = 0.0
 )
 
 var lobby_id string
-var isHost = false
+var isHost atomic.Bool
+var isSpectator = false
 
 var signalingIP = "127.0.0.1"
 var port = 3000
 
+// what the host tells the signaling server about their lobby
+var lobbyName = ""
+var maxPlayers = 2
+var region = "local"
+var privateLobby = false
+
+// password for the lobby we are hosting or joining, empty if there is none
+var lobbyPassword = ""
+
+// returned by startConnection when the lobby needs a (different) password
+var (
+	errPasswordRequired = errors.New("this lobby needs a password")
+	errWrongPassword    = errors.New("wrong password")
+)
+
+// host:port of the signaling server, which is the LAN host's own one in LAN mode
+func getSignalingAddr() string {
+	if lanSignalingAddr != "" {
+		return lanSignalingAddr
+	}
+	return signalingIP + ":" + strconv.Itoa(port)
+}
+
 func getSignalingURL() string {
-	return "http://" + signalingIP + ":" + strconv.Itoa(port)
+	return "http://" + getSignalingAddr()
 }
 
-// players registered by host
+// players the host is polling offers for, guarded by peersMutex
 var registered_players = make(map[int]struct{})
 
 // client to the HTTP signaling server
@@ -68,78 +101,97 @@ func init() {
 
 // implements ebiten.Game interface
 type Game struct {
-	ui         *ebitenui.UI
-	hostButton *widget.Button
-	joinButton *widget.Button
-	//This parameter is so you can keep track of the textInput widget to update and retrieve
-	//its values in other parts of your game
-	standardTextInput *widget.TextInput
+	scenes *SceneManager
+
+	// shared by every scene's UI
+	face          text.Face
+	buttonImage   *widget.ButtonImage
+	checkboxImage *widget.CheckboxGraphicImage
+
+	// shared by every scene's HUD
+	hudFace    text.Face
+	timerFace  text.Face
+	bannerFace text.Face
+
+	// the last match played, for the replay scene
+	replay *Replay
 }
 
 // Layout implements Game.
+// everything is drawn at the logical resolution, and scaled up to the window by DrawFinalScreen
 func (g *Game) Layout(outsideWidth int, outsideHeight int) (int, int) {
-	return outsideWidth, outsideHeight
+	g.scenes.Layout(logicalWidth, logicalHeight)
+	return logicalWidth, logicalHeight
 }
 
 // called every tick (default 60 times a second)
 // updates game logical state
 func (g *Game) Update() error {
-
-	if ebiten.IsKeyPressed(ebiten.KeyUp) {
-		pos_y -= 1
-	}
-
-	if ebiten.IsKeyPressed(ebiten.KeyDown) {
-		pos_y += 1
-	}
-
-	if ebiten.IsKeyPressed(ebiten.KeyLeft) {
-		pos_x -= 1
-	}
-
-	if ebiten.IsKeyPressed(ebiten.KeyRight) {
-		pos_x += 1
-	}
-
-	// update the UI
-	g.ui.Update()
-
+	updateFullscreen()
 	// if update returns non nil error, game suspends
-	return nil
+	return g.scenes.Update()
 }
 
 // called every frame, depends on the monitor refresh rate
 // which will probably be at least 60 times per second
 func (g *Game) Draw(screen *ebiten.Image) {
-	// draw the UI onto the screen
-	g.ui.Draw(screen)
-
-	// prints something on the screen
-	ebitenutil.DebugPrint(screen, fmt.Sprintf("FPS: %f", ebiten.ActualFPS()))
+	g.scenes.Draw(screen)
+}
 
-	// draw image
-	op := &ebiten.DrawImageOptions{}
-	op.GeoM.Translate(pos_x, pos_y)
-	screen.DrawImage(img, op)
+var (
+	// probably move all webrtc networking stuff to a struct i can manage
+	// connection to the host, used by players and spectators
+	// swapped out from ICE callbacks and network goroutines, so always atomically
+	peerConnection atomic.Pointer[webrtc.PeerConnection]
+	// connections to every player and spectator, used by the host
+	peer_connections = make(map[int]*webrtc.PeerConnection)
+	peersMutex       sync.Mutex
+
+	// cancelled when we leave the lobby, which stops everything polling on its behalf
+	session    = context.Background()
+	endSession = func() {}
+)
 
-	// draw remote
-	op2 := &ebiten.DrawImageOptions{}
-	op2.GeoM.Translate(remote_pos_x, remote_pos_y)
-	screen.DrawImage(img, op2)
+type PlayerData struct {
+	Id    int
+	Token string
 }
 
+// proves to the signaling server that our slot is ours, sent along with every request about it
+// the host's token also lets them read offers and post answers for everyone else
+// empty with servers that don't hand out tokens
+// read by the heartbeat goroutine while leaving or migrating changes it, so only through getToken, setToken and takeToken
 var (
-	// probably move all webrtc networking stuff to a struct i can manage
-	peerConnection *webrtc.PeerConnection
+	player_token string
+	tokenMutex   sync.Mutex
 )
 
-const messageSize = 32
+func getToken() string {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	return player_token
+}
 
-type PlayerData struct {
-	Id int
+func setToken(token string) {
+	tokenMutex.Lock()
+	player_token = token
+	tokenMutex.Unlock()
 }
 
-func startConnection(game *Game) {
+// the token, which is cleared so the slot is only given up once
+func takeToken() string {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	token := player_token
+	player_token = ""
+	return token
+}
+
+// how often we tell the signaling server we're still around
+const heartbeatInterval = 5 * time.Second
+
+// creates a PeerConnection with detached data channels and the default STUN server
+func newPeerConnection() *webrtc.PeerConnection {
 	// Since this behavior diverges from the WebRTC API it has to be
 	// enabled using a settings engine. Mixing both detached and the
 	// OnMessage DataChannel API is not supported.
@@ -168,177 +220,89 @@ func startConnection(game *Game) {
 		panic(err)
 	}
 
-	// Set the global variable to the newly created RTCPeerConnection
-	peerConnection = pc
-
-	// Set the handler for Peer connection state
-	// This will notify you when the peer has connected/disconnected
-	peerConnection.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		fmt.Printf("Peer Connection State has changed: %s\n", s.String())
-
-		if s == webrtc.PeerConnectionStateFailed {
-			// Wait until PeerConnection has had no network activity for 30 seconds or another failure. It may be reconnected using an ICE Restart.
-			// Use webrtc.PeerConnectionStateDisconnected if you are interested in detecting faster timeout.
-			// Note that the PeerConnection may come back from PeerConnectionStateDisconnected.
-			fmt.Println("Peer Connection has gone to failed exiting")
-			os.Exit(0)
-		}
-
-		if s == webrtc.PeerConnectionStateClosed {
-			// PeerConnection was explicitly closed. This usually happens from a DTLS CloseNotify
-			fmt.Println("Peer Connection has gone to closed exiting")
-			os.Exit(0)
-		}
-	})
-
 	// Set the handler for ICE connection state
 	// This will notify you when the peer has connected/disconnected
-	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
+	pc.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		fmt.Printf("ICE Connection State has changed: %s\n", connectionState.String())
 	})
 
-	// the one that gives the answer is the host
-	if isHost {
+	return pc
+}
+
+// connects to the lobby in lobby_id, or hosts a new one
+// only failures the player can do something about are returned, the rest panic
+func startConnection() error {
+	session, endSession = context.WithCancel(context.Background())
+	ctx := session
 
+	// the one that gives the answer is the host
+	if isHost.Load() {
 		// Host creates lobby
-		lobby_resp, err := httpClient.Get(getSignalingURL() + "/lobby/host")
+		hostQuery := url.Values{}
+		hostQuery.Set("name", lobbyName)
+		hostQuery.Set("host_name", playerName)
+		hostQuery.Set("max_players", strconv.Itoa(maxPlayers))
+		hostQuery.Set("region", region)
+		if privateLobby {
+			hostQuery.Set("private", "true")
+		}
+		if lobbyPassword != "" {
+			hostQuery.Set("password_hash", signaling.HashPassword(lobbyPassword))
+		}
+		lobby_resp, err := httpClient.Get(getSignalingURL() + "/lobby/host?" + hostQuery.Encode())
 		if err != nil {
-			panic(err)
+			return err
 		}
 		bodyBytes, err := io.ReadAll(lobby_resp.Body)
 		if err != nil {
 			panic(err)
 		}
-		lobby_id = string(bodyBytes)
+		if lobby_resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("cannot host lobby: %s", strings.TrimSpace(string(bodyBytes)))
+		}
+		// go-signaling-server only sends back the id, ours sends a token with it
+		var host_data signaling.HostData
+		if json.Unmarshal(bodyBytes, &host_data) == nil {
+			lobby_id = host_data.Id
+			setToken(host_data.Token)
+		} else {
+			lobby_id = string(bodyBytes)
+			setToken("")
+		}
 		fmt.Printf("Lobby ID: %s\n", lobby_id)
-		game.standardTextInput.SetText(lobby_id)
-
-		// Register data channel creation handling
-		peerConnection.OnDataChannel(func(d *webrtc.DataChannel) {
-			fmt.Printf("New DataChannel %s %d\n", d.Label(), d.ID())
-
-			// Register channel opening handling
-			d.OnOpen(func() {
-				fmt.Printf("Data channel '%s'-'%d' open.\n", d.Label(), d.ID())
-
-				// Detach the data channel
-				raw, dErr := d.Detach()
-				if dErr != nil {
-					panic(dErr)
-				}
-
-				// Handle reading from the data channel
-				go ReadLoop(raw)
-
-				// Handle writing to the data channel
-				go WriteLoop(raw)
-			})
-		})
-
-		// poll for offer from signaling server for player
-		pollForPlayerOffer := func(player_id int) {
-			ticker := time.NewTicker(1 * time.Second)
-			for {
-				select {
-				case t := <-ticker.C:
-					fmt.Println("Tick at", t)
-					fmt.Printf("Polling for offer for %d\n", player_id)
-					// hardcode that there is only one other player and they have player_id 1
-					getUrl := getSignalingURL() + "/offer/get?lobby_id=" + lobby_id + "&player_id=" + strconv.Itoa(player_id)
-					fmt.Println(getUrl)
-					offer_resp, err := httpClient.Get(getUrl)
-					if err != nil {
-						panic(err)
-					}
-					if offer_resp.StatusCode != http.StatusOK {
-						continue
-					}
-					body := new(bytes.Buffer)
-					body.ReadFrom(offer_resp.Body)
-					fmt.Printf("Got offer %v\n", body.String())
-					offer := webrtc.SessionDescription{}
-					err = json.NewDecoder(body).Decode(&offer)
-					if err != nil {
-						panic(err)
-					}
-					// Set the remote SessionDescription
-					err = peerConnection.SetRemoteDescription(offer)
-					if err != nil {
-						panic(err)
-					}
-					// Create answer
-					answer, err := peerConnection.CreateAnswer(nil)
-					if err != nil {
-						panic(err)
-					}
-
-					// Create channel that is blocked until ICE Gathering is complete
-					gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
-
-					// Sets the LocalDescription, and starts our UDP listeners
-					err = peerConnection.SetLocalDescription(answer)
-					if err != nil {
-						panic(err)
-					}
-
-					// Block until ICE Gathering is complete, disabling trickle ICE
-					// we do this because we only can exchange one signaling message
-					// in a production application you should exchange ICE Candidates via OnICECandidate
-					<-gatherComplete
-					// send answer we generated to the signaling server
-					answerJson, err := json.Marshal(peerConnection.LocalDescription())
-					if err != nil {
-						panic(err)
-					}
-					postUrl := getSignalingURL() + "/answer/post?lobby_id=" + lobby_id + "&player_id=" + strconv.Itoa(player_id)
-					fmt.Println(postUrl)
-					httpClient.Post(postUrl, "application/json", bytes.NewBuffer(answerJson))
-					// if we have successfully set the remote description, we can break out of the loop
-					ticker.Stop()
-					return
-				}
-			}
+		local_player_id = hostPlayerId
+		host_player_id = hostPlayerId
+		hostLobby(ctx)
+		go acceptPlayers(ctx)
+		go heartbeatLoop(ctx)
+		if lanSignalingAddr != "" && !privateLobby {
+			go advertiseLobby(ctx)
 		}
-
-		go func() {
-			ticker := time.NewTicker(1 * time.Second)
-			for {
-				select {
-				case t := <-ticker.C:
-					fmt.Println("Tick at", t)
-					idUrl := getSignalingURL() + "/lobby/unregisteredPlayers?id=" + lobby_id
-					fmt.Println(idUrl)
-					id_resp, err := httpClient.Get(idUrl)
-					if err != nil {
-						panic(err)
-					}
-					if id_resp.StatusCode != http.StatusOK {
-						continue
-					}
-					var player_ids []int
-					err = json.NewDecoder(id_resp.Body).Decode(&player_ids)
-					if err != nil {
-						panic(err)
-					}
-					fmt.Printf("Player IDs: %v\n", player_ids)
-					// poll for all of the unregistered players
-					for _, player_id := range player_ids {
-						// only start goroutine if player_id hasn't been registered yet
-						if _, ok := registered_players[player_id]; !ok {
-							registered_players[player_id] = struct{}{}
-							go pollForPlayerOffer(player_id)
-						}
-					}
-				}
-			}
-		}()
 	} else {
+		kicked.Store(false)
+
 		// the following is for the client joining the lobby
-		// get lobby id from text input
-		lobby_id = game.standardTextInput.GetText()
-		response, err := httpClient.Get(getSignalingURL() + "/lobby/join?id=" + lobby_id)
+		// the main menu has already filled in lobby_id
+		joinUrl := getSignalingURL() + "/lobby/join?id=" + url.QueryEscape(lobby_id)
+		if isSpectator {
+			joinUrl += "&spectate=true"
+		}
+		if lobbyPassword != "" {
+			joinUrl += "&password_hash=" + signaling.HashPassword(lobbyPassword)
+		}
+		response, err := httpClient.Get(joinUrl)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		switch response.StatusCode {
+		case http.StatusUnauthorized:
+			return errPasswordRequired
+		case http.StatusForbidden:
+			return errWrongPassword
+		}
+		if response.StatusCode != http.StatusOK {
+			reason, _ := io.ReadAll(response.Body)
+			return fmt.Errorf("cannot join lobby %s: %s", lobby_id, strings.TrimSpace(string(reason)))
 		}
 		var player_data PlayerData
 		err = json.NewDecoder(response.Body).Decode(&player_data)
@@ -346,109 +310,576 @@ func startConnection(game *Game) {
 			panic(err)
 		}
 		fmt.Printf("Player ID: %v\n", player_data)
-		// Create a datachannel with label 'data'
-		dataChannel, err := peerConnection.CreateDataChannel("data", nil)
-		if err != nil {
-			panic(err)
+		local_player_id = player_data.Id
+		setToken(player_data.Token)
+		host_player_id = hostPlayerId
+
+		connectToHost(ctx)
+		go heartbeatLoop(ctx)
+	}
+	return nil
+}
+
+// answers every player that joins the lobby, host only
+func acceptPlayers(ctx context.Context) {
+	peersMutex.Lock()
+	registered_players = make(map[int]struct{})
+	peersMutex.Unlock()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			fmt.Println("Tick at", t)
+			idUrl := getSignalingURL() + "/lobby/unregisteredPlayers?id=" + lobby_id + "&token=" + getToken()
+			fmt.Println(idUrl)
+			id_resp, err := httpClient.Get(idUrl)
+			if err != nil {
+				panic(err)
+			}
+			if id_resp.StatusCode != http.StatusOK {
+				continue
+			}
+			var player_ids []int
+			err = json.NewDecoder(id_resp.Body).Decode(&player_ids)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Player IDs: %v\n", player_ids)
+			// poll for all of the unregistered players
+			peersMutex.Lock()
+			for _, player_id := range player_ids {
+				// only start goroutine if player_id hasn't been registered yet
+				if _, ok := registered_players[player_id]; !ok {
+					registered_players[player_id] = struct{}{}
+					go pollForPlayerOffer(ctx, player_id)
+				}
+			}
+			peersMutex.Unlock()
 		}
+	}
+}
+
+// poll for offer from signaling server for player
+func pollForPlayerOffer(ctx context.Context, player_id int) {
+	// once answered, a new offer from the player (an ICE restart or a rejoin) gets polled for again
+	defer func() {
+		peersMutex.Lock()
+		delete(registered_players, player_id)
+		peersMutex.Unlock()
+	}()
 
-		// Register channel opening handling
-		dataChannel.OnOpen(func() {
-			fmt.Printf("Data channel '%s'-'%d' open.\n", dataChannel.Label(), dataChannel.ID())
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			fmt.Println("Tick at", t)
+			fmt.Printf("Polling for offer for %d\n", player_id)
+			getUrl := getSignalingURL() + "/offer/get?lobby_id=" + lobby_id + "&player_id=" + strconv.Itoa(player_id) + "&token=" + getToken()
+			fmt.Println(getUrl)
+			offer_resp, err := httpClient.Get(getUrl)
+			if err != nil {
+				panic(err)
+			}
+			if offer_resp.StatusCode != http.StatusOK {
+				continue
+			}
+			body := new(bytes.Buffer)
+			body.ReadFrom(offer_resp.Body)
+			fmt.Printf("Got offer %v\n", body.String())
+			offer := webrtc.SessionDescription{}
+			err = json.NewDecoder(body).Decode(&offer)
+			if err != nil {
+				panic(err)
+			}
+
+			peersMutex.Lock()
+			pc := peer_connections[player_id]
+			peersMutex.Unlock()
 
-			// Detach the data channel
-			raw, dErr := dataChannel.Detach()
-			if dErr != nil {
-				panic(dErr)
+			// the same DTLS fingerprint means the player is restarting ICE on the connection we already have
+			if pc != nil && sameFingerprint(pc.RemoteDescription(), &offer) {
+				fmt.Printf("Player %d is restarting ICE\n", player_id)
+				answerOffer(pc, player_id, offer)
+				return
+			}
+			// otherwise they have rejoined with a brand new connection
+			if pc != nil {
+				fmt.Printf("Player %d rejoined, replacing their old connection\n", player_id)
+				pc.Close()
 			}
 
-			// Handle reading from the data channel
-			go ReadLoop(raw)
+			// every player gets their own PeerConnection, so players and spectators
+			// can come and go without tearing down everyone else
+			pc = newPeerConnection()
+			state := newStateChannel(pc)
+			addPeer(player_id, pc)
 
-			// Handle writing to the data channel
-			go WriteLoop(raw)
-		})
+			pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+				fmt.Printf("Peer Connection State for %d has changed: %s\n", player_id, s.String())
 
-		// Create an offer to send to the browser
-		offer, err := peerConnection.CreateOffer(nil)
-		if err != nil {
-			panic(err)
+				if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateClosed {
+					fmt.Printf("Peer Connection for %d has gone away, removing it\n", player_id)
+					removePeer(player_id, pc)
+				}
+			})
+
+			// Register data channel creation handling
+			opened := make(chan struct{})
+			pc.OnDataChannel(func(d *webrtc.DataChannel) {
+				handleHostDataChannel(ctx, player_id, d, state, opened)
+			})
+
+			answerOffer(pc, player_id, offer)
+			go relayFromHostIfStuck(ctx, player_id, pc, opened)
+			return
+		}
+	}
+}
+
+// answers an offer from a player through the signaling server
+func answerOffer(pc *webrtc.PeerConnection, player_id int, offer webrtc.SessionDescription) {
+	// Set the remote SessionDescription
+	err := pc.SetRemoteDescription(offer)
+	if err != nil {
+		panic(err)
+	}
+	// Create answer
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	// Create channel that is blocked until ICE Gathering is complete
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+
+	// Sets the LocalDescription, and starts our UDP listeners
+	err = pc.SetLocalDescription(answer)
+	if err != nil {
+		panic(err)
+	}
+
+	// Block until ICE Gathering is complete, disabling trickle ICE
+	// we do this because we only can exchange one signaling message
+	// in a production application you should exchange ICE Candidates via OnICECandidate
+	<-gatherComplete
+	// send answer we generated to the signaling server
+	answerJson, err := json.Marshal(pc.LocalDescription())
+	if err != nil {
+		panic(err)
+	}
+	postUrl := getSignalingURL() + "/answer/post?lobby_id=" + lobby_id + "&player_id=" + strconv.Itoa(player_id) + "&token=" + getToken()
+	fmt.Println(postUrl)
+	httpClient.Post(postUrl, "application/json", bytes.NewBuffer(answerJson))
+}
+
+// whether two SessionDescriptions come from the same DTLS certificate, and so the same PeerConnection
+func sameFingerprint(a *webrtc.SessionDescription, b *webrtc.SessionDescription) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	fingerprint := func(sdp string) string {
+		for _, line := range strings.Split(sdp, "\n") {
+			if strings.HasPrefix(line, "a=fingerprint:") {
+				return strings.TrimSpace(line)
+			}
 		}
+		return ""
+	}
+	return fingerprint(a.SDP) != "" && fingerprint(a.SDP) == fingerprint(b.SDP)
+}
 
-		// Sets the LocalDescription, and starts our UDP listeners
-		err = peerConnection.SetLocalDescription(offer)
-		if err != nil {
-			panic(err)
+// offers a connection to whoever hosts the lobby, as local_player_id
+// called again with the same id when the host migrates or we rejoin
+func connectToHost(ctx context.Context) {
+	pc := newPeerConnection()
+	peerConnection.Store(pc)
+	hostConnected.Store(false)
+
+	// only one ICE restart at a time
+	var restarting atomic.Bool
+
+	// Set the handler for Peer connection state
+	// This will notify you when the peer has connected/disconnected
+	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		fmt.Printf("Peer Connection State has changed: %s\n", s.String())
+
+		// the host hanging up after kicking us, or a connection we have already replaced, isn't fatal
+		if kicked.Load() || pc != peerConnection.Load() {
+			return
 		}
 
-		// print out possible offers from different ICE Candidates
-		peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
-			if candidate != nil {
-				offerJson, err := json.Marshal(peerConnection.LocalDescription())
-				if err != nil {
-					panic(err)
-				}
-				postUrl := getSignalingURL() + "/offer/post?lobby_id=" + lobby_id + "&player_id=" + strconv.Itoa(player_data.Id)
-				fmt.Println(postUrl)
-				httpClient.Post(postUrl, "application/json", bytes.NewBuffer(offerJson))
+		switch s {
+		case webrtc.PeerConnectionStateConnected:
+			hostConnected.Store(true)
+
+		case webrtc.PeerConnectionStateDisconnected:
+			// may come back on its own, but an ICE restart gets it back sooner if the network changed
+			if !restarting.Swap(true) {
+				go func() {
+					defer restarting.Store(false)
+					fmt.Println("Peer Connection to the host is disconnected, restarting ICE")
+					offerToHost(ctx, pc, &webrtc.OfferOptions{ICERestart: true})
+				}()
+			}
+
+		case webrtc.PeerConnectionStateFailed:
+			// Wait until PeerConnection has had no network activity for 30 seconds or another failure.
+			// The ICE restart didn't help, so start over with a new connection in our old slot.
+			fmt.Println("Peer Connection to the host has failed, rejoining")
+			hostConnected.Store(false)
+			if !peerConnection.CompareAndSwap(pc, nil) {
+				return
 			}
-		})
-
-		answer := webrtc.SessionDescription{}
-		// read answer from other peer (wait till we actually get something)
-		ticker := time.NewTicker(1 * time.Second)
-		go func() {
-			for {
-				select {
-				case t := <-ticker.C:
-					fmt.Println("Tick at", t)
-					fmt.Println("Polling for answer")
-					url := getSignalingURL() + "/answer/get?lobby_id=" + lobby_id + "&player_id=" + strconv.Itoa(player_data.Id)
-					fmt.Println(url)
-					answer_resp, err := httpClient.Get(url)
-					if err != nil {
-						panic(err)
-					}
-					if answer_resp.StatusCode != http.StatusOK {
-						continue
-					}
-					body := new(bytes.Buffer)
-					body.ReadFrom(answer_resp.Body)
-					fmt.Printf("Got answer %v\n", body.String())
-					err = json.NewDecoder(body).Decode(&answer)
-					if err != nil {
-						panic(err)
-					}
-
-					if err := peerConnection.SetRemoteDescription(answer); err != nil {
-						panic(err)
-					}
-
-					// if we have successfully set the remote description, we can break out of the loop
-					ticker.Stop()
-					return
+			go pc.Close()
+			go func() {
+				if err := rejoinHost(ctx); err != nil {
+					giveUpOnHost(err)
 				}
+			}()
+
+		case webrtc.PeerConnectionStateClosed:
+			// PeerConnection was explicitly closed. This usually happens from a DTLS CloseNotify,
+			// when the host leaves, so someone else has to take over.
+			fmt.Println("Peer Connection to the host has closed, migrating")
+			hostConnected.Store(false)
+			if !peerConnection.CompareAndSwap(pc, nil) {
+				return
+			}
+			go migrateHost(ctx)
+		}
+	})
+
+	// spectators get their own one-way channel, which the host
+	// recognizes by its label
+	label := "data"
+	if isSpectator {
+		label = spectatorChannelLabel
+	}
+
+	// Create a datachannel with the label chosen above
+	dataChannel, err := pc.CreateDataChannel(label, nil)
+	if err != nil {
+		panic(err)
+	}
+	var state *webrtc.DataChannel
+	if !isSpectator {
+		state = newStateChannel(pc)
+	}
+
+	// Register channel opening handling
+	opened := make(chan struct{})
+	dataChannel.OnOpen(func() {
+		fmt.Printf("Data channel '%s'-'%d' open.\n", dataChannel.Label(), dataChannel.ID())
+		close(opened)
+
+		// Detach the data channel
+		raw, dErr := dataChannel.Detach()
+		if dErr != nil {
+			panic(dErr)
+		}
+
+		openHostChannel(ctx, raw, dataChannel, state)
+	})
+
+	go offerToHost(ctx, pc, nil)
+	go relayToHostIfStuck(ctx, pc, label, opened)
+}
+
+// sends the host an offer through the signaling server and waits for the answer
+func offerToHost(ctx context.Context, pc *webrtc.PeerConnection, options *webrtc.OfferOptions) {
+	player_id := local_player_id
+
+	// Create an offer to send to the host
+	offer, err := pc.CreateOffer(options)
+	if err != nil {
+		panic(err)
+	}
+
+	// Create channel that is blocked until ICE Gathering is complete
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+
+	// Sets the LocalDescription, and starts our UDP listeners
+	err = pc.SetLocalDescription(offer)
+	if err != nil {
+		panic(err)
+	}
+
+	// Block until ICE Gathering is complete, so the offer only has to be posted once
+	// posting it again would make the host answer it again
+	select {
+	case <-ctx.Done():
+		return
+	case <-gatherComplete:
+	}
+	offerJson, err := json.Marshal(pc.LocalDescription())
+	if err != nil {
+		panic(err)
+	}
+	postUrl := getSignalingURL() + "/offer/post?lobby_id=" + lobby_id + "&player_id=" + strconv.Itoa(player_id) + "&token=" + getToken()
+	fmt.Println(postUrl)
+	httpClient.Post(postUrl, "application/json", bytes.NewBuffer(offerJson))
+
+	answer := webrtc.SessionDescription{}
+	// read answer from other peer (wait till we actually get something)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			// nothing left to answer once we have given up on this connection
+			if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+				return
+			}
+			fmt.Println("Tick at", t)
+			fmt.Println("Polling for answer")
+			url := getSignalingURL() + "/answer/get?lobby_id=" + lobby_id + "&player_id=" + strconv.Itoa(player_id) + "&token=" + getToken()
+			fmt.Println(url)
+			answer_resp, err := httpClient.Get(url)
+			if err != nil {
+				panic(err)
 			}
-		}()
+			if answer_resp.StatusCode != http.StatusOK {
+				continue
+			}
+			body := new(bytes.Buffer)
+			body.ReadFrom(answer_resp.Body)
+			fmt.Printf("Got answer %v\n", body.String())
+			err = json.NewDecoder(body).Decode(&answer)
+			if err != nil {
+				panic(err)
+			}
+
+			if err := pc.SetRemoteDescription(answer); err != nil {
+				panic(err)
+			}
+
+			// if we have successfully set the remote description, we can break out of the loop
+			return
+		}
+	}
+}
+
+// sets up a data channel opened by a player or spectator on the host
+// state is the connection's state channel, opened is closed once d is open
+func handleHostDataChannel(ctx context.Context, player_id int, d *webrtc.DataChannel, state *webrtc.DataChannel, opened chan struct{}) {
+	fmt.Printf("New DataChannel %s %d\n", d.Label(), d.ID())
+
+	// Register channel opening handling
+	d.OnOpen(func() {
+		fmt.Printf("Data channel '%s'-'%d' open.\n", d.Label(), d.ID())
+		close(opened)
+
+		// Detach the data channel
+		raw, dErr := d.Detach()
+		if dErr != nil {
+			panic(dErr)
+		}
+
+		handleHostChannel(ctx, player_id, d.Label(), raw, d, state)
+	})
+}
+
+// starts talking to a player or spectator over a data channel or a relay, host only
+// dc is the data channel raw was detached from and state the connection's state channel, both nil for a relay
+func handleHostChannel(ctx context.Context, player_id int, label string, raw io.ReadWriteCloser, dc *webrtc.DataChannel, state *webrtc.DataChannel) {
+	// spectators get a delayed, write-only stream and are never read from
+	if label == spectatorChannelLabel {
+		go SpectatorWriteLoop(ctx, raw)
+		return
+	}
+
+	// the player joins the lobby once they say hello
+	c := newPeerChannel(raw, dc)
+	// snapshots only go back over the state channel once the player has shown they have one
+	if state != nil {
+		c.openState(player_id, state, false)
+	}
+
+	// Handle reading from the data channel
+	go ReadLoop(player_id, c)
+
+	// Handle writing to the data channel
+	go WriteLoop(ctx, player_id, c)
+}
+
+// starts talking to the host over a data channel or a relay, players and spectators only
+// dc is the data channel raw was detached from and state the connection's state channel, both nil for a relay
+func openHostChannel(ctx context.Context, raw io.ReadWriteCloser, dc *webrtc.DataChannel, state *webrtc.DataChannel) {
+	// spectators only ever read, they can't affect the game
+	if isSpectator {
+		go SpectatorReadLoop(raw)
+		return
+	}
+
+	// introduce ourselves to the host's lobby
+	c := newPeerChannel(raw, dc)
+	if state != nil {
+		c.openState(host_player_id, state, true)
+	}
+	lobbyMutex.Lock()
+	hostChannel = c
+	lobbyMutex.Unlock()
+	if err := c.send(messageHello, &HelloMessage{playerName}); err != nil {
+		panic(err)
+	}
+
+	// Handle reading from the data channel
+	go ReadLoop(host_player_id, c)
+
+	// Handle writing to the data channel
+	go WriteLoop(ctx, host_player_id, c)
+
+	// the host's clock is the match clock
+	go clockSyncLoop(ctx, c)
+
+	// the host pings us too, but we time our own round trips for sendRate
+	go pingLoop(ctx, c)
+}
+
+func addPeer(player_id int, pc *webrtc.PeerConnection) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	peer_connections[player_id] = pc
+}
+
+// forgets a player's connection, unless it has already been replaced by a newer one
+func removePeer(player_id int, pc *webrtc.PeerConnection) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	if peer_connections[player_id] == pc {
+		delete(peer_connections, player_id)
 	}
 }
 
 func closeConnection() {
-	if cErr := peerConnection.Close(); cErr != nil {
-		fmt.Printf("cannot close peerConnection: %v\n", cErr)
+	endSession()
+
+	// let the host know straight away, rather than them holding our slot for a rejoin
+	lobbyMutex.Lock()
+	c := hostChannel
+	lobbyMutex.Unlock()
+	if c != nil {
+		c.send(messageLeave, &LeaveMessage{})
+	}
+
+	// the signaling server hears we are gone before anyone else does,
+	// so if we were the host, whoever takes over isn't turned away for a host that is still there
+	leaveSignaling()
+
+	// forget the connection first, so it closing isn't mistaken for the host going away
+	if pc := peerConnection.Swap(nil); pc != nil {
+		if cErr := pc.Close(); cErr != nil {
+			fmt.Printf("cannot close peerConnection: %v\n", cErr)
+		}
+	}
+	peersMutex.Lock()
+	for player_id, pc := range peer_connections {
+		if cErr := pc.Close(); cErr != nil {
+			fmt.Printf("cannot close peerConnection for %d: %v\n", player_id, cErr)
+		}
+	}
+	clear(peer_connections)
+	peersMutex.Unlock()
+}
+
+// gives up our slot on the signaling server
+// a host on their own deletes the lobby, otherwise it is left for the others to take over
+func leaveSignaling() {
+	token := takeToken()
+	if token == "" {
+		return
+	}
+	query := url.Values{}
+	query.Set("id", lobby_id)
+	query.Set("token", token)
+
+	lobbyMutex.Lock()
+	alone := len(lobby.Players) <= 1
+	lobbyMutex.Unlock()
+
+	endpoint := "/lobby/leave"
+	if isHost.Load() && alone {
+		endpoint = "/lobby/delete"
+	} else {
+		query.Set("player_id", strconv.Itoa(local_player_id))
 	}
-	// TODO: this doesn't work, fix this
-	if isHost {
-		// delete lobby if host
-		url := getSignalingURL() + "/lobby/delete"
-		fmt.Println(url)
-		httpClient.Get(url)
+	url := getSignalingURL() + endpoint + "?" + query.Encode()
+	fmt.Println(url)
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		fmt.Printf("cannot leave lobby %s: %v\n", lobby_id, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// keeps our slot on the signaling server from expiring
+func heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// servers without tokens don't expire anything either
+		token := getToken()
+		if token == "" {
+			return
+		}
+		query := url.Values{}
+		query.Set("id", lobby_id)
+		query.Set("player_id", strconv.Itoa(local_player_id))
+		query.Set("token", token)
+		resp, err := httpClient.Get(getSignalingURL() + "/lobby/heartbeat?" + query.Encode())
+		if err != nil {
+			fmt.Println("Heartbeat failed:", err)
+			continue
+		}
+		resp.Body.Close()
 	}
 }
 
+// tears down the current lobby so another one can be hosted or joined
+func leaveSession() {
+	closeConnection()
+	resetLobby()
+	isHost.Store(false)
+	isSpectator = false
+}
+
 // entry point of the program
 func main() {
-	ebiten.SetWindowSize(640, 480)
+	flag.DurationVar(&spectatorDelay, "spectator-delay", spectatorDelay, "how far behind the live game spectators are kept")
+	flag.DurationVar(&relayTimeout, "relay-timeout", relayTimeout, "how long to wait for ICE before relaying through the signaling server, 0 to never relay")
+	flag.Parse()
+
+	// `go run . server` runs the embedded signaling server instead of the game
+	if flag.Arg(0) == "server" {
+		serverFlags := flag.NewFlagSet("server", flag.ExitOnError)
+		serverPort := serverFlags.Int("port", port, "port to listen on")
+		serverFlags.Parse(flag.Args()[1:])
+		log.Fatal(signaling.ListenAndServe(":" + strconv.Itoa(*serverPort)))
+	}
+	if flag.Arg(0) == "bot" {
+		runBots(flag.Args()[1:])
+		return
+	}
+
+	sound.Init()
+
+	ebiten.SetWindowSize(logicalWidth, logicalHeight)
+	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
 	ebiten.SetWindowTitle("Hello, World!")
 
 	// load images for button states: idle, hover, and pressed
@@ -457,232 +888,21 @@ func main() {
 	// load button text font
 	face, _ := loadFont(20)
 
-	// construct a new container that serves as the root of the UI hierarchy
-	rootContainer := widget.NewContainer(
-		// the container will use a plain color as its background
-		widget.ContainerOpts.BackgroundImage(image.NewNineSliceColor(color.NRGBA{0x13, 0x1a, 0x22, 0xff})),
-
-		// the container will use an anchor layout to layout its single child widget
-		widget.ContainerOpts.Layout(widget.NewAnchorLayout()),
-	)
-	game := Game{}
-	// construct the UI
-	game.ui = &ebitenui.UI{
-		Container: rootContainer,
-	}
-
-	// Creating button variable first so that it is usable in callbacks
-	game.hostButton = widget.NewButton(
-		// set general widget options
-		widget.ButtonOpts.WidgetOpts(
-			// instruct the container's anchor layout to center the button both horizontally and vertically
-			widget.WidgetOpts.LayoutData(widget.AnchorLayoutData{
-				HorizontalPosition: widget.AnchorLayoutPositionCenter,
-				VerticalPosition:   widget.AnchorLayoutPositionStart,
-			}),
-		),
-		// specify the images to use
-		widget.ButtonOpts.Image(buttonImage),
-
-		// specify the button's text, the font face, and the color
-		//widget.ButtonOpts.Text("Hello, World!", face, &widget.ButtonTextColor{
-		widget.ButtonOpts.Text("Host Game", face, &widget.ButtonTextColor{
-			Idle:    color.NRGBA{0xdf, 0xf4, 0xff, 0xff},
-			Hover:   color.NRGBA{0, 255, 128, 255},
-			Pressed: color.NRGBA{255, 0, 0, 255},
-		}),
-		widget.ButtonOpts.TextProcessBBCode(true),
-		// specify that the button's text needs some padding for correct display
-		widget.ButtonOpts.TextPadding(widget.Insets{
-			Left:   30,
-			Right:  30,
-			Top:    5,
-			Bottom: 5,
-		}),
-
-		// add a handler that reacts to clicking the button
-		widget.ButtonOpts.ClickedHandler(func(args *widget.ButtonClickedEventArgs) {
-			fmt.Println(game.standardTextInput.GetText())
-			isHost = true
-			startConnection(&game)
-		}),
-
-		// Indicate that this button should not be submitted when enter or space are pressed
-		widget.ButtonOpts.DisableDefaultKeys(),
-	)
-
-	// add the button as a child of the container
-	rootContainer.AddChild(game.hostButton)
-
-	// Creating button variable first so that it is usable in callbacks
-	game.joinButton = widget.NewButton(
-		// set general widget options
-		widget.ButtonOpts.WidgetOpts(
-			// instruct the container's anchor layout to center the button both horizontally and vertically
-			widget.WidgetOpts.LayoutData(widget.AnchorLayoutData{
-				HorizontalPosition: widget.AnchorLayoutPositionCenter,
-				VerticalPosition:   widget.AnchorLayoutPositionEnd,
-			}),
-		),
-		// specify the images to use
-		widget.ButtonOpts.Image(buttonImage),
-
-		// specify the button's text, the font face, and the color
-		//widget.ButtonOpts.Text("Hello, World!", face, &widget.ButtonTextColor{
-		widget.ButtonOpts.Text("Join Lobby", face, &widget.ButtonTextColor{
-			Idle:    color.NRGBA{0xdf, 0xf4, 0xff, 0xff},
-			Hover:   color.NRGBA{0, 255, 128, 255},
-			Pressed: color.NRGBA{255, 0, 0, 255},
-		}),
-		widget.ButtonOpts.TextProcessBBCode(true),
-		// specify that the button's text needs some padding for correct display
-		widget.ButtonOpts.TextPadding(widget.Insets{
-			Left:   30,
-			Right:  30,
-			Top:    5,
-			Bottom: 5,
-		}),
-
-		// add a handler that reacts to clicking the button
-		widget.ButtonOpts.ClickedHandler(func(args *widget.ButtonClickedEventArgs) {
-			fmt.Println(game.standardTextInput.GetText())
-			isHost = false
-			startConnection(&game)
-		}),
-
-		// Indicate that this button should not be submitted when enter or space are pressed
-		widget.ButtonOpts.DisableDefaultKeys(),
-	)
-
-	// add the button as a child of the container
-	rootContainer.AddChild(game.joinButton)
-
-	// construct a new container to contain textboxes
-	textBoxContainer := widget.NewContainer(
-		// the container will use a plain color as its background
-		widget.ContainerOpts.BackgroundImage(image.NewNineSliceColor(color.NRGBA{0x13, 0x1a, 0x22, 0xff})),
-
-		// the container will use an anchor layout to layout its single child widget
-		widget.ContainerOpts.Layout(widget.NewAnchorLayout()),
-
-		widget.ContainerOpts.WidgetOpts(
-			//Set the layout information to center the container in the parent
-			widget.WidgetOpts.LayoutData(widget.AnchorLayoutData{
-				HorizontalPosition: widget.AnchorLayoutPositionCenter,
-				VerticalPosition:   widget.AnchorLayoutPositionCenter,
-			}),
-			widget.WidgetOpts.MinSize(150, 150),
-		),
-	)
-
-	rootContainer.AddChild(textBoxContainer)
-
-	// construct a standard textinput widget for signaling server ip
-	signalingTextInput := widget.NewTextInput(
-		widget.TextInputOpts.WidgetOpts(
-			//Set the layout information to center the textbox in the parent
-			widget.WidgetOpts.LayoutData(widget.AnchorLayoutData{
-				HorizontalPosition: widget.AnchorLayoutPositionCenter,
-				VerticalPosition:   widget.AnchorLayoutPositionStart,
-			}),
-			widget.WidgetOpts.MinSize(150, 30),
-		),
-
-		//Set the Idle and Disabled background image for the text input
-		//If the NineSlice image has a minimum size, the widget will use that or
-		// widget.WidgetOpts.MinSize; whichever is greater
-		widget.TextInputOpts.Image(&widget.TextInputImage{
-			Idle:     image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
-			Disabled: image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
-		}),
-
-		//Set the font face and size for the widget
-		widget.TextInputOpts.Face(face),
-
-		//Set the colors for the text and caret
-		widget.TextInputOpts.Color(&widget.TextInputColor{
-			Idle:          color.NRGBA{254, 255, 255, 255},
-			Disabled:      color.NRGBA{R: 200, G: 200, B: 200, A: 255},
-			Caret:         color.NRGBA{254, 255, 255, 255},
-			DisabledCaret: color.NRGBA{R: 200, G: 200, B: 200, A: 255},
-		}),
-
-		//Set how much padding there is between the edge of the input and the text
-		widget.TextInputOpts.Padding(widget.NewInsetsSimple(5)),
-
-		//Set the font and width of the caret
-		widget.TextInputOpts.CaretOpts(
-			widget.CaretOpts.Size(face, 2),
-		),
-
-		//This text is displayed if the input is empty
-		widget.TextInputOpts.Placeholder("Signaling Server IP"),
-
-		//This is called whenever there is a change to the text
-		widget.TextInputOpts.ChangedHandler(func(args *widget.TextInputChangedEventArgs) {
-			fmt.Println("Text Changed: ", args.InputText)
-			signalingIP = args.InputText
-		}),
-	)
-
-	signalingTextInput.SetText(signalingIP)
-
-	textBoxContainer.AddChild(signalingTextInput)
-
-	// construct a standard textinput widget for lobby id
-	game.standardTextInput = widget.NewTextInput(
-		widget.TextInputOpts.WidgetOpts(
-			//Set the layout information to center the textbox in the parent
-			widget.WidgetOpts.LayoutData(widget.AnchorLayoutData{
-				HorizontalPosition: widget.AnchorLayoutPositionCenter,
-				VerticalPosition:   widget.AnchorLayoutPositionEnd,
-			}),
-			widget.WidgetOpts.MinSize(150, 30),
-		),
-
-		//Set the Idle and Disabled background image for the text input
-		//If the NineSlice image has a minimum size, the widget will use that or
-		// widget.WidgetOpts.MinSize; whichever is greater
-		widget.TextInputOpts.Image(&widget.TextInputImage{
-			Idle:     image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
-			Disabled: image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
-		}),
-
-		//Set the font face and size for the widget
-		widget.TextInputOpts.Face(face),
-
-		//Set the colors for the text and caret
-		widget.TextInputOpts.Color(&widget.TextInputColor{
-			Idle:          color.NRGBA{254, 255, 255, 255},
-			Disabled:      color.NRGBA{R: 200, G: 200, B: 200, A: 255},
-			Caret:         color.NRGBA{254, 255, 255, 255},
-			DisabledCaret: color.NRGBA{R: 200, G: 200, B: 200, A: 255},
-		}),
-
-		//Set how much padding there is between the edge of the input and the text
-		widget.TextInputOpts.Padding(widget.NewInsetsSimple(5)),
-
-		//Set the font and width of the caret
-		widget.TextInputOpts.CaretOpts(
-			widget.CaretOpts.Size(face, 2),
-		),
-
-		//This text is displayed if the input is empty
-		widget.TextInputOpts.Placeholder("Lobby ID"),
-
-		//This is called when the user hits the "Enter" key.
-		//There are other options that can configure this behavior
-		widget.TextInputOpts.SubmitHandler(func(args *widget.TextInputChangedEventArgs) {
-			fmt.Println("Text Submitted: ", args.InputText)
-		}),
-
-		//This is called whenever there is a change to the text
-		widget.TextInputOpts.ChangedHandler(func(args *widget.TextInputChangedEventArgs) {
-			fmt.Println("Text Changed: ", args.InputText)
-		}),
-	)
-
-	textBoxContainer.AddChild(game.standardTextInput)
+	// HUD fonts, sized off the logical resolution like the rest of the HUD
+	hudFace, _ := loadFont(hudFontSize)
+	timerFace, _ := loadFont(hudTimerSize)
+	bannerFace, _ := loadFont(hudBannerSize)
+
+	game := Game{
+		face:          face,
+		buttonImage:   buttonImage,
+		checkboxImage: loadCheckboxImage(),
+		hudFace:       hudFace,
+		timerFace:     timerFace,
+		bannerFace:    bannerFace,
+	}
+	game.scenes = newSceneManager(&game)
+	game.scenes.Push(newMainMenuScene(&game))
 
 	// triggers the game loop to actually start up
 	// if we run into an error, log what it is
@@ -694,49 +914,146 @@ func main() {
 	closeConnection()
 }
 
+// a gopher's position, which is all that went over the wire before snapshots
 type Packet struct {
 	Pos_x float64
 	Pos_y float64
 }
 
 // ReadLoop shows how to read from the datachannel directly
-func ReadLoop(d io.Reader) {
+func ReadLoop(player_id int, c *peerChannel) {
+	buffer := make([]byte, maxMessageSize)
 	for {
-		buffer := make([]byte, messageSize)
-		_, err := io.ReadFull(d, buffer)
+		n, err := c.rw.Read(buffer)
 		if err != nil {
 			fmt.Println("Datachannel closed; Exit the readloop:", err)
+			if isHost.Load() {
+				disconnectPlayer(player_id, c)
+			}
 			return
 		}
+		if n == 0 {
+			continue
+		}
 
-		var packet Packet
-		err = binary.Unmarshal(buffer, &packet)
+		message := buffer[:n]
+		if message[0] == messageChecksum || message[0] == messageDesync {
+			handleDesyncMessage(player_id, c, message)
+			continue
+		}
+		if message[0] != messageState {
+			handleLobbyMessage(player_id, c, message)
+			continue
+		}
+
+		applySnapshot(player_id, c, message)
+	}
+}
+
+// StateReadLoop reads the snapshots coming in over a state channel, see stateChannelLabel
+// the channel closing is dealt with by ReadLoop, which sees the same connection go
+func StateReadLoop(player_id int, c *peerChannel, rw io.Reader) {
+	buffer := make([]byte, maxMessageSize)
+	for {
+		n, err := rw.Read(buffer)
 		if err != nil {
-			panic(err)
+			return
 		}
+		if n == 0 || buffer[0] != messageState {
+			continue
+		}
+		// they have a state channel, so ours can go over it too
+		c.overState.Store(true)
+		applySnapshot(player_id, c, buffer[:n])
+	}
+}
 
-		remote_pos_x = packet.Pos_x
-		remote_pos_y = packet.Pos_y
+// decodes a snapshot message from the other end and puts what's in it into the game
+func applySnapshot(player_id int, c *peerChannel, message []byte) {
+	c.applying.Lock()
+	defer c.applying.Unlock()
 
-		fmt.Printf("Message from DataChannel: %f %f\n", packet.Pos_x, packet.Pos_y)
+	entities, err := c.snapshots.decode(message[1:])
+	if err != nil {
+		fmt.Println("Dropping snapshot:", err)
+		return
+	}
+	// one that was overtaken would only put gophers back where they were
+	if c.snapshots.stale() {
+		return
+	}
+	applied := make([]gopherId, 0, len(entities))
+	for _, entity := range entities {
+		// players only speak for the gophers at their machine, the host speaks for everyone
+		if entity.Id == local_player_id || (isHost.Load() && entity.Id != player_id) {
+			continue
+		}
+		setRemoteGopher(entity.gopher(), entity.Pos_x, entity.Pos_y)
+		applied = append(applied, entity.gopher())
+		if entity.gopher() != (gopherId{player_id, 0}) {
+			continue
+		}
+		remote_pos_x = entity.Pos_x
+		remote_pos_y = entity.Pos_y
+
+		// kept around for the snapshots a new host would carry on from
+		if isHost.Load() {
+			lobbyMutex.Lock()
+			player_positions[player_id] = Packet{entity.Pos_x, entity.Pos_y}
+			lobbyMutex.Unlock()
+		}
 	}
+	// read back out of the game, for checking against the sender's checksum of where they had them
+	c.snapshots.setChecksum(false, stateChecksum(remoteGophers(applied)))
 }
 
 // WriteLoop shows how to write to the datachannel directly
-func WriteLoop(d io.Writer) {
-	ticker := time.NewTicker(time.Millisecond * 20)
-	defer ticker.Stop()
-	for range ticker.C {
-		packet := &Packet{pos_x, pos_y}
-		fmt.Printf("Sending x:%f y:%f\n", packet.Pos_x, packet.Pos_y)
-		encoded, err := binary.Marshal(packet)
-		if err != nil {
-			panic(err)
+// snapshots go out as often as the link allows, see sendRate
+// player_id is who is on the other end, whose own gophers the host doesn't send back to them
+func WriteLoop(ctx context.Context, player_id int, c *peerChannel) {
+	rate := newSendRate()
+	timer := time.NewTimer(rate.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
 		}
 
-		if _, err := d.Write(encoded); err != nil {
-			panic(err)
+		// a snapshot is stale by the time a congested link gets to it, so wait for the buffer to drain
+		if c.buffered() > bufferedHigh {
+			rate.update(0, 0, c.buffered())
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.drained:
+			case <-time.After(time.Second):
+			}
+			timer.Reset(rate.interval)
+			continue
+		}
+
+		rate.update(c.roundTrip(), c.snapshots.lossRate(), c.buffered())
+		frame, entities := localFrame()
+		if !rate.fullDetail() {
+			for i := range entities {
+				entities[i].Vel_x, entities[i].Vel_y = 0, 0
+			}
+		}
+		if isHost.Load() {
+			entities = append(entities, forwardedEntities(player_id)...)
 		}
+		checksum := stateChecksum(entities)
+		state := c.snapshots.encode(entities)
+		if err := c.writeState(append([]byte{messageState}, state...)); err != nil {
+			// the channel going away is dealt with by whoever owns its connection,
+			// like the host migrating when the host's channel closes
+			fmt.Println("Datachannel closed; Exit the writeloop:", err)
+			return
+		}
+		sendChecksum(c, frame, checksum)
+		timer.Reset(rate.interval)
 	}
 }
 
@@ -754,6 +1071,19 @@ func loadButtonImage() (*widget.ButtonImage, error) {
 	}, nil
 }
 
+func loadCheckboxImage() *widget.CheckboxGraphicImage {
+	unchecked := ebiten.NewImage(20, 20)
+	unchecked.Fill(color.NRGBA{R: 100, G: 100, B: 100, A: 255})
+
+	checked := ebiten.NewImage(20, 20)
+	checked.Fill(color.NRGBA{R: 0, G: 200, B: 100, A: 255})
+
+	return &widget.CheckboxGraphicImage{
+		Unchecked: &widget.GraphicImage{Idle: unchecked, Disabled: unchecked},
+		Checked:   &widget.GraphicImage{Idle: checked, Disabled: checked},
+	}
+}
+
 func loadFont(size float64) (text.Face, error) {
 	s, err := text.NewGoTextFaceSource(bytes.NewReader(goregular.TTF))
 	if err != nil {
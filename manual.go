@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// the id the joining player gets when connecting by copy-paste, there's nobody to hand one out
+const manualPlayerId = 1
+
+// turns a SessionDescription into something short enough to paste into a chat
+// ICE gathering must be complete, since there's no way to trickle candidates afterwards
+func encodeSignal(desc *webrtc.SessionDescription) string {
+	descJson, err := json.Marshal(desc)
+	if err != nil {
+		panic(err)
+	}
+	compressed := new(bytes.Buffer)
+	w, err := flate.NewWriter(compressed, flate.BestCompression)
+	if err != nil {
+		panic(err)
+	}
+	w.Write(descJson)
+	w.Close()
+	return base64.RawURLEncoding.EncodeToString(compressed.Bytes())
+}
+
+// the other way around, want is the type of description we expect to be given
+func decodeSignal(signal string, want webrtc.SDPType) (webrtc.SessionDescription, error) {
+	desc := webrtc.SessionDescription{}
+	// chat apps like to wrap long lines and add spaces
+	signal = strings.Join(strings.Fields(signal), "")
+	compressed, err := base64.RawURLEncoding.DecodeString(signal)
+	if err != nil {
+		return desc, errors.New("that doesn't look like a connection string")
+	}
+	descJson, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return desc, errors.New("that connection string is cut short")
+	}
+	if err := json.Unmarshal(descJson, &desc); err != nil || desc.Type != want {
+		return desc, fmt.Errorf("that isn't an %s", want)
+	}
+	return desc, nil
+}
+
+// starts a session by copy-paste, without a signaling server
+// only two players can play this way, and there's nothing to relay through or migrate to
+func startManualSession() context.Context {
+	session, endSession = context.WithCancel(context.Background())
+	lobby_id = ""
+	setToken("")
+	isSpectator = false
+	kicked.Store(false)
+	return session
+}
+
+// hosts a lobby for the player whose offer was pasted in, returning the answer to send back
+// blocks until ICE gathering is done
+func answerManualOffer(signal string) (string, error) {
+	offer, err := decodeSignal(signal, webrtc.SDPTypeOffer)
+	if err != nil {
+		return "", err
+	}
+
+	ctx := startManualSession()
+	isHost.Store(true)
+	local_player_id = hostPlayerId
+	host_player_id = hostPlayerId
+	hostLobby(ctx)
+
+	pc := newPeerConnection()
+	state := newStateChannel(pc)
+	addPeer(manualPlayerId, pc)
+	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		fmt.Printf("Peer Connection State for %d has changed: %s\n", manualPlayerId, s.String())
+		if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateClosed {
+			removePeer(manualPlayerId, pc)
+		}
+	})
+	opened := make(chan struct{})
+	pc.OnDataChannel(func(d *webrtc.DataChannel) {
+		handleHostDataChannel(ctx, manualPlayerId, d, state, opened)
+	})
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		panic(err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		panic(err)
+	}
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-gatherComplete:
+	}
+	return encodeSignal(pc.LocalDescription()), nil
+}
+
+// creates an offer for the host to paste in, as the first step of joining by copy-paste
+// blocks until ICE gathering is done
+func createManualOffer() (string, error) {
+	ctx := startManualSession()
+	isHost.Store(false)
+	local_player_id = manualPlayerId
+	host_player_id = hostPlayerId
+
+	pc := newPeerConnection()
+	peerConnection.Store(pc)
+	hostConnected.Store(false)
+	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		fmt.Printf("Peer Connection State has changed: %s\n", s.String())
+		if kicked.Load() || pc != peerConnection.Load() {
+			return
+		}
+		switch s {
+		case webrtc.PeerConnectionStateConnected:
+			hostConnected.Store(true)
+		// restarting ICE or rejoining would need another round of copy-paste, so this is the end
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			hostConnected.Store(false)
+			if !peerConnection.CompareAndSwap(pc, nil) {
+				return
+			}
+			go pc.Close()
+			giveUpOnHost(errors.New("the connection was lost"))
+		}
+	})
+
+	dataChannel, err := pc.CreateDataChannel("data", nil)
+	if err != nil {
+		panic(err)
+	}
+	state := newStateChannel(pc)
+	dataChannel.OnOpen(func() {
+		fmt.Printf("Data channel '%s'-'%d' open.\n", dataChannel.Label(), dataChannel.ID())
+		raw, dErr := dataChannel.Detach()
+		if dErr != nil {
+			panic(dErr)
+		}
+		openHostChannel(ctx, raw, dataChannel, state)
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		panic(err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		panic(err)
+	}
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-gatherComplete:
+	}
+	return encodeSignal(pc.LocalDescription()), nil
+}
+
+// finishes joining by copy-paste with the answer the host sent back
+func acceptManualAnswer(signal string) error {
+	answer, err := decodeSignal(signal, webrtc.SDPTypeAnswer)
+	if err != nil {
+		return err
+	}
+	pc := peerConnection.Load()
+	if pc == nil {
+		return errors.New("create an offer first")
+	}
+	return pc.SetRemoteDescription(answer)
+}
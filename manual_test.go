@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// a description like one pion gives once ICE gathering is done, candidates and all
+var testOffer = webrtc.SessionDescription{
+	Type: webrtc.SDPTypeOffer,
+	SDP: "v=0\r\n" +
+		"o=- 4215775240449105457 1700000000 IN IP4 0.0.0.0\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"a=group:BUNDLE 0\r\n" +
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"a=setup:actpass\r\n" +
+		"a=mid:0\r\n" +
+		"a=ice-ufrag:QtMiHaTzNOPvbKPv\r\n" +
+		"a=ice-pwd:rGYmtdMhCKNQGIlOcQfMVvYAMGlkZCwC\r\n" +
+		"a=fingerprint:sha-256 49:66:12:17:0D:1C:91:AE:57:4C:C6:36:DD:D5:97:D2:7D:62:C9:9A:7F:B9:A3:F4:70:03:E7:43:91:73:23:5E\r\n" +
+		"a=candidate:1966762133 1 udp 2130706431 192.168.1.20 51234 typ host\r\n" +
+		"a=candidate:233762139 1 udp 1694498815 203.0.113.7 51234 typ srflx raddr 0.0.0.0 rport 51234\r\n" +
+		"a=end-of-candidates\r\n" +
+		"a=sctp-port:5000\r\n",
+}
+
+func TestSignalRoundTrip(t *testing.T) {
+	signal := encodeSignal(&testOffer)
+	if len(signal) >= len(testOffer.SDP) {
+		t.Errorf("the signal is %d characters, the SDP only %d", len(signal), len(testOffer.SDP))
+	}
+	desc, err := decodeSignal(signal, webrtc.SDPTypeOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc != testOffer {
+		t.Errorf("got %+v", desc)
+	}
+}
+
+func TestSignalSurvivesChatWrapping(t *testing.T) {
+	signal := encodeSignal(&testOffer)
+	// broken into lines with spaces, the way chat apps paste long strings back
+	var wrapped strings.Builder
+	for len(signal) > 40 {
+		wrapped.WriteString(signal[:40] + " \n ")
+		signal = signal[40:]
+	}
+	wrapped.WriteString(signal + "\n")
+	desc, err := decodeSignal(wrapped.String(), webrtc.SDPTypeOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc != testOffer {
+		t.Errorf("got %+v", desc)
+	}
+}
+
+func TestBadSignals(t *testing.T) {
+	signal := encodeSignal(&testOffer)
+	tests := []struct {
+		name   string
+		signal string
+		want   webrtc.SDPType
+	}{
+		{"not base64", "this is not a signal!", webrtc.SDPTypeOffer},
+		{"cut short", signal[:len(signal)/2], webrtc.SDPTypeOffer},
+		{"wrong type", signal, webrtc.SDPTypeAnswer},
+		{"empty", "", webrtc.SDPTypeOffer},
+	}
+	for _, test := range tests {
+		if _, err := decodeSignal(test.signal, test.want); err == nil {
+			t.Errorf("%s: decoded without an error", test.name)
+		}
+	}
+}
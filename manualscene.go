@@ -0,0 +1,191 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// connects two players by pasting connection strings to each other, using only STUN
+// the player joining creates an offer, the host answers it, and the answer goes back
+type ManualScene struct {
+	ui *ebitenui.UI
+
+	offerInput  *widget.TextInput
+	answerInput *widget.TextInput
+	statusText  *widget.Text
+
+	// filled in by the goroutines gathering ICE candidates and reading the clipboard
+	// ebitenui widgets are only touched from Update
+	mu     sync.Mutex
+	busy   bool
+	offer  *string
+	answer *string
+	status *string
+}
+
+func newManualScene(g *Game) *ManualScene {
+	s := &ManualScene{}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Copy-Paste Connect"))
+	rootContainer.AddChild(newLabel(g, "Joining: create an offer, send it to the host and paste their answer"))
+	rootContainer.AddChild(newLabel(g, "Hosting: paste the offer you were sent and send back the answer"))
+
+	s.offerInput = newTextInput(g, "Offer", nil, widget.TextInputOpts.WidgetOpts(widget.WidgetOpts.MinSize(320, 30)))
+	rootContainer.AddChild(s.clipboardRow(g, s.offerInput, func(text string) { s.offer = &text }))
+
+	s.answerInput = newTextInput(g, "Answer", nil, widget.TextInputOpts.WidgetOpts(widget.WidgetOpts.MinSize(320, 30)))
+	rootContainer.AddChild(s.clipboardRow(g, s.answerInput, func(text string) { s.answer = &text }))
+
+	buttons := widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+			widget.RowLayoutOpts.Spacing(10),
+		)),
+	)
+	buttons.AddChild(newButton(g, "Create Offer", func(args *widget.ButtonClickedEventArgs) {
+		s.run("Gathering candidates...", func() {
+			offer, err := createManualOffer()
+			s.finish(&offer, nil, err, "Send the offer to the host and paste their answer")
+		})
+	}))
+	buttons.AddChild(newButton(g, "Answer Offer", func(args *widget.ButtonClickedEventArgs) {
+		offer := s.offerInput.GetText()
+		s.run("Gathering candidates...", func() {
+			answer, err := answerManualOffer(offer)
+			s.finish(nil, &answer, err, "Send the answer back and wait for them to connect")
+		})
+	}))
+	buttons.AddChild(newButton(g, "Connect", func(args *widget.ButtonClickedEventArgs) {
+		if err := acceptManualAnswer(s.answerInput.GetText()); err != nil {
+			s.statusText.Label = err.Error()
+			return
+		}
+		s.statusText.Label = "Connecting..."
+	}))
+	rootContainer.AddChild(buttons)
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		leaveSession()
+		g.scenes.Pop()
+	}))
+
+	s.statusText = newLabel(g, "")
+	rootContainer.AddChild(s.statusText)
+
+	return s
+}
+
+// an input with buttons to copy it and paste into it, ebitenui's inputs can't do either
+// pasted sets the pending text, with s.mu held
+func (s *ManualScene) clipboardRow(g *Game, input *widget.TextInput, pasted func(text string)) *widget.Container {
+	row := widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+			widget.RowLayoutOpts.Spacing(10),
+		)),
+	)
+	row.AddChild(input)
+	row.AddChild(newButton(g, "Copy", func(args *widget.ButtonClickedEventArgs) {
+		copyToClipboard(input.GetText())
+	}))
+	row.AddChild(newButton(g, "Paste", func(args *widget.ButtonClickedEventArgs) {
+		pasteFromClipboard(func(text string, err error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if err != nil {
+				status := err.Error()
+				s.status = &status
+				return
+			}
+			pasted(text)
+		})
+	}))
+	return row
+}
+
+// starts over with a fresh session and runs work in the background, one at a time
+func (s *ManualScene) run(status string, work func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy {
+		return
+	}
+	s.busy = true
+	leaveSession()
+	s.statusText.Label = status
+	go work()
+}
+
+// hands the result of run's work over to Update
+func (s *ManualScene) finish(offer *string, answer *string, err error, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy = false
+	if err != nil {
+		status = err.Error()
+	} else {
+		s.offer = offer
+		s.answer = answer
+	}
+	s.status = &status
+}
+
+func (s *ManualScene) Enter(g *Game) {}
+
+func (s *ManualScene) Exit(g *Game) {}
+
+func (s *ManualScene) Update(g *Game) error {
+	s.mu.Lock()
+	if s.offer != nil {
+		s.offerInput.SetText(*s.offer)
+		s.offer = nil
+	}
+	if s.answer != nil {
+		s.answerInput.SetText(*s.answer)
+		s.answer = nil
+	}
+	if s.status != nil {
+		s.statusText.Label = *s.status
+		s.status = nil
+	}
+	s.mu.Unlock()
+
+	if hostLost.Load() {
+		s.statusText.Label = takeStatus()
+		leaveSession()
+	}
+
+	// on to the lobby once the other player is through
+	lobbyMutex.Lock()
+	connected := (isHost.Load() && len(lobby.Players) > 1) || (!isHost.Load() && hostChannel != nil)
+	lobbyMutex.Unlock()
+	if connected {
+		g.scenes.Replace(newLobbyScene(g))
+		return nil
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		leaveSession()
+		g.scenes.Pop()
+		return nil
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *ManualScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *ManualScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"sync/atomic"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// how long a match lasts, in ticks
+const matchFrames = 60 * 60
+
+// ticks since the match started, shared with the snapshots so a new host can carry on from them
+var matchClock atomic.Int32
+
+// where the gophers were on one tick of a match
+type ReplayFrame struct {
+	Local_x  float64
+	Local_y  float64
+	Remote_x float64
+	Remote_y float64
+	// everyone else, like players sharing a machine or a third player
+	Others []PlayerPosition
+}
+
+// a recording of a match, played back by the replay scene
+type Replay struct {
+	Stage           Stage
+	LocalCharacter  Character
+	RemoteCharacter Character
+	// false when there was nobody on another machine to play against
+	HasRemote bool
+	// characters of the other gophers
+	Characters map[gopherId]Character
+	Frames     []ReplayFrame
+}
+
+// character of a gopher other than the local and remote ones
+func (r *Replay) characterOf(id gopherId) Character {
+	if character, ok := r.Characters[id]; ok {
+		return character
+	}
+	return characterOfGopher(id)
+}
+
+// every gopher's sprite on a frame, for the camera to keep in view
+func (r *Replay) targets(frame ReplayFrame) []image.Rectangle {
+	targets := []image.Rectangle{spriteAt(frame.Local_x, frame.Local_y)}
+	if r.HasRemote {
+		targets = append(targets, spriteAt(frame.Remote_x, frame.Remote_y))
+	}
+	for _, other := range frame.Others {
+		targets = append(targets, spriteAt(other.Pos_x, other.Pos_y))
+	}
+	return targets
+}
+
+// the gophers actually running around
+type MatchScene struct {
+	ui     *ebitenui.UI
+	replay *Replay
+	camera *Camera
+	hud    *Hud
+	// the player the remote gopher belongs to
+	remoteId int
+	// ticks since we last sat one out to let the other side catch up
+	sinceStall int
+}
+
+func newMatchScene(g *Game) *MatchScene {
+	s := &MatchScene{camera: newCamera(), hud: newHud(g)}
+
+	// nothing but the gophers on screen, so no background either
+	s.ui = &ebitenui.UI{
+		Container: widget.NewContainer(),
+	}
+
+	return s
+}
+
+func (s *MatchScene) Enter(g *Game) {
+	// spectators see the host as the local gopher
+	local_id := local_player_id
+	if isSpectator {
+		local_id = host_player_id
+	}
+	resetHistory()
+	// whoever got the start message late starts a few frames in, so everyone is on the same frame
+	matchClock.Store(sharedFrame())
+	s.remoteId = remotePlayerId()
+	playMusic(stageTrack(currentStage()))
+	s.replay = &Replay{
+		Stage:           currentStage(),
+		LocalCharacter:  characterOf(local_id),
+		RemoteCharacter: characterOf(s.remoteId),
+		// a local match has nobody else in the lobby, so remotePlayerId falls back on us
+		// spectators get everyone besides the host as other gophers
+		HasRemote:  !isSpectator && s.remoteId != local_player_id,
+		Characters: make(map[gopherId]Character),
+	}
+}
+
+// where the remote player's gopher is, and every gopher besides theirs and ours
+func (s *MatchScene) gophers() (float64, float64, []PlayerPosition) {
+	remote_x, remote_y := remote_pos_x, remote_pos_y
+	// spectators only watch, so whoever is at their machine isn't in the match
+	var others []PlayerPosition
+	if !isSpectator {
+		others = localGuestPositions()
+	}
+	for _, position := range remoteGopherPositions() {
+		if position.gopher() == (gopherId{s.remoteId, 0}) {
+			remote_x, remote_y = position.Pos_x, position.Pos_y
+			continue
+		}
+		others = append(others, position)
+	}
+	// in id order, so gophers on top of each other don't flicker
+	slices.SortFunc(others, func(a, b PlayerPosition) int {
+		return compareGophers(a.gopher(), b.gopher())
+	})
+	return remote_x, remote_y, others
+}
+
+// every gopher in the match, as the HUD shows them
+// online gophers aren't fighters yet, so there are only names and connections
+func (s *MatchScene) hudPlayers() []hudPlayer {
+	local_id := local_player_id
+	if isSpectator {
+		local_id = host_player_id
+	}
+	ids := []gopherId{{local_id, 0}}
+	if s.replay.HasRemote {
+		ids = append(ids, gopherId{s.remoteId, 0})
+	}
+	_, _, others := s.gophers()
+	for _, other := range others {
+		ids = append(ids, other.gopher())
+	}
+	tints := make([]color.Color, len(ids))
+	for i, id := range ids {
+		tints[i] = characterOfGopher(id).Tint
+	}
+
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	players := make([]hudPlayer, len(ids))
+	for i, id := range ids {
+		player := hudPlayer{Name: playerName, Tint: tints[i], Ping: -1}
+		owner := lobbyPlayer(id.Player)
+		if owner != nil {
+			player.Name = owner.Name
+			player.Disconnected = owner.Disconnected
+		}
+		if id.Slot > 0 {
+			player.Name += fmt.Sprintf(" (%d)", id.Slot+1)
+		}
+		// the gophers at this machine don't go over the network, and the host only has a round trip
+		// to itself, so what shows for them is how far away from us they are: our own round trip
+		switch {
+		case id.Player == local_player_id:
+		case id.Player == host_player_id && !isHost.Load():
+			if self := lobbyPlayer(local_player_id); self != nil {
+				player.Ping = self.Ping
+			}
+		case owner != nil:
+			player.Ping = owner.Ping
+		}
+		players[i] = player
+	}
+	return players
+}
+
+func (s *MatchScene) Exit(g *Game) {}
+
+func (s *MatchScene) Update(g *Game) error {
+	// nobody could take over from the host, so the match is over
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || hostLost.Load() {
+		leaveSession()
+		g.scenes.Reset(newMainMenuScene(g))
+		return nil
+	}
+
+	// running ahead of the other side, so sit this tick out and let them catch up
+	s.sinceStall++
+	if !isSpectator && s.sinceStall >= stallEvery && frameAdvantage() > maxFrameAdvantage {
+		s.sinceStall = 0
+		s.ui.Update()
+		return nil
+	}
+
+	// spectators only watch, so their input is ignored
+	last_x, last_y := pos_x, pos_y
+	var input uint8
+	if !isSpectator {
+		input = bindingOf(0).read()
+		if input&inputUp != 0 {
+			pos_y -= 1
+		}
+		if input&inputDown != 0 {
+			pos_y += 1
+		}
+		if input&inputLeft != 0 {
+			pos_x -= 1
+		}
+		if input&inputRight != 0 {
+			pos_x += 1
+		}
+		stepLocalGuests()
+	}
+	vel_x, vel_y = pos_x-last_x, pos_y-last_y
+	recordFrame(HistoryFrame{Frame: matchClock.Load(), Input: input, Pos_x: pos_x, Pos_y: pos_y, Remote_x: remote_pos_x, Remote_y: remote_pos_y}, localEntities())
+
+	remote_x, remote_y, others := s.gophers()
+	for _, other := range others {
+		if _, ok := s.replay.Characters[other.gopher()]; !ok {
+			s.replay.Characters[other.gopher()] = characterOfGopher(other.gopher())
+		}
+	}
+	s.replay.Frames = append(s.replay.Frames, ReplayFrame{pos_x, pos_y, remote_x, remote_y, others})
+	s.camera.follow(s.replay.targets(s.replay.Frames[len(s.replay.Frames)-1])...)
+	frame := matchClock.Add(1)
+
+	// spectators stay until they leave, everyone else gets the results
+	if frame >= matchFrames && !isSpectator {
+		g.replay = s.replay
+		g.scenes.Replace(newResultsScene(g))
+	}
+
+	// update the UI
+	s.ui.Update()
+	return nil
+}
+
+func (s *MatchScene) Draw(screen *ebiten.Image) {
+	screen.Fill(s.replay.Stage.Background)
+
+	// the gophers go through the camera, everything after them is drawn straight onto the screen
+	view := s.camera.view()
+	remote_x, remote_y, others := s.gophers()
+	drawFighter(screen, view, pos_x, pos_y, s.replay.LocalCharacter.Tint)
+	if s.replay.HasRemote {
+		drawFighter(screen, view, remote_x, remote_y, s.replay.RemoteCharacter.Tint)
+	}
+	for _, other := range others {
+		drawFighter(screen, view, other.Pos_x, other.Pos_y, s.replay.characterOf(other.gopher()).Tint)
+	}
+
+	// draw the UI onto the screen
+	s.ui.Draw(screen)
+
+	secondsLeft := max(matchFrames-matchClock.Load(), 0) / 60
+	players := s.hudPlayers()
+	s.hud.draw(screen, players, int(secondsLeft), 0, 0)
+
+	// debug details, under the HUD
+	status := fmt.Sprintf("FPS: %.1f\nSpectators: %d", ebiten.ActualFPS(), spectatorCount.Load())
+	if !isSpectator && !isHost.Load() {
+		offset, drift, rtt := hostClock.stats()
+		status += fmt.Sprintf("\nClock: %+.1fms %+.1fppm, rtt %.1fms", offset.Seconds()*1000, drift, rtt.Seconds()*1000)
+	}
+	if !isSpectator {
+		status += fmt.Sprintf("\nFrame advantage: %.1f", frameAdvantage())
+	}
+	if desyncReported.Load() {
+		status += "\nDesync detected, report written"
+	}
+	if migrating.Load() {
+		status += "\nThe host left, waiting for a new one..."
+	}
+	ebitenutil.DebugPrintAt(screen, status, 0, hudHeight(len(players)))
+}
+
+func (s *MatchScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	s.camera.resize(outsideWidth, outsideHeight)
+	return outsideWidth, outsideHeight
+}
+
+// draws a gopher at world coordinates, view is the camera's
+func drawFighter(screen *ebiten.Image, view ebiten.GeoM, x float64, y float64, tint color.Color) {
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Translate(x, y)
+	op.GeoM.Concat(view)
+	op.Filter = ebiten.FilterLinear
+	op.ColorScale.ScaleWithColor(tint)
+	screen.DrawImage(img, op)
+}
+
+// shown once a match is over
+type ResultsScene struct {
+	ui *ebitenui.UI
+}
+
+func newResultsScene(g *Game) *ResultsScene {
+	s := &ResultsScene{}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Match over"))
+
+	lobbyMutex.Lock()
+	for _, player := range lobby.Players {
+		rootContainer.AddChild(newLabel(g, player.Name+" - "+characters[player.Character].Name))
+	}
+	lobbyMutex.Unlock()
+
+	rootContainer.AddChild(newButton(g, "Watch Replay", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newReplayScene(g, g.replay))
+	}))
+
+	rootContainer.AddChild(newButton(g, "Main Menu", func(args *widget.ButtonClickedEventArgs) {
+		leaveSession()
+		g.scenes.Reset(newMainMenuScene(g))
+	}))
+
+	return s
+}
+
+func (s *ResultsScene) Enter(g *Game) {}
+
+func (s *ResultsScene) Exit(g *Game) {}
+
+func (s *ResultsScene) Update(g *Game) error {
+	s.ui.Update()
+	return nil
+}
+
+func (s *ResultsScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *ResultsScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
+
+// plays back a recorded match
+// space pauses, escape goes back
+type ReplayScene struct {
+	replay *Replay
+	camera *Camera
+	frame  int
+	paused bool
+}
+
+func newReplayScene(g *Game, replay *Replay) *ReplayScene {
+	return &ReplayScene{replay: replay, camera: newCamera()}
+}
+
+func (s *ReplayScene) Enter(g *Game) {
+	s.frame = 0
+	s.paused = false
+}
+
+func (s *ReplayScene) Exit(g *Game) {}
+
+func (s *ReplayScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || len(s.replay.Frames) == 0 {
+		g.scenes.Pop()
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		s.paused = !s.paused
+	}
+	// hold the last frame once the replay is over
+	if !s.paused && s.frame < len(s.replay.Frames)-1 {
+		s.frame++
+	}
+	s.camera.follow(s.replay.targets(s.replay.Frames[s.frame])...)
+	return nil
+}
+
+func (s *ReplayScene) Draw(screen *ebiten.Image) {
+	screen.Fill(s.replay.Stage.Background)
+	if len(s.replay.Frames) == 0 {
+		return
+	}
+
+	frame := s.replay.Frames[s.frame]
+	view := s.camera.view()
+	drawFighter(screen, view, frame.Local_x, frame.Local_y, s.replay.LocalCharacter.Tint)
+	if s.replay.HasRemote {
+		drawFighter(screen, view, frame.Remote_x, frame.Remote_y, s.replay.RemoteCharacter.Tint)
+	}
+	for _, other := range frame.Others {
+		drawFighter(screen, view, other.Pos_x, other.Pos_y, s.replay.characterOf(other.gopher()).Tint)
+	}
+
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("Replay %d/%d\nSpace to pause, Escape to go back", s.frame+1, len(s.replay.Frames)))
+}
+
+func (s *ReplayScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	s.camera.resize(outsideWidth, outsideHeight)
+	return outsideWidth, outsideHeight
+}
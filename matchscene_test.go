@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+// online, every gopher gets a name and a connection, but no health bar
+func TestMatchHudPlayers(t *testing.T) {
+	lobbyMutex.Lock()
+	old := lobby
+	lobby = LobbyState{Host: 1, Players: []LobbyPlayer{
+		{Id: 1, Name: "host", Ping: 0},
+		{Id: 2, Name: "me", Ping: 120},
+		{Id: 3, Name: "dropped", Ping: 40, Disconnected: true},
+	}}
+	lobbyMutex.Unlock()
+	oldLocal, oldHost, wasHost := local_player_id, host_player_id, isHost.Load()
+	defer func() {
+		lobbyMutex.Lock()
+		lobby = old
+		lobbyMutex.Unlock()
+		local_player_id, host_player_id = oldLocal, oldHost
+		isHost.Store(wasHost)
+		clearRemoteGophers()
+	}()
+	local_player_id, host_player_id = 2, 1
+	isHost.Store(false)
+	setRemoteGopher(gopherId{3, 0}, 10, 0)
+
+	s := &MatchScene{replay: &Replay{HasRemote: true}, remoteId: 1}
+	players := s.hudPlayers()
+	want := []struct {
+		name         string
+		ping         int64
+		disconnected bool
+	}{
+		// we're at this machine, and the host is as far away as our own round trip to them
+		{"me", -1, false},
+		{"host", 120, false},
+		{"dropped", 40, true},
+	}
+	if len(players) != len(want) {
+		t.Fatalf("%d players on the HUD, want %d", len(players), len(want))
+	}
+	for i, player := range players {
+		if player.Name != want[i].name || player.Ping != want[i].ping || player.Disconnected != want[i].disconnected {
+			t.Errorf("player %d is %+v, want %+v", i, player, want[i])
+		}
+		if player.Fighter {
+			t.Errorf("%s has a health bar", player.Name)
+		}
+	}
+}
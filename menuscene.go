@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"valorzard/gopher-combat/sound"
+)
+
+// the first screen, for hosting, joining or spectating a lobby
+type MainMenuScene struct {
+	ui         *ebitenui.UI
+	statusText *widget.Text
+}
+
+func newMainMenuScene(g *Game) *MainMenuScene {
+	s := &MainMenuScene{}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Gopher Combat"))
+
+	// everything but the title and status goes two to a row, a single column runs off the bottom of the screen
+	grid := newGrid()
+	rootContainer.AddChild(grid)
+
+	// construct a standard textinput widget for the player's name
+	nameTextInput := newTextInput(g, "Player Name", func(text string) {
+		playerName = text
+	})
+	nameTextInput.SetText(playerName)
+	grid.AddChild(nameTextInput)
+
+	// construct a standard textinput widget for lobby id
+	lobbyTextInput := newTextInput(g, "Lobby ID", nil)
+	grid.AddChild(lobbyTextInput)
+
+	grid.AddChild(newButton(g, "Host Game", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newHostScene(g, false))
+	}))
+
+	grid.AddChild(newButton(g, "Join Lobby", func(args *widget.ButtonClickedEventArgs) {
+		fmt.Println(lobbyTextInput.GetText())
+		if err := joinLobby(g, lobbyTextInput.GetText(), false); err != nil {
+			s.statusText.Label = err.Error()
+		}
+	}))
+
+	grid.AddChild(newButton(g, "Browse Lobbies", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newLobbyBrowserScene(g))
+	}))
+
+	grid.AddChild(newButton(g, "LAN", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newLanScene(g))
+	}))
+
+	grid.AddChild(newButton(g, "Copy-Paste Connect", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newManualScene(g))
+	}))
+
+	grid.AddChild(newButton(g, "Spectate", func(args *widget.ButtonClickedEventArgs) {
+		fmt.Println(lobbyTextInput.GetText())
+		if err := joinLobby(g, lobbyTextInput.GetText(), true); err != nil {
+			s.statusText.Label = err.Error()
+		}
+	}))
+
+	grid.AddChild(newButton(g, "Local Match", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newLocalScene(g, true))
+	}))
+
+	grid.AddChild(newButton(g, "Versus CPU", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newCpuScene(g))
+	}))
+
+	grid.AddChild(newButton(g, "Training", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newTrainingScene(g))
+	}))
+
+	grid.AddChild(newButton(g, "Settings", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Push(newSettingsScene(g))
+	}))
+
+	s.statusText = newLabel(g, "")
+	rootContainer.AddChild(s.statusText)
+
+	return s
+}
+
+func (s *MainMenuScene) Enter(g *Game) {
+	// before the status is taken, so it can say if the music won't play
+	playMusic("menu")
+	// say why we ended up back here, if there's a reason
+	s.statusText.Label = takeStatus()
+	// back to the signaling server from the settings after a LAN game
+	lanSignalingAddr = ""
+}
+
+func (s *MainMenuScene) Exit(g *Game) {}
+
+func (s *MainMenuScene) Update(g *Game) error {
+	s.ui.Update()
+	return nil
+}
+
+func (s *MainMenuScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("FPS: %f", ebiten.ActualFPS()))
+}
+
+func (s *MainMenuScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
+
+// joins a lobby as a player or spectator and moves on to the right scene
+// asks for a password first if the lobby turns out to need one
+func joinLobby(g *Game, id string, spectate bool) error {
+	lobbyPassword = ""
+	next, err := connectToLobby(g, id, spectate)
+	if errors.Is(err, errPasswordRequired) {
+		g.scenes.Push(newPasswordScene(g, id, spectate, ""))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	g.scenes.Push(next)
+	return nil
+}
+
+// joins a lobby using lobbyPassword, returning the scene to show once connected
+func connectToLobby(g *Game, id string, spectate bool) (Scene, error) {
+	lobby_id = id
+	isHost.Store(false)
+	isSpectator = spectate
+	if err := startConnection(); err != nil {
+		leaveSession()
+		return nil, err
+	}
+	if spectate {
+		return newMatchScene(g), nil
+	}
+	return newLobbyScene(g), nil
+}
+
+// asks for the password of the lobby being joined
+type PasswordScene struct {
+	ui *ebitenui.UI
+}
+
+func newPasswordScene(g *Game, id string, spectate bool, status string) *PasswordScene {
+	s := &PasswordScene{}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Lobby "+id+" needs a password"))
+
+	passwordTextInput := newTextInput(g, "Password", nil, widget.TextInputOpts.Secure(true))
+	rootContainer.AddChild(passwordTextInput)
+
+	statusText := newLabel(g, status)
+
+	rootContainer.AddChild(newButton(g, "Join", func(args *widget.ButtonClickedEventArgs) {
+		lobbyPassword = passwordTextInput.GetText()
+		next, err := connectToLobby(g, id, spectate)
+		if err != nil {
+			statusText.Label = err.Error()
+			return
+		}
+		g.scenes.Replace(next)
+	}))
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Pop()
+	}))
+
+	rootContainer.AddChild(statusText)
+
+	return s
+}
+
+func (s *PasswordScene) Enter(g *Game) {}
+
+func (s *PasswordScene) Exit(g *Game) {}
+
+func (s *PasswordScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *PasswordScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *PasswordScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
+
+// asks the host how their lobby should show up in the lobby browser
+// LAN lobbies are hosted through our own signaling server instead
+type HostScene struct {
+	ui         *ebitenui.UI
+	statusText *widget.Text
+}
+
+func newHostScene(g *Game, lan bool) *HostScene {
+	s := &HostScene{}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	if lan {
+		rootContainer.AddChild(newLabel(g, "Host LAN Game"))
+	} else {
+		rootContainer.AddChild(newLabel(g, "Host Game"))
+	}
+
+	// labels on the left, what they set on the right
+	grid := newGrid()
+	rootContainer.AddChild(grid)
+
+	if lobbyName == "" {
+		lobbyName = playerName + "'s lobby"
+	}
+	nameTextInput := newTextInput(g, "Lobby Name", func(text string) {
+		lobbyName = text
+	})
+	nameTextInput.SetText(lobbyName)
+	addLabeled(g, grid, "Lobby Name", nameTextInput)
+
+	maxPlayersTextInput := newTextInput(g, "Max Players", func(text string) {
+		// keep the old value until the text is a number again
+		if n, err := strconv.Atoi(text); err == nil && n >= minPlayersToStart {
+			maxPlayers = n
+		}
+	})
+	maxPlayersTextInput.SetText(strconv.Itoa(maxPlayers))
+	addLabeled(g, grid, "Max Players", maxPlayersTextInput)
+
+	regionTextInput := newTextInput(g, "Region", func(text string) {
+		region = text
+	})
+	regionTextInput.SetText(region)
+	addLabeled(g, grid, "Region", regionTextInput)
+
+	// leave empty for a lobby anyone can join
+	passwordTextInput := newTextInput(g, "No password", func(text string) {
+		lobbyPassword = text
+	}, widget.TextInputOpts.Secure(true))
+	addLabeled(g, grid, "Password", passwordTextInput)
+
+	// private lobbies don't show up in the lobby browser or on the LAN, so they can only be joined by id
+	rootContainer.AddChild(newToggle(g, "Private", func(on bool) {
+		privateLobby = on
+	}))
+
+	rootContainer.AddChild(newButton(g, "Create Lobby", func(args *widget.ButtonClickedEventArgs) {
+		if lan {
+			if err := startLanServer(); err != nil {
+				s.statusText.Label = err.Error()
+				return
+			}
+		}
+		isHost.Store(true)
+		isSpectator = false
+		if err := startConnection(); err != nil {
+			leaveSession()
+			s.statusText.Label = err.Error()
+			return
+		}
+		g.scenes.Replace(newLobbyScene(g))
+	}))
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Pop()
+	}))
+
+	s.statusText = newLabel(g, "")
+	rootContainer.AddChild(s.statusText)
+
+	return s
+}
+
+func (s *HostScene) Enter(g *Game) {
+	// a password typed in while joining someone else shouldn't carry over
+	lobbyPassword = ""
+	privateLobby = false
+}
+
+func (s *HostScene) Exit(g *Game) {}
+
+func (s *HostScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *HostScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *HostScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
+
+// where the signaling server and other knobs are set
+type SettingsScene struct {
+	ui *ebitenui.UI
+}
+
+func newSettingsScene(g *Game) *SettingsScene {
+	s := &SettingsScene{}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Settings"))
+
+	// labels on the left, what they set on the right
+	grid := newGrid()
+	rootContainer.AddChild(grid)
+
+	signalingTextInput := newTextInput(g, "Signaling Server IP", func(text string) {
+		signalingIP = text
+	})
+	signalingTextInput.SetText(signalingIP)
+	addLabeled(g, grid, "Signaling Server IP", signalingTextInput)
+
+	portTextInput := newTextInput(g, "Signaling Server Port", func(text string) {
+		// keep the old port until the text is a number again
+		if p, err := strconv.Atoi(text); err == nil {
+			port = p
+		}
+	})
+	portTextInput.SetText(strconv.Itoa(port))
+	addLabeled(g, grid, "Signaling Server Port", portTextInput)
+
+	delayTextInput := newTextInput(g, "Spectator Delay", func(text string) {
+		if d, err := time.ParseDuration(text); err == nil && d >= 0 {
+			spectatorDelay = d
+		}
+	})
+	delayTextInput.SetText(spectatorDelay.String())
+	addLabeled(g, grid, "Spectator Delay", delayTextInput)
+
+	// 0 never relays
+	relayTextInput := newTextInput(g, "Relay Timeout", func(text string) {
+		if d, err := time.ParseDuration(text); err == nil && d >= 0 {
+			relayTimeout = d
+		}
+	})
+	relayTextInput.SetText(relayTimeout.String())
+	addLabeled(g, grid, "Relay Timeout", relayTextInput)
+
+	// volumes are percentages
+	musicTextInput := newTextInput(g, "Music Volume", func(text string) {
+		if v, err := strconv.Atoi(text); err == nil && v >= 0 && v <= 100 {
+			sound.SetMusicVolume(float64(v) / 100)
+		}
+	})
+	musicTextInput.SetText(strconv.Itoa(int(sound.MusicVolume() * 100)))
+	addLabeled(g, grid, "Music Volume", musicTextInput)
+
+	soundTextInput := newTextInput(g, "Sound Volume", func(text string) {
+		if v, err := strconv.Atoi(text); err == nil && v >= 0 && v <= 100 {
+			sound.SetVolume(float64(v) / 100)
+		}
+	})
+	soundTextInput.SetText(strconv.Itoa(int(sound.Volume() * 100)))
+	addLabeled(g, grid, "Sound Volume", soundTextInput)
+
+	// clicking cycles through the ways of scaling the screen up to the window
+	var scaleButton *widget.Button
+	scaleButton = newButton(g, "Scaling: "+scaleModeNames[screenScale], func(args *widget.ButtonClickedEventArgs) {
+		setScaleMode((screenScale + 1) % scaleModes)
+		scaleButton.Text().Label = "Scaling: " + scaleModeNames[screenScale]
+	})
+	grid.AddChild(scaleButton)
+
+	// F11 does the same from anywhere
+	grid.AddChild(newButton(g, "Toggle Fullscreen", func(args *widget.ButtonClickedEventArgs) {
+		ebiten.SetFullscreen(!ebiten.IsFullscreen())
+	}))
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Pop()
+	}))
+
+	return s
+}
+
+func (s *SettingsScene) Enter(g *Game) {}
+
+func (s *SettingsScene) Exit(g *Game) {}
+
+func (s *SettingsScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *SettingsScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *SettingsScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
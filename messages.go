@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelindar/binary"
+	"github.com/pion/webrtc/v4"
+)
+
+// biggest message we expect to read off a data channel
+const maxMessageSize = 4096
+
+// every message on the data channel starts with one of these
+const (
+	// a snapshot of the sender's gopher every tick, see snapshotCodec
+	messageState byte = iota
+	messageHello
+	messageLobbyState
+	messageReady
+	messageSelection
+	messagePing
+	messagePong
+	messageKick
+	messageStart
+	messageSnapshot
+	messageLeave
+	messageTimeRequest
+	messageTimeResponse
+	messageChecksum
+	messageDesync
+)
+
+// sent by a player to the host once their data channel opens
+type HelloMessage struct {
+	Name string
+}
+
+type ReadyMessage struct {
+	Ready bool
+}
+
+// character picked by a player, or stage picked by the host
+type SelectionMessage struct {
+	Character int
+	Stage     int
+}
+
+// round trip probe, the timestamp is echoed back untouched
+type PingMessage struct {
+	SentAt int64
+}
+
+// tells a player they have been removed from the lobby
+type KickMessage struct {
+	Reason string
+}
+
+type StartMessage struct {
+	Stage int
+	// when the match started, in unix nanoseconds on the host's clock
+	StartAt int64
+}
+
+// sent by a player to the host right before they leave on purpose
+type LeaveMessage struct{}
+
+// a player asking the host for the time, and telling it where their match is
+type TimeRequestMessage struct {
+	// unix nanoseconds on the player's clock
+	SentAt int64
+	Frame  int32
+	// when Frame was current, in unix nanoseconds on the host's clock as far as the player knows
+	FrameAt int64
+}
+
+// the host's answer, times in unix nanoseconds on the host's clock apart from SentAt
+type TimeResponseMessage struct {
+	SentAt     int64
+	ReceivedAt int64
+	RepliedAt  int64
+	// where the host's match was at RepliedAt
+	Frame int32
+}
+
+// the checksum of the game state the sender's snapshot on Tick was taken from, on Frame of their match
+// the other side checks it against the game state they applied that snapshot to
+type ChecksumMessage struct {
+	Tick     uint16
+	Frame    int32
+	Checksum uint32
+}
+
+// sent by whoever finds a checksum that doesn't match, with their side of the story
+// the other side writes a report and replies with theirs, so both reports have both sides
+type DesyncMessage struct {
+	Tick     uint16
+	Frame    int32
+	Snapshot []EntityState
+	Checksum uint32
+	History  []HistoryFrame
+	Reply    bool
+}
+
+// where a gopher was when the host took a snapshot
+type PlayerPosition struct {
+	Id int
+	// the same as EntityState's
+	Slot  uint8
+	Pos_x float64
+	Pos_y float64
+}
+
+// the match as the host last saw it, what a new host carries on from
+type MatchSnapshot struct {
+	Frame     int32
+	Positions []PlayerPosition
+}
+
+func encodeMessage(kind byte, v any) ([]byte, error) {
+	encoded, err := binary.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{kind}, encoded...), nil
+}
+
+func decodeMessage(message []byte, v any) error {
+	if len(message) < 1 {
+		return errors.New("empty message")
+	}
+	return binary.Unmarshal(message[1:], v)
+}
+
+// snapshots go over a data channel of their own where there is one, unordered and never
+// retransmitted, so a lost snapshot doesn't hold up the ones after it and counts as lost
+// both ends create it under the same id as soon as they have a connection, rather than one
+// announcing it to the other, so it's there before anything can arrive on it
+const (
+	stateChannelLabel = "state"
+	stateChannelId    = 64
+)
+
+func newStateChannel(pc *webrtc.PeerConnection) *webrtc.DataChannel {
+	ordered, retransmits, negotiated, id := false, uint16(0), true, uint16(stateChannelId)
+	dc, err := pc.CreateDataChannel(stateChannelLabel, &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &retransmits,
+		Negotiated:     &negotiated,
+		ID:             &id,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return dc
+}
+
+// the state channel of a peerChannel once it's open, see stateChannelLabel
+type stateChannel struct {
+	rw io.ReadWriteCloser
+	dc *webrtc.DataChannel
+}
+
+// a detached data channel to another peer, or a relay standing in for one
+// writes can come from several goroutines, so they are serialized here
+type peerChannel struct {
+	rw io.ReadWriteCloser
+	mu sync.Mutex
+
+	// the data channel behind rw, nil when relaying, where there's no send buffer to watch
+	dc *webrtc.DataChannel
+	// signalled once the send buffer snapshots go into drains below bufferedLow
+	drained chan struct{}
+
+	// the state channel once it's open, nil until then and for good over a relay
+	state atomic.Pointer[stateChannel]
+	// whether our snapshots go over state rather than rw, which they do from the start for
+	// the player who opened the connection, and for the host once it has heard from them on it
+	overState atomic.Bool
+	// the round trip of our last ping over this channel, in nanoseconds, 0 before one comes back
+	rtt atomic.Int64
+
+	// the snapshots going each way over this channel
+	snapshots snapshotCodec
+	// held while a snapshot is decoded and applied, which can happen on rw and state at once
+	applying sync.Mutex
+	// set once a desync on this channel has been reported, so it's only reported once
+	desynced atomic.Bool
+}
+
+// dc is the data channel rw was detached from, or nil if rw is a relay
+func newPeerChannel(rw io.ReadWriteCloser, dc *webrtc.DataChannel) *peerChannel {
+	c := &peerChannel{rw: rw, dc: dc, drained: make(chan struct{}, 1)}
+	if dc != nil {
+		c.watchBuffered(dc)
+	}
+	return c
+}
+
+func (c *peerChannel) watchBuffered(dc *webrtc.DataChannel) {
+	dc.SetBufferedAmountLowThreshold(bufferedLow)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case c.drained <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// takes snapshots over dc as well once it opens, and sends ours over it straight away if first
+// player_id is who is on the other end
+func (c *peerChannel) openState(player_id int, dc *webrtc.DataChannel, first bool) {
+	dc.OnOpen(func() {
+		raw, err := dc.Detach()
+		if err != nil {
+			panic(err)
+		}
+		c.watchBuffered(dc)
+		c.state.Store(&stateChannel{raw, dc})
+		if first {
+			c.overState.Store(true)
+		}
+		go StateReadLoop(player_id, c, raw)
+	})
+}
+
+// the state channel if our snapshots go over it, nil if they go over rw
+func (c *peerChannel) snapshotChannel() *stateChannel {
+	if !c.overState.Load() {
+		return nil
+	}
+	return c.state.Load()
+}
+
+// bytes queued on the data channel snapshots go over that haven't gone out yet
+func (c *peerChannel) buffered() uint64 {
+	if state := c.snapshotChannel(); state != nil {
+		return state.dc.BufferedAmount()
+	}
+	if c.dc == nil {
+		return 0
+	}
+	return c.dc.BufferedAmount()
+}
+
+// the round trip of our last ping over this channel, 0 before one has come back
+func (c *peerChannel) roundTrip() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
+func (c *peerChannel) send(kind byte, v any) error {
+	encoded, err := encodeMessage(kind, v)
+	if err != nil {
+		return err
+	}
+	return c.write(encoded)
+}
+
+// sends a message that has already been encoded, kind and all
+func (c *peerChannel) write(message []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.rw.Write(message)
+	return err
+}
+
+// sends a snapshot message, over the state channel if that's where ours go
+// only ever called from WriteLoop, so it needs no lock of its own
+func (c *peerChannel) writeState(message []byte) error {
+	if state := c.snapshotChannel(); state != nil {
+		_, err := state.rw.Write(message)
+		return err
+	}
+	return c.write(message)
+}
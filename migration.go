@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"valorzard/gopher-combat/signaling"
+)
+
+// how long players wait for a new host, and a new host waits for the players, before giving up on them
+const migrationTimeout = 15 * time.Second
+
+// the signaling server only lets someone take over once the host has left or its slot has expired,
+// so after a crash a new host can take this long to get the lobby
+const hostGoneTimeout = signaling.SlotTimeout + migrationTimeout
+
+var (
+	// the last snapshot the host sent us, used by players
+	lastSnapshot MatchSnapshot
+	// where every player's gopher last was, used by the host
+	player_positions = make(map[int]Packet)
+
+	// set while the lobby looks for a new host
+	migrating atomic.Bool
+	// set when nobody could take over from the host, which ends the session
+	hostLost atomic.Bool
+)
+
+// the match as the host sees it right now
+func takeSnapshot() MatchSnapshot {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	snapshot := MatchSnapshot{
+		Frame:     matchClock.Load(),
+		Positions: []PlayerPosition{{Id: local_player_id, Pos_x: pos_x, Pos_y: pos_y}},
+	}
+	for player_id, packet := range player_positions {
+		snapshot.Positions = append(snapshot.Positions, PlayerPosition{Id: player_id, Pos_x: packet.Pos_x, Pos_y: packet.Pos_y})
+	}
+	return snapshot
+}
+
+// sends the match as the host sees it to every player
+// a new host carries on from the last one of these if the host leaves
+func broadcastSnapshot() {
+	snapshot := takeSnapshot()
+	lobbyMutex.Lock()
+	channels := make([]*peerChannel, 0, len(lobby_channels))
+	for _, c := range lobby_channels {
+		channels = append(channels, c)
+	}
+	lobbyMutex.Unlock()
+
+	for _, c := range channels {
+		c.send(messageSnapshot, &snapshot)
+	}
+}
+
+// puts the match back where the last snapshot had it, so everyone carries on from the same point
+func restoreSnapshot() {
+	if !matchStarted.Load() {
+		return
+	}
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	matchClock.Store(lastSnapshot.Frame)
+	for _, position := range lastSnapshot.Positions {
+		if position.gopher() == (gopherId{local_player_id, 0}) {
+			pos_x = position.Pos_x
+			pos_y = position.Pos_y
+		}
+	}
+}
+
+// the player that takes over from the host, which is whoever has the lowest id
+// every player works this out from the same lobby state, so they all agree
+// players still rejoining can't claim the lobby, so they are passed over unless nobody else is left
+func electHost(old_host int) int {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	elected, fallback := -1, -1
+	for _, player := range lobby.Players {
+		if player.Id == old_host {
+			continue
+		}
+		if fallback < 0 || player.Id < fallback {
+			fallback = player.Id
+		}
+		if !player.Disconnected && (elected < 0 || player.Id < elected) {
+			elected = player.Id
+		}
+	}
+	if elected < 0 {
+		return fallback
+	}
+	return elected
+}
+
+// called by players and spectators once their connection to the host is gone
+func migrateHost(ctx context.Context) {
+	migrating.Store(true)
+	lobbyChanged.Store(true)
+	defer func() {
+		migrating.Store(false)
+		lobbyChanged.Store(true)
+	}()
+
+	old_host := host_player_id
+	lobbyMutex.Lock()
+	hostChannel = nil
+	lobbyMutex.Unlock()
+	forgetGophers(old_host)
+	restoreSnapshot()
+
+	// spectators aren't in the lobby, so they never get elected
+	if !isSpectator && electHost(old_host) == local_player_id {
+		if err := takeOverLobby(ctx, old_host); err != nil {
+			giveUpOnHost(err)
+		}
+		return
+	}
+
+	new_host, err := waitForNewHost(ctx, old_host)
+	if err != nil {
+		giveUpOnHost(err)
+		return
+	}
+	fmt.Printf("Player %d took over the lobby, reconnecting\n", new_host)
+	host_player_id = new_host
+	connectToHost(ctx)
+}
+
+// asks the signaling server for the lobby, until it agrees the old host is gone
+func claimLobby(ctx context.Context, old_host int) error {
+	query := url.Values{}
+	query.Set("id", lobby_id)
+	query.Set("host_id", strconv.Itoa(local_player_id))
+	query.Set("old_host_id", strconv.Itoa(old_host))
+	query.Set("host_name", playerName)
+	query.Set("token", getToken())
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	timeout := time.After(hostGoneTimeout)
+	for {
+		resp, err := httpClient.Get(getSignalingURL() + "/lobby/migrate?" + query.Encode())
+		if err != nil {
+			return err
+		}
+		reason, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusTooEarly:
+			// the host hasn't left the lobby, or hasn't been quiet for long enough yet
+		default:
+			return fmt.Errorf("cannot take over lobby %s: %s", lobby_id, strings.TrimSpace(string(reason)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return errors.New("the host never left the lobby")
+		case <-ticker.C:
+		}
+	}
+}
+
+// becomes the host of the lobby we are in, keeping everyone's slots and picks
+func takeOverLobby(ctx context.Context, old_host int) error {
+	if err := claimLobby(ctx, old_host); err != nil {
+		return err
+	}
+	fmt.Printf("Took over lobby %s from player %d\n", lobby_id, old_host)
+
+	isHost.Store(true)
+	host_player_id = local_player_id
+	adoptHostClock()
+	lobbyMutex.Lock()
+	lobby.Host = local_player_id
+	for i, player := range lobby.Players {
+		if player.Id == old_host {
+			lobby.Players = append(lobby.Players[:i], lobby.Players[i+1:]...)
+			break
+		}
+	}
+	clear(lobby_channels)
+	clear(player_positions)
+	lobbyMutex.Unlock()
+	lobbyChanged.Store(true)
+
+	go lobbyLoop(ctx)
+	go acceptPlayers(ctx)
+	go dropMissingPlayers(ctx)
+	return nil
+}
+
+// removes players that haven't reconnected to us since we took over
+func dropMissingPlayers(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(migrationTimeout):
+	}
+
+	lobbyMutex.Lock()
+	players := lobby.Players[:0]
+	for _, player := range lobby.Players {
+		if _, ok := lobby_channels[player.Id]; ok || player.Id == local_player_id {
+			players = append(players, player)
+		} else {
+			fmt.Printf("Player %d never came back, dropping them\n", player.Id)
+		}
+	}
+	lobby.Players = players
+	lobbyMutex.Unlock()
+	broadcastLobby()
+}
+
+// waits for the signaling server to say someone other than old_host hosts the lobby
+func waitForNewHost(ctx context.Context, old_host int) (int, error) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	timeout := time.After(hostGoneTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-timeout:
+			return 0, errors.New("nobody took over the lobby")
+		case <-ticker.C:
+		}
+
+		resp, err := httpClient.Get(getSignalingURL() + "/lobby/info?id=" + url.QueryEscape(lobby_id))
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return 0, fmt.Errorf("lobby %s is gone: %s", lobby_id, resp.Status)
+		}
+		var info signaling.LobbyInfo
+		err = json.NewDecoder(resp.Body).Decode(&info)
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+		if info.HostId != old_host {
+			return info.HostId, nil
+		}
+	}
+}
+
+// ends the session once the lobby can't go on without its host
+func giveUpOnHost(err error) {
+	// leaving the lobby ourselves cancels the context, which isn't worth reporting
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	fmt.Println("Cannot migrate the host:", err)
+	setStatus("The host left: " + err.Error())
+	hostLost.Store(true)
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestElectHost(t *testing.T) {
+	lobbyMutex.Lock()
+	old := lobby
+	lobbyMutex.Unlock()
+	defer func() {
+		lobbyMutex.Lock()
+		lobby = old
+		lobbyMutex.Unlock()
+	}()
+
+	tests := []struct {
+		name    string
+		players []LobbyPlayer
+		want    int
+	}{
+		{"lowest id", []LobbyPlayer{{Id: 1}, {Id: 4}, {Id: 3}}, 3},
+		{"passes over a player rejoining", []LobbyPlayer{{Id: 1}, {Id: 2, Disconnected: true}, {Id: 3}}, 3},
+		{"everyone rejoining", []LobbyPlayer{{Id: 1}, {Id: 5, Disconnected: true}, {Id: 2, Disconnected: true}}, 2},
+		{"nobody left", []LobbyPlayer{{Id: 1}}, -1},
+	}
+	for _, test := range tests {
+		lobbyMutex.Lock()
+		lobby = LobbyState{Host: 1, Players: test.players}
+		lobbyMutex.Unlock()
+		if got := electHost(1); got != test.want {
+			t.Errorf("%s: elected %d, want %d", test.name, got, test.want)
+		}
+	}
+}
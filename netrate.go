@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+)
+
+// snapshots go out somewhere between every tick and ten times a second
+const (
+	minSendInterval = 20 * time.Millisecond
+	maxSendInterval = 100 * time.Millisecond
+)
+
+// a data channel with more than bufferedHigh bytes queued gets nothing more until
+// it drains below bufferedLow, anything above bufferedLow already counts as congested
+const (
+	bufferedLow  = 16 << 10
+	bufferedHigh = 64 << 10
+)
+
+// a link is also congested once round trips or losses get past these
+// round trips are timed by pings and losses counted on the state channel, see stateChannelLabel
+const (
+	congestedRTT  = 250 * time.Millisecond
+	congestedLoss = 0.05
+)
+
+// how often snapshots are sent over one channel, and how much goes into them
+// backs off quickly when the link is congested and creeps back once it isn't
+type sendRate struct {
+	interval time.Duration
+}
+
+func newSendRate() *sendRate {
+	return &sendRate{interval: minSendInterval}
+}
+
+func (r *sendRate) update(rtt time.Duration, loss float64, buffered uint64) {
+	if buffered > bufferedLow || rtt > congestedRTT || loss > congestedLoss {
+		r.interval = min(maxSendInterval, r.interval*3/2)
+	} else {
+		r.interval = max(minSendInterval, r.interval-time.Millisecond)
+	}
+}
+
+// whether snapshots carry velocities, which are the first thing dropped on a slow link
+func (r *sendRate) fullDetail() bool {
+	return r.interval < 2*minSendInterval
+}
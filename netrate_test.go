@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendRateUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		rtt      time.Duration
+		loss     float64
+		buffered uint64
+		want     time.Duration
+	}{
+		{"a clear link stays at the fastest rate", minSendInterval, 30 * time.Millisecond, 0, 0, minSendInterval},
+		{"a clear link creeps back", 60 * time.Millisecond, 30 * time.Millisecond, 0, 0, 59 * time.Millisecond},
+		{"a slow round trip backs off", minSendInterval, congestedRTT + time.Millisecond, 0, 0, 30 * time.Millisecond},
+		{"a round trip right at the limit is fine", 60 * time.Millisecond, congestedRTT, 0, 0, 59 * time.Millisecond},
+		{"losses back off", 40 * time.Millisecond, 0, 0.1, 0, 60 * time.Millisecond},
+		{"a filling buffer backs off", 40 * time.Millisecond, 0, 0, bufferedLow + 1, 60 * time.Millisecond},
+		{"backing off stops at the slowest rate", 80 * time.Millisecond, time.Second, 1, bufferedHigh, maxSendInterval},
+		// no ping back yet
+		{"no round trip measured", 30 * time.Millisecond, 0, 0, 0, 29 * time.Millisecond},
+	}
+	for _, test := range tests {
+		r := &sendRate{interval: test.interval}
+		r.update(test.rtt, test.loss, test.buffered)
+		if r.interval != test.want {
+			t.Errorf("%s: interval %v, want %v", test.name, r.interval, test.want)
+		}
+	}
+}
+
+// a link that is only congested for a moment recovers to the fastest rate
+func TestSendRateRecovers(t *testing.T) {
+	r := newSendRate()
+	for range 10 {
+		r.update(time.Second, 0, 0)
+	}
+	if r.interval != maxSendInterval {
+		t.Fatalf("interval %v after congestion, want %v", r.interval, maxSendInterval)
+	}
+	for range 100 {
+		r.update(50*time.Millisecond, 0, 0)
+	}
+	if r.interval != minSendInterval {
+		t.Errorf("interval %v once the link cleared, want %v", r.interval, minSendInterval)
+	}
+}
+
+func TestSendRateFullDetail(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     bool
+	}{
+		{minSendInterval, true},
+		{2*minSendInterval - time.Millisecond, true},
+		{2 * minSendInterval, false},
+		{maxSendInterval, false},
+	}
+	for _, test := range tests {
+		r := &sendRate{interval: test.interval}
+		if got := r.fullDetail(); got != test.want {
+			t.Errorf("fullDetail at %v is %v, want %v", test.interval, got, test.want)
+		}
+	}
+}
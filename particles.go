@@ -0,0 +1,208 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// particles are only for show: they are spawned from what the simulation did and never read back by it,
+// and they roll their own random numbers, so they can't make two players' matches drift apart
+// and can be thrown away whenever the simulation is rewound
+
+// more than this and the oldest particles make way, which also keeps the vertices within uint16 indices
+const maxParticles = 4096
+
+// how an effect throws out its particles
+type effect struct {
+	Count int
+	// how far either side of the direction it's thrown in a particle can go, in radians
+	Spread   float64
+	MinSpeed float64
+	MaxSpeed float64
+	// in ticks
+	MinLife int
+	MaxLife int
+	// added to the vertical speed every tick, negative rises
+	Gravity float64
+	// fraction of its speed a particle keeps every tick
+	Drag      float64
+	StartSize float32
+	EndSize   float32
+	// color at birth and at death, faded between over the particle's life
+	From color.NRGBA
+	To   color.NRGBA
+}
+
+var (
+	hitSpark = effect{
+		Count: 14, Spread: math.Pi / 3, MinSpeed: 2, MaxSpeed: 6, MinLife: 8, MaxLife: 16,
+		Gravity: 0.15, Drag: 0.9, StartSize: 6, EndSize: 1,
+		From: color.NRGBA{255, 250, 200, 255}, To: color.NRGBA{255, 120, 30, 0},
+	}
+	blockSpark = effect{
+		Count: 8, Spread: math.Pi / 4, MinSpeed: 1.5, MaxSpeed: 4, MinLife: 6, MaxLife: 12,
+		Gravity: 0.05, Drag: 0.85, StartSize: 4, EndSize: 1,
+		From: color.NRGBA{200, 230, 255, 255}, To: color.NRGBA{80, 140, 255, 0},
+	}
+	dustPuff = effect{
+		Count: 6, Spread: math.Pi / 6, MinSpeed: 0.3, MaxSpeed: 1.2, MinLife: 20, MaxLife: 35,
+		Gravity: -0.02, Drag: 0.95, StartSize: 5, EndSize: 12,
+		From: color.NRGBA{190, 170, 140, 160}, To: color.NRGBA{190, 170, 140, 0},
+	}
+	koBurst = effect{
+		Count: 80, Spread: math.Pi, MinSpeed: 2, MaxSpeed: 9, MinLife: 30, MaxLife: 60,
+		Gravity: 0.1, Drag: 0.96, StartSize: 8, EndSize: 2,
+		From: color.NRGBA{255, 255, 255, 255}, To: color.NRGBA{255, 60, 40, 0},
+	}
+)
+
+type particle struct {
+	x  float64
+	y  float64
+	vx float64
+	vy float64
+	// ticks lived so far, out of life
+	age  int
+	life int
+	// the effect it came from, for everything that doesn't differ between particles
+	effect *effect
+}
+
+// every particle in a scene, drawn in one go
+type particleSystem struct {
+	particles []particle
+	rng       *rand.Rand
+
+	// reused between frames
+	vertices []ebiten.Vertex
+	indices  []uint16
+}
+
+// every particle is a quad of this one white pixel, tinted
+var particleImage = func() *ebiten.Image {
+	white := ebiten.NewImage(3, 3)
+	white.Fill(color.White)
+	// the middle pixel, so filtering never pulls in the transparent edge
+	return white.SubImage(image.Rect(1, 1, 2, 2)).(*ebiten.Image)
+}()
+
+func newParticleSystem() *particleSystem {
+	seed := uint64(time.Now().UnixNano())
+	return &particleSystem{rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// throws out an effect at x, y in world coordinates, towards angle in radians, 0 being to the right
+func (p *particleSystem) emit(e *effect, x float64, y float64, angle float64) {
+	for range e.Count {
+		if len(p.particles) >= maxParticles {
+			p.particles = p.particles[1:]
+		}
+		direction := angle + (p.rng.Float64()*2-1)*e.Spread
+		speed := e.MinSpeed + p.rng.Float64()*(e.MaxSpeed-e.MinSpeed)
+		p.particles = append(p.particles, particle{
+			x:      x,
+			y:      y,
+			vx:     math.Cos(direction) * speed,
+			vy:     math.Sin(direction) * speed,
+			life:   e.MinLife + p.rng.IntN(e.MaxLife-e.MinLife+1),
+			effect: e,
+		})
+	}
+}
+
+// moves every particle on by a tick and drops the ones that have died
+func (p *particleSystem) update() {
+	alive := p.particles[:0]
+	for _, particle := range p.particles {
+		particle.age++
+		if particle.age >= particle.life {
+			continue
+		}
+		particle.vy += particle.effect.Gravity
+		particle.vx *= particle.effect.Drag
+		particle.vy *= particle.effect.Drag
+		particle.x += particle.vx
+		particle.y += particle.vy
+		alive = append(alive, particle)
+	}
+	p.particles = alive
+}
+
+func (p *particleSystem) clear() {
+	p.particles = p.particles[:0]
+}
+
+// draws every particle through the camera's view in a single DrawTriangles
+func (p *particleSystem) draw(screen *ebiten.Image, view ebiten.GeoM) {
+	if len(p.particles) == 0 {
+		return
+	}
+	p.vertices = p.vertices[:0]
+	p.indices = p.indices[:0]
+	for _, particle := range p.particles {
+		e := particle.effect
+		t := float32(particle.age) / float32(particle.life)
+		half := float64(e.StartSize+(e.EndSize-e.StartSize)*t) / 2
+		// premultiplied, which is what Ebitengine blends with
+		a := lerpChannel(e.From.A, e.To.A, t)
+		r := lerpChannel(e.From.R, e.To.R, t) * a
+		g := lerpChannel(e.From.G, e.To.G, t) * a
+		b := lerpChannel(e.From.B, e.To.B, t) * a
+
+		base := uint16(len(p.vertices))
+		for _, corner := range [4][2]float64{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}} {
+			x, y := view.Apply(particle.x+corner[0]*half, particle.y+corner[1]*half)
+			p.vertices = append(p.vertices, ebiten.Vertex{
+				DstX: float32(x), DstY: float32(y),
+				SrcX: 1, SrcY: 1,
+				ColorR: r, ColorG: g, ColorB: b, ColorA: a,
+			})
+		}
+		p.indices = append(p.indices, base, base+1, base+2, base+1, base+3, base+2)
+	}
+	screen.DrawTriangles(p.vertices, p.indices, particleImage, &ebiten.DrawTrianglesOptions{})
+}
+
+func lerpChannel(from uint8, to uint8, t float32) float32 {
+	return (float32(from) + (float32(to)-float32(from))*t) / 255
+}
+
+// sparks where a hit landed, and a burst if it knocked the defender out
+// called right after stepFighters said the attacker's move connected
+func (p *particleSystem) hit(attacker *Fighter, defender *Fighter, result hitResult) {
+	contact := result.Contact
+	x := float64(contact.Min.X+contact.Max.X) / 2
+	y := float64(contact.Min.Y+contact.Max.Y) / 2
+	forward := 0.0
+	if attacker.Facing < 0 {
+		forward = math.Pi
+	}
+	if result.Blocked {
+		// bounces back off the guard
+		p.emit(&blockSpark, x, y, forward+math.Pi)
+		return
+	}
+	p.emit(&hitSpark, x, y, forward)
+	if defender.Health == 0 {
+		center := defender.hurtbox()
+		p.emit(&koBurst, float64(center.Min.X+center.Max.X)/2, float64(center.Min.Y+center.Max.Y)/2, 0)
+	}
+}
+
+// dust from a fighter's feet when they set off, there's no jumping to land from yet
+// returns whether the fighter is moving, to pass back in as wasMoving next tick
+func (p *particleSystem) footsteps(f *Fighter, last_x float64, last_y float64, wasMoving bool) bool {
+	dx, dy := f.X-last_x, f.Y-last_y
+	moving := dx != 0 || dy != 0
+	if moving && !wasMoving {
+		feet := f.hurtbox()
+		// kicked up behind them
+		p.emit(&dustPuff, float64(feet.Min.X+feet.Max.X)/2, float64(feet.Max.Y), math.Atan2(-dy, -dx))
+	}
+	return moving
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// how long the host keeps a player's slot after losing them, so they can rejoin
+const reconnectGrace = 30 * time.Second
+
+// how long a rejoining player tries to get through before deciding the host is gone
+const rejoinTimeout = 10 * time.Second
+
+var (
+	// whether our current connection to the host is up, used by players and spectators
+	hostConnected atomic.Bool
+	// set after rejoining until the host has sent us the state of the match again
+	resyncing atomic.Bool
+)
+
+// gets our old slot back from the signaling server and connects to the host again
+// falls back to migrating if the host doesn't answer, as it has probably gone
+// the error is for when the signaling server won't give the slot back, and there's nothing to fall back on
+func rejoinHost(ctx context.Context) error {
+	query := url.Values{}
+	query.Set("id", lobby_id)
+	query.Set("player_id", strconv.Itoa(local_player_id))
+	query.Set("token", getToken())
+	resp, err := httpClient.Get(getSignalingURL() + "/lobby/rejoin?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return fmt.Errorf("cannot rejoin lobby %s: %s", lobby_id, strings.TrimSpace(string(reason)))
+	}
+	var player_data PlayerData
+	err = json.NewDecoder(resp.Body).Decode(&player_data)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("cannot rejoin lobby %s: %w", lobby_id, err)
+	}
+	fmt.Printf("Rejoined lobby %s as %d\n", lobby_id, player_data.Id)
+
+	resyncing.Store(true)
+	connectToHost(ctx)
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(rejoinTimeout)
+	for !hostConnected.Load() {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout:
+			fmt.Println("The host didn't answer, migrating")
+			resyncing.Store(false)
+			if pc := peerConnection.Swap(nil); pc != nil {
+				pc.Close()
+			}
+			migrateHost(ctx)
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// keeps the slot of a player whose data channel closed for a while, in case they rejoin
+// c is the channel that closed, which may already have been replaced by a rejoin
+func disconnectPlayer(player_id int, c *peerChannel) {
+	lobbyMutex.Lock()
+	if lobby_channels[player_id] != c {
+		lobbyMutex.Unlock()
+		return
+	}
+	delete(lobby_channels, player_id)
+	if player := lobbyPlayer(player_id); player != nil {
+		player.Disconnected = true
+	}
+	lobbyMutex.Unlock()
+	broadcastLobby()
+
+	ctx := session
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectGrace):
+		}
+		lobbyMutex.Lock()
+		player := lobbyPlayer(player_id)
+		gone := player != nil && player.Disconnected
+		lobbyMutex.Unlock()
+		if gone {
+			fmt.Printf("Player %d never came back, removing them\n", player_id)
+			leaveLobby(player_id)
+		}
+	}()
+}
+
+// brings a player that has just (re)joined up to date with a match in progress, host only
+func resyncPlayer(c *peerChannel) {
+	if !matchStarted.Load() {
+		return
+	}
+	c.send(messageStart, &StartMessage{currentStageIndex(), getMatchStart().UnixNano()})
+	snapshot := takeSnapshot()
+	c.send(messageSnapshot, &snapshot)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// how long ICE gets to open the data channel before we give up on it and relay
+// through the signaling server instead, 0 never relays
+var relayTimeout = 10 * time.Second
+
+// a WebSocket to the signaling server's relay, standing in for a detached data channel
+// like a data channel, every Read gets one whole message and every Write sends one
+type relayConn struct {
+	ctx  context.Context
+	conn *websocket.Conn
+	// closed once the relay goes away, from either end
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// connects to the relay for player_id's slot
+// players pass their own id, the host passes the id of the player it relays for
+func dialRelay(ctx context.Context, player_id int) (*relayConn, error) {
+	query := url.Values{}
+	query.Set("lobby_id", lobby_id)
+	query.Set("player_id", strconv.Itoa(player_id))
+	query.Set("token", getToken())
+	relayUrl := "ws://" + getSignalingAddr() + "/relay?" + query.Encode()
+	fmt.Println(relayUrl)
+	conn, _, err := websocket.Dial(ctx, relayUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(maxMessageSize)
+	return &relayConn{ctx: ctx, conn: conn, done: make(chan struct{})}, nil
+}
+
+func (r *relayConn) Read(p []byte) (int, error) {
+	_, message, err := r.conn.Read(r.ctx)
+	if err != nil {
+		r.Close()
+		return 0, err
+	}
+	if len(message) > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(p, message), nil
+}
+
+func (r *relayConn) Write(p []byte) (int, error) {
+	if err := r.conn.Write(r.ctx, websocket.MessageBinary, p); err != nil {
+		r.Close()
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *relayConn) Close() error {
+	err := errors.New("relay already closed")
+	r.closeOnce.Do(func() {
+		close(r.done)
+		err = r.conn.Close(websocket.StatusNormalClosure, "")
+	})
+	return err
+}
+
+// falls back to the relay if the data channel to the host doesn't open in time
+// the channel's label goes first, so the host can tell players and spectators apart
+func relayToHostIfStuck(ctx context.Context, pc *webrtc.PeerConnection, label string, opened chan struct{}) {
+	if relayTimeout <= 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-opened:
+		return
+	case <-time.After(relayTimeout):
+	}
+	// the connection may have been replaced already, by a rejoin or a new host
+	if !peerConnection.CompareAndSwap(pc, nil) {
+		return
+	}
+
+	fmt.Println("ICE didn't get through in time, relaying through the signaling server")
+	pc.Close()
+
+	relay, err := dialRelay(ctx, local_player_id)
+	if err != nil {
+		giveUpOnHost(fmt.Errorf("cannot relay: %w", err))
+		return
+	}
+	if _, err := relay.Write([]byte(label)); err != nil {
+		giveUpOnHost(fmt.Errorf("cannot relay: %w", err))
+		return
+	}
+	hostConnected.Store(true)
+	openHostChannel(ctx, relay, nil, nil)
+
+	// there is no PeerConnection to watch, so the relay closing is how we find out the host left
+	<-relay.done
+	hostConnected.Store(false)
+	if ctx.Err() == nil && !kicked.Load() {
+		fmt.Println("Relay to the host has closed, migrating")
+		migrateHost(ctx)
+	}
+}
+
+// the host's side of relayToHostIfStuck, for a player we have just answered
+func relayFromHostIfStuck(ctx context.Context, player_id int, pc *webrtc.PeerConnection, opened chan struct{}) {
+	if relayTimeout <= 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-opened:
+		return
+	case <-time.After(relayTimeout):
+	}
+	// the player may have rejoined with a new connection in the meantime
+	peersMutex.Lock()
+	current := peer_connections[player_id] == pc
+	peersMutex.Unlock()
+	if !current {
+		return
+	}
+
+	fmt.Printf("ICE didn't get through to %d in time, relaying through the signaling server\n", player_id)
+	pc.Close()
+
+	relay, err := dialRelay(ctx, player_id)
+	if err != nil {
+		fmt.Printf("cannot relay for %d: %v\n", player_id, err)
+		return
+	}
+	buffer := make([]byte, maxMessageSize)
+	n, err := relay.Read(buffer)
+	if err != nil {
+		fmt.Printf("cannot relay for %d: %v\n", player_id, err)
+		return
+	}
+	handleHostChannel(ctx, player_id, string(buffer[:n]), relay, nil, nil)
+}
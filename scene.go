@@ -0,0 +1,156 @@
+package main
+
+import (
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// how many ticks a fade out (and the fade in after it) lasts
+const transitionFrames = 15
+
+// a screen of the game, like the main menu or a match
+// only the scene on top of the stack is updated and drawn
+type Scene interface {
+	// called when the scene becomes the active one
+	Enter(g *Game)
+	// called when the scene stops being the active one, either because
+	// it was popped or because another scene was pushed on top of it
+	Exit(g *Game)
+	Update(g *Game) error
+	Draw(screen *ebiten.Image)
+	Layout(outsideWidth int, outsideHeight int) (int, int)
+}
+
+// keeps the stack of scenes and fades between them
+type SceneManager struct {
+	game  *Game
+	stack []Scene
+
+	// applied once the active scene has faded out
+	pending func()
+	// ticks left in the current fade
+	fade      int
+	fadingOut bool
+}
+
+func newSceneManager(g *Game) *SceneManager {
+	return &SceneManager{game: g}
+}
+
+// the scene on top of the stack, or nil if there are none
+func (m *SceneManager) Current() Scene {
+	if len(m.stack) == 0 {
+		return nil
+	}
+	return m.stack[len(m.stack)-1]
+}
+
+// puts a scene on top of the active one, which is kept around underneath
+func (m *SceneManager) Push(s Scene) {
+	m.transition(func() {
+		if current := m.Current(); current != nil {
+			current.Exit(m.game)
+		}
+		m.stack = append(m.stack, s)
+		s.Enter(m.game)
+	})
+}
+
+// goes back to the scene underneath the active one
+func (m *SceneManager) Pop() {
+	m.transition(func() {
+		if len(m.stack) < 2 {
+			return
+		}
+		m.Current().Exit(m.game)
+		m.stack = m.stack[:len(m.stack)-1]
+		m.Current().Enter(m.game)
+	})
+}
+
+// swaps the active scene for another one
+func (m *SceneManager) Replace(s Scene) {
+	m.transition(func() {
+		if current := m.Current(); current != nil {
+			current.Exit(m.game)
+			m.stack = m.stack[:len(m.stack)-1]
+		}
+		m.stack = append(m.stack, s)
+		s.Enter(m.game)
+	})
+}
+
+// throws away the whole stack and starts again from a single scene
+func (m *SceneManager) Reset(s Scene) {
+	m.transition(func() {
+		if current := m.Current(); current != nil {
+			current.Exit(m.game)
+		}
+		m.stack = append(m.stack[:0], s)
+		s.Enter(m.game)
+	})
+}
+
+func (m *SceneManager) transition(change func()) {
+	// the very first scene doesn't need to fade anything out
+	if m.Current() == nil {
+		change()
+		m.fade = transitionFrames
+		return
+	}
+	// a later change during a fade out wins over the earlier one
+	m.pending = change
+	if !m.fadingOut {
+		m.fadingOut = true
+		m.fade = transitionFrames
+	}
+}
+
+func (m *SceneManager) Update() error {
+	if m.fadingOut {
+		m.fade--
+		if m.fade > 0 {
+			return nil
+		}
+		m.fadingOut = false
+		m.fade = transitionFrames
+		change := m.pending
+		m.pending = nil
+		change()
+	} else if m.fade > 0 {
+		m.fade--
+	}
+
+	if current := m.Current(); current != nil {
+		return current.Update(m.game)
+	}
+	return nil
+}
+
+func (m *SceneManager) Draw(screen *ebiten.Image) {
+	current := m.Current()
+	if current == nil {
+		return
+	}
+	current.Draw(screen)
+
+	if m.fade <= 0 {
+		return
+	}
+	// fade to black and back
+	alpha := float32(m.fade) / transitionFrames
+	if m.fadingOut {
+		alpha = 1 - alpha
+	}
+	bounds := screen.Bounds()
+	vector.DrawFilledRect(screen, 0, 0, float32(bounds.Dx()), float32(bounds.Dy()), color.NRGBA{A: uint8(alpha * 255)}, false)
+}
+
+func (m *SceneManager) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	if current := m.Current(); current != nil {
+		return current.Layout(outsideWidth, outsideHeight)
+	}
+	return outsideWidth, outsideHeight
+}
@@ -0,0 +1,108 @@
+package signaling
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// how long one end of a relay waits for the other to show up
+const relayPairTimeout = 30 * time.Second
+
+// a player's slot in a lobby, which is what a relay connects the host to
+type relayKey struct {
+	lobbyId  string
+	playerId int
+}
+
+// one end of a relay, waiting for the other
+type relayEnd struct {
+	host   bool
+	conn   *websocket.Conn
+	paired chan *websocket.Conn
+}
+
+// connects a player to the host over WebSockets, for when ICE can't
+// messages from one end are handed to the other as they are
+// the player connects with their own token, the host with theirs and the player's id
+func (s *Server) handleRelay(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	token := query.Get("token")
+
+	s.mu.Lock()
+	l := s.lobbies[query.Get("lobby_id")]
+	id, err := strconv.Atoi(query.Get("player_id"))
+	if l == nil || err != nil || l.players[id] == nil || id == l.info.HostId {
+		s.mu.Unlock()
+		http.Error(w, "no such player", http.StatusNotFound)
+		return
+	}
+	host := lobbyHost(l, id, token)
+	if !host && !slotOwner(l, id, token) {
+		s.mu.Unlock()
+		http.Error(w, "wrong token", http.StatusForbidden)
+		return
+	}
+	key := relayKey{l.info.Id, id}
+	s.mu.Unlock()
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		log.Printf("cannot accept relay for player %d: %v\n", id, err)
+		return
+	}
+	defer conn.CloseNow()
+
+	end := &relayEnd{host: host, conn: conn, paired: make(chan *websocket.Conn, 1)}
+	s.mu.Lock()
+	other := s.relays[key]
+	if other != nil && other.host != host {
+		delete(s.relays, key)
+		other.paired <- conn
+	} else {
+		// a second try from the same end replaces the first
+		s.relays[key] = end
+		other = nil
+	}
+	s.mu.Unlock()
+
+	var peer *websocket.Conn
+	if other != nil {
+		peer = other.conn
+		log.Printf("Relaying player %d in lobby %s\n", id, key.lobbyId)
+	} else {
+		select {
+		case peer = <-end.paired:
+		case <-time.After(relayPairTimeout):
+		case <-r.Context().Done():
+		}
+		if peer == nil {
+			s.mu.Lock()
+			if s.relays[key] == end {
+				delete(s.relays, key)
+			}
+			s.mu.Unlock()
+			conn.Close(websocket.StatusTryAgainLater, "the other end never showed up")
+			return
+		}
+	}
+
+	// each end forwards what it reads, so both directions are covered
+	// once either side goes away, both are closed
+	ctx := context.Background()
+	for {
+		typ, message, err := conn.Read(ctx)
+		if err != nil {
+			peer.Close(websocket.StatusGoingAway, "the other end left")
+			return
+		}
+		if err := peer.Write(ctx, typ, message); err != nil {
+			conn.Close(websocket.StatusGoingAway, "the other end left")
+			return
+		}
+	}
+}
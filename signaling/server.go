@@ -0,0 +1,603 @@
+// Package signaling is a small in-memory signaling server speaking the same
+// protocol as go-signaling-server, so a lobby can be hosted without running
+// anything else. Start it with `go run . server`.
+//
+// On top of that protocol every slot gets a secret token, which is needed for
+// everything done with the slot afterwards: posting its offer and reading its
+// answer, leaving, rejoining and keeping it alive with heartbeats. Only the
+// host's token can list who is waiting, read offers and post answers, so
+// nobody else can hijack a connection. Players that can't get through with
+// ICE can have their messages relayed to the host over a WebSocket. Slots that stop sending heartbeats
+// expire, and so do lobbies with nobody left in them. Another player can only
+// take the lobby over once the host's slot is gone.
+package signaling
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the host takes the first slot in a new lobby
+const hostPlayerId = 0
+
+// used when the host doesn't say how many players fit
+const defaultMaxPlayers = 2
+
+// how long a slot is kept without a heartbeat, lobbies go once every slot has
+// a lobby can only be taken over from a host that has left or gone quiet for this long
+const SlotTimeout = 30 * time.Second
+
+// biggest SessionDescription that can be posted, real ones are a few kilobytes
+const maxDescriptionSize = 64 << 10
+
+// handed out by /lobby/join, same shape as go-signaling-server
+// plus the token that proves the slot is ours, for rejoining, leaving and heartbeats
+type PlayerData struct {
+	Id    int
+	Token string
+}
+
+// handed out by /lobby/host
+// go-signaling-server answers with just the id as plain text instead
+type HostData struct {
+	Id    string
+	Token string
+}
+
+// what the lobby browser shows about a lobby, as returned by /lobby/list
+type LobbyInfo struct {
+	Id         string
+	Name       string
+	HostName   string
+	Players    int
+	MaxPlayers int
+	Spectators int
+	Password   bool
+	Region     string
+	// player id of whoever hosts the lobby right now, which changes when the host migrates
+	HostId int
+}
+
+type lobby struct {
+	info LobbyInfo
+	// private lobbies are left out of /lobby/list
+	private bool
+	// as hashed by HashPassword, empty if there is no password
+	passwordHash string
+	players      map[int]*player
+	nextPlayerId int
+}
+
+// a player or spectator that has joined a lobby
+type player struct {
+	spectator bool
+	// proves a request comes from whoever has the slot
+	token string
+	// last time we heard from them, slots that go quiet expire
+	lastSeen time.Time
+	// SessionDescriptions exchanged through the server, as posted
+	offer  []byte
+	answer []byte
+}
+
+type Server struct {
+	mu      sync.Mutex
+	lobbies map[string]*lobby
+	// relay ends still waiting for the other end
+	relays map[relayKey]*relayEnd
+}
+
+func NewServer() *Server {
+	return &Server{
+		lobbies: make(map[string]*lobby),
+		relays:  make(map[relayKey]*relayEnd),
+	}
+}
+
+// Handler serves the signaling protocol
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /lobby/host", s.handleHost)
+	mux.HandleFunc("GET /lobby/join", s.handleJoin)
+	mux.HandleFunc("GET /lobby/rejoin", s.handleRejoin)
+	mux.HandleFunc("GET /lobby/leave", s.handleLeave)
+	mux.HandleFunc("GET /lobby/heartbeat", s.handleHeartbeat)
+	mux.HandleFunc("GET /lobby/list", s.handleList)
+	mux.HandleFunc("GET /lobby/info", s.handleInfo)
+	mux.HandleFunc("GET /lobby/migrate", s.handleMigrate)
+	mux.HandleFunc("GET /lobby/unregisteredPlayers", s.handleUnregisteredPlayers)
+	mux.HandleFunc("GET /lobby/delete", s.handleDelete)
+	// players offer and the host answers, each through the player's slot
+	mux.HandleFunc("POST /offer/post", s.handlePost(slotOwner, func(p *player) *[]byte {
+		// a new offer starts a new negotiation, like an ICE restart, so the old answer no longer applies
+		p.answer = nil
+		return &p.offer
+	}))
+	mux.HandleFunc("GET /offer/get", s.handleGet(lobbyHost, func(p *player) []byte { return p.offer }))
+	mux.HandleFunc("POST /answer/post", s.handlePost(lobbyHost, func(p *player) *[]byte { return &p.answer }))
+	mux.HandleFunc("GET /answer/get", s.handleGet(slotOwner, func(p *player) []byte { return p.answer }))
+	mux.HandleFunc("GET /relay", s.handleRelay)
+	return allowCORS(mux)
+}
+
+// ListenAndServe runs a signaling server on addr until it fails
+func ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(l)
+}
+
+// Serve runs a signaling server on l until it fails
+func Serve(l net.Listener) error {
+	s := NewServer()
+	go func() {
+		for now := range time.Tick(SlotTimeout / 3) {
+			s.Expire(now)
+		}
+	}()
+	log.Printf("Signaling server listening on %s\n", l.Addr())
+	return http.Serve(l, s.Handler())
+}
+
+// Expire drops every slot that hasn't sent a heartbeat for SlotTimeout,
+// and every lobby left with nobody in it
+func (s *Server) Expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for lobbyId, l := range s.lobbies {
+		for id, p := range l.players {
+			if now.Sub(p.lastSeen) > SlotTimeout {
+				delete(l.players, id)
+				log.Printf("Player %d in lobby %s timed out\n", id, lobbyId)
+			}
+		}
+		if len(l.players) == 0 {
+			delete(s.lobbies, lobbyId)
+			log.Printf("Lobby %s expired\n", lobbyId)
+		}
+	}
+}
+
+// browser clients are served from somewhere else, so let them in
+func allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleHost(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	maxPlayers, err := strconv.Atoi(query.Get("max_players"))
+	if err != nil || maxPlayers < 1 {
+		maxPlayers = defaultMaxPlayers
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := newLobbyId()
+	for s.lobbies[id] != nil {
+		id = newLobbyId()
+	}
+	host := &player{token: newToken(), lastSeen: time.Now()}
+	l := &lobby{
+		info: LobbyInfo{
+			Id:         id,
+			Name:       query.Get("name"),
+			HostName:   query.Get("host_name"),
+			MaxPlayers: maxPlayers,
+			Region:     query.Get("region"),
+			HostId:     hostPlayerId,
+		},
+		players:      map[int]*player{hostPlayerId: host},
+		nextPlayerId: hostPlayerId + 1,
+	}
+	if l.info.Name == "" {
+		l.info.Name = "Lobby " + id
+	}
+	l.private = query.Get("private") == "true"
+	l.passwordHash = query.Get("password_hash")
+	l.info.Password = l.passwordHash != ""
+	s.lobbies[id] = l
+	log.Printf("Lobby %s hosted\n", id)
+
+	writeJSON(w, HostData{id, host.token})
+}
+
+func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	spectator := query.Get("spectate") == "true"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.lobbies[query.Get("id")]
+	if l == nil {
+		http.Error(w, "no such lobby", http.StatusNotFound)
+		return
+	}
+	if l.passwordHash != "" {
+		passwordHash := query.Get("password_hash")
+		if passwordHash == "" {
+			http.Error(w, "password required", http.StatusUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(passwordHash), []byte(l.passwordHash)) != 1 {
+			http.Error(w, "wrong password", http.StatusForbidden)
+			return
+		}
+	}
+	if !spectator && l.playerCount() >= l.info.MaxPlayers {
+		http.Error(w, "lobby is full", http.StatusConflict)
+		return
+	}
+
+	id := l.nextPlayerId
+	l.nextPlayerId++
+	p := &player{spectator: spectator, token: newToken(), lastSeen: time.Now()}
+	l.players[id] = p
+	log.Printf("Player %d joined lobby %s\n", id, l.info.Id)
+
+	writeJSON(w, PlayerData{id, p.token})
+}
+
+// gives a player that lost their connection their old slot back, ready to offer again
+func (s *Server) handleRejoin(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, id, p := s.ownSlot(w, r)
+	if p == nil {
+		return
+	}
+	if id == l.info.HostId {
+		http.Error(w, "the host can't rejoin their own lobby", http.StatusConflict)
+		return
+	}
+
+	p.offer = nil
+	p.answer = nil
+	p.lastSeen = time.Now()
+	log.Printf("Player %d rejoined lobby %s\n", id, l.info.Id)
+
+	writeJSON(w, PlayerData{id, p.token})
+}
+
+// gives up a slot, the lobby stays around for everyone else
+// if the host leaves, the others take over the lobby through /lobby/migrate
+func (s *Server) handleLeave(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, id, p := s.ownSlot(w, r)
+	if p == nil {
+		return
+	}
+	delete(l.players, id)
+	log.Printf("Player %d left lobby %s\n", id, l.info.Id)
+	if len(l.players) == 0 {
+		delete(s.lobbies, l.info.Id)
+		log.Printf("Lobby %s is empty, deleted\n", l.info.Id)
+	}
+}
+
+// keeps a slot from expiring
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, _, p := s.ownSlot(w, r); p != nil {
+		p.lastSeen = time.Now()
+	}
+}
+
+// the lobby and slot named by the id and player_id query parameters,
+// as long as the token matches the slot's
+// writes the error and returns a nil player otherwise
+// s.mu must be held
+func (s *Server) ownSlot(w http.ResponseWriter, r *http.Request) (*lobby, int, *player) {
+	query := r.URL.Query()
+	l := s.lobbies[query.Get("id")]
+	if l == nil {
+		http.Error(w, "no such lobby", http.StatusNotFound)
+		return nil, 0, nil
+	}
+	id, err := strconv.Atoi(query.Get("player_id"))
+	if err != nil {
+		http.Error(w, "bad player_id", http.StatusBadRequest)
+		return nil, 0, nil
+	}
+	p := l.players[id]
+	if p == nil {
+		http.Error(w, "no such player", http.StatusNotFound)
+		return nil, 0, nil
+	}
+	if !slotOwner(l, id, query.Get("token")) {
+		http.Error(w, "wrong token", http.StatusForbidden)
+		return nil, 0, nil
+	}
+	return l, id, p
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	infos := make([]LobbyInfo, 0, len(s.lobbies))
+	for _, l := range s.lobbies {
+		if l.private {
+			continue
+		}
+		info := l.info
+		info.Players = l.playerCount()
+		info.Spectators = len(l.players) - info.Players
+		infos = append(infos, info)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(infos, func(a, b LobbyInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	writeJSON(w, infos)
+}
+
+// a single lobby, private or not, for players that already know its id
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.lobbies[r.URL.Query().Get("id")]
+	if l == nil {
+		http.Error(w, "no such lobby", http.StatusNotFound)
+		return
+	}
+	info := l.info
+	info.Players = l.playerCount()
+	info.Spectators = len(l.players) - info.Players
+	writeJSON(w, info)
+}
+
+// hands the lobby over to another player once the host is gone,
+// either because they left or because their slot has gone without a heartbeat for SlotTimeout
+// everyone else has to offer again, so their old descriptions are dropped
+func (s *Server) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	hostId, err := strconv.Atoi(query.Get("host_id"))
+	if err != nil {
+		http.Error(w, "bad host_id", http.StatusBadRequest)
+		return
+	}
+	oldHostId, err := strconv.Atoi(query.Get("old_host_id"))
+	if err != nil {
+		http.Error(w, "bad old_host_id", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.lobbies[query.Get("id")]
+	if l == nil {
+		http.Error(w, "no such lobby", http.StatusNotFound)
+		return
+	}
+	// someone else may have taken over already
+	if l.info.HostId != oldHostId {
+		http.Error(w, "host has already changed", http.StatusConflict)
+		return
+	}
+	p := l.players[hostId]
+	if p == nil || p.spectator {
+		http.Error(w, "no such player", http.StatusNotFound)
+		return
+	}
+	if !slotOwner(l, hostId, query.Get("token")) {
+		http.Error(w, "wrong token", http.StatusForbidden)
+		return
+	}
+	// a host that is still sending heartbeats hasn't gone anywhere, whatever anyone else says
+	if old := l.players[oldHostId]; old != nil && time.Since(old.lastSeen) <= SlotTimeout {
+		http.Error(w, "the host is still here", http.StatusTooEarly)
+		return
+	}
+
+	delete(l.players, oldHostId)
+	for _, p := range l.players {
+		p.offer = nil
+		p.answer = nil
+	}
+	l.info.HostId = hostId
+	if hostName := query.Get("host_name"); hostName != "" {
+		l.info.HostName = hostName
+	}
+	log.Printf("Lobby %s migrated from player %d to %d\n", l.info.Id, oldHostId, hostId)
+}
+
+// players the host hasn't answered yet
+func (s *Server) handleUnregisteredPlayers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	l := s.lobbies[query.Get("id")]
+	if l == nil {
+		http.Error(w, "no such lobby", http.StatusNotFound)
+		return
+	}
+	if !lobbyHost(l, l.info.HostId, query.Get("token")) {
+		http.Error(w, "only the host can see who is waiting", http.StatusForbidden)
+		return
+	}
+	ids := []int{}
+	for id, p := range l.players {
+		if id != l.info.HostId && p.answer == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	writeJSON(w, ids)
+}
+
+// closes the lobby for everyone, only the host may do this
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := query.Get("id")
+	l := s.lobbies[id]
+	if l == nil {
+		http.Error(w, "no such lobby", http.StatusNotFound)
+		return
+	}
+	if !lobbyHost(l, l.info.HostId, query.Get("token")) {
+		http.Error(w, "only the host can delete the lobby", http.StatusForbidden)
+		return
+	}
+	delete(s.lobbies, id)
+	log.Printf("Lobby %s deleted\n", id)
+}
+
+// decides whether a token allows using the slot of player id
+type authorizer func(l *lobby, id int, token string) bool
+
+// only whoever has the slot
+func slotOwner(l *lobby, id int, token string) bool {
+	p := l.players[id]
+	return p != nil && subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) == 1
+}
+
+// only the host, for any slot
+func lobbyHost(l *lobby, id int, token string) bool {
+	return slotOwner(l, l.info.HostId, token)
+}
+
+// stores a SessionDescription in the field picked by slot
+func (s *Server) handlePost(allowed authorizer, slot func(p *player) *[]byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDescriptionSize))
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, "description too big", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		p := s.player(w, r, allowed)
+		if p == nil {
+			return
+		}
+		*slot(p) = body
+	}
+}
+
+// hands back the SessionDescription in the field picked by slot, once there is one
+func (s *Server) handleGet(allowed authorizer, slot func(p *player) []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		p := s.player(w, r, allowed)
+		if p == nil {
+			return
+		}
+		description := slot(p)
+		if description == nil {
+			http.Error(w, "not posted yet", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(description)
+	}
+}
+
+// the player named by the lobby_id and player_id query parameters,
+// as long as the token query parameter lets the caller at them
+// writes the error and returns nil otherwise
+// s.mu must be held
+func (s *Server) player(w http.ResponseWriter, r *http.Request, allowed authorizer) *player {
+	query := r.URL.Query()
+	l := s.lobbies[query.Get("lobby_id")]
+	id, err := strconv.Atoi(query.Get("player_id"))
+	if l == nil || err != nil || l.players[id] == nil {
+		http.Error(w, "no such player", http.StatusNotFound)
+		return nil
+	}
+	if !allowed(l, id, query.Get("token")) {
+		http.Error(w, "wrong token", http.StatusForbidden)
+		return nil
+	}
+	return l.players[id]
+}
+
+// players in the lobby, counting the host but not spectators
+func (l *lobby) playerCount() int {
+	count := 0
+	for _, p := range l.players {
+		if !p.spectator {
+			count++
+		}
+	}
+	return count
+}
+
+// letters that can't be mistaken for each other when read out loud
+const lobbyIdAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func newLobbyId() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	for i := range b {
+		b[i] = lobbyIdAlphabet[int(b[i])%len(lobbyIdAlphabet)]
+	}
+	return string(b)
+}
+
+// a secret nobody can guess, for handing out to a single player
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// HashPassword is how clients hash a lobby password before sending it,
+// so the plain password never leaves the player's machine
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte("gopher-combat lobby password:" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("cannot encode response: %v\n", err)
+	}
+}
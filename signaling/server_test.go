@@ -0,0 +1,352 @@
+package signaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sends a request straight to the server's handler, no network involved
+func request(t *testing.T, s *Server, method string, path string, query url.Values, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path+"?"+query.Encode(), strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+		t.Fatalf("cannot decode %q: %v", w.Body.String(), err)
+	}
+	return v
+}
+
+func hostLobby(t *testing.T, s *Server, query url.Values) HostData {
+	t.Helper()
+	w := request(t, s, http.MethodGet, "/lobby/host", query, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("hosting: %d %s", w.Code, w.Body.String())
+	}
+	return decode[HostData](t, w)
+}
+
+func joinLobby(t *testing.T, s *Server, id string, query url.Values) PlayerData {
+	t.Helper()
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("id", id)
+	w := request(t, s, http.MethodGet, "/lobby/join", query, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("joining: %d %s", w.Code, w.Body.String())
+	}
+	return decode[PlayerData](t, w)
+}
+
+// the query naming a player's slot for the offer and answer endpoints
+func slotQuery(lobbyId string, playerId int, token string) url.Values {
+	return url.Values{"lobby_id": {lobbyId}, "player_id": {strconv.Itoa(playerId)}, "token": {token}}
+}
+
+func TestHostAndJoin(t *testing.T) {
+	s := NewServer()
+	host := hostLobby(t, s, url.Values{"name": {"Dojo"}, "max_players": {"2"}})
+	player := joinLobby(t, s, host.Id, nil)
+	if player.Id != hostPlayerId+1 {
+		t.Errorf("first player got id %d", player.Id)
+	}
+	if player.Token == "" || player.Token == host.Token {
+		t.Errorf("player token %q, host token %q", player.Token, host.Token)
+	}
+
+	w := request(t, s, http.MethodGet, "/lobby/join", url.Values{"id": {host.Id}}, "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("joining a full lobby: got %d, want %d", w.Code, http.StatusConflict)
+	}
+	// spectators don't take a player's place
+	joinLobby(t, s, host.Id, url.Values{"spectate": {"true"}})
+
+	info := decode[LobbyInfo](t, request(t, s, http.MethodGet, "/lobby/info", url.Values{"id": {host.Id}}, ""))
+	if info.Name != "Dojo" || info.Players != 2 || info.Spectators != 1 {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestListLeavesOutPrivateLobbies(t *testing.T) {
+	s := NewServer()
+	hostLobby(t, s, url.Values{"name": {"B"}})
+	hostLobby(t, s, url.Values{"name": {"A"}})
+	hostLobby(t, s, url.Values{"name": {"Secret"}, "private": {"true"}})
+
+	lobbies := decode[[]LobbyInfo](t, request(t, s, http.MethodGet, "/lobby/list", nil, ""))
+	var names []string
+	for _, info := range lobbies {
+		names = append(names, info.Name)
+	}
+	if strings.Join(names, ",") != "A,B" {
+		t.Errorf("listed %v", names)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	s := NewServer()
+	host := hostLobby(t, s, url.Values{"password_hash": {HashPassword("hunter2")}})
+
+	for _, c := range []struct {
+		password string
+		want     int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusForbidden},
+		{"hunter2", http.StatusOK},
+	} {
+		query := url.Values{"id": {host.Id}}
+		if c.password != "" {
+			query.Set("password_hash", HashPassword(c.password))
+		}
+		if w := request(t, s, http.MethodGet, "/lobby/join", query, ""); w.Code != c.want {
+			t.Errorf("password %q: got %d, want %d", c.password, w.Code, c.want)
+		}
+	}
+}
+
+func TestOfferAndAnswer(t *testing.T) {
+	s := NewServer()
+	host := hostLobby(t, s, nil)
+	player := joinLobby(t, s, host.Id, nil)
+	own := slotQuery(host.Id, player.Id, player.Token)
+	asHost := slotQuery(host.Id, player.Id, host.Token)
+
+	if w := request(t, s, http.MethodPost, "/offer/post", asHost, "offer"); w.Code != http.StatusForbidden {
+		t.Errorf("host posting the player's offer: got %d", w.Code)
+	}
+	if w := request(t, s, http.MethodPost, "/offer/post", own, "offer"); w.Code != http.StatusOK {
+		t.Fatalf("posting an offer: %d %s", w.Code, w.Body.String())
+	}
+
+	waiting := decode[[]int](t, request(t, s, http.MethodGet, "/lobby/unregisteredPlayers", url.Values{"id": {host.Id}, "token": {host.Token}}, ""))
+	if len(waiting) != 1 || waiting[0] != player.Id {
+		t.Errorf("waiting players %v", waiting)
+	}
+
+	if w := request(t, s, http.MethodGet, "/offer/get", own, ""); w.Code != http.StatusForbidden {
+		t.Errorf("player reading their own offer: got %d", w.Code)
+	}
+	if w := request(t, s, http.MethodGet, "/offer/get", asHost, ""); w.Body.String() != "offer" {
+		t.Errorf("host reading the offer: %d %q", w.Code, w.Body.String())
+	}
+
+	if w := request(t, s, http.MethodGet, "/answer/get", own, ""); w.Code != http.StatusNotFound {
+		t.Errorf("answer before it was posted: got %d", w.Code)
+	}
+	if w := request(t, s, http.MethodPost, "/answer/post", own, "answer"); w.Code != http.StatusForbidden {
+		t.Errorf("player answering themselves: got %d", w.Code)
+	}
+	request(t, s, http.MethodPost, "/answer/post", asHost, "answer")
+	if w := request(t, s, http.MethodGet, "/answer/get", own, ""); w.Body.String() != "answer" {
+		t.Errorf("reading the answer: %d %q", w.Code, w.Body.String())
+	}
+
+	// offering again, like an ICE restart, throws the old answer away
+	request(t, s, http.MethodPost, "/offer/post", own, "restart")
+	if w := request(t, s, http.MethodGet, "/answer/get", own, ""); w.Code != http.StatusNotFound {
+		t.Errorf("old answer after a new offer: got %d", w.Code)
+	}
+}
+
+func TestPostTooBig(t *testing.T) {
+	s := NewServer()
+	host := hostLobby(t, s, nil)
+	player := joinLobby(t, s, host.Id, nil)
+	own := slotQuery(host.Id, player.Id, player.Token)
+
+	big := strings.Repeat("x", maxDescriptionSize+1)
+	if w := request(t, s, http.MethodPost, "/offer/post", own, big); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized offer: got %d", w.Code)
+	}
+	if w := request(t, s, http.MethodGet, "/offer/get", slotQuery(host.Id, player.Id, host.Token), ""); w.Code != http.StatusNotFound {
+		t.Errorf("oversized offer was kept: got %d", w.Code)
+	}
+}
+
+// a lobby with a host and two players, for the tests that need someone to take over
+func threePlayerLobby(t *testing.T, s *Server) (HostData, PlayerData, PlayerData) {
+	t.Helper()
+	host := hostLobby(t, s, url.Values{"max_players": {"3"}})
+	return host, joinLobby(t, s, host.Id, nil), joinLobby(t, s, host.Id, nil)
+}
+
+func migrateQuery(lobbyId string, hostId int, oldHostId int, token string) url.Values {
+	return url.Values{
+		"id":          {lobbyId},
+		"host_id":     {strconv.Itoa(hostId)},
+		"old_host_id": {strconv.Itoa(oldHostId)},
+		"token":       {token},
+	}
+}
+
+// makes the host look like it stopped sending heartbeats a while ago
+func silenceHost(s *Server, lobbyId string) {
+	s.mu.Lock()
+	l := s.lobbies[lobbyId]
+	l.players[l.info.HostId].lastSeen = time.Now().Add(-2 * SlotTimeout)
+	s.mu.Unlock()
+}
+
+func TestMigrateWhileHostIsHere(t *testing.T) {
+	s := NewServer()
+	host, player, _ := threePlayerLobby(t, s)
+
+	w := request(t, s, http.MethodGet, "/lobby/migrate", migrateQuery(host.Id, player.Id, hostPlayerId, player.Token), "")
+	if w.Code != http.StatusTooEarly {
+		t.Fatalf("taking over from a host that is still here: got %d", w.Code)
+	}
+	info := decode[LobbyInfo](t, request(t, s, http.MethodGet, "/lobby/info", url.Values{"id": {host.Id}}, ""))
+	if info.HostId != hostPlayerId || info.Players != 3 {
+		t.Errorf("lobby changed anyway: %+v", info)
+	}
+}
+
+func TestMigrateAfterHostLeaves(t *testing.T) {
+	s := NewServer()
+	host, player, other := threePlayerLobby(t, s)
+	leave := url.Values{"id": {host.Id}, "player_id": {strconv.Itoa(hostPlayerId)}, "token": {host.Token}}
+	if w := request(t, s, http.MethodGet, "/lobby/leave", leave, ""); w.Code != http.StatusOK {
+		t.Fatalf("host leaving: %d %s", w.Code, w.Body.String())
+	}
+
+	w := request(t, s, http.MethodGet, "/lobby/migrate", migrateQuery(host.Id, player.Id, hostPlayerId, player.Token), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("taking over: %d %s", w.Code, w.Body.String())
+	}
+	// only one of them gets it
+	w = request(t, s, http.MethodGet, "/lobby/migrate", migrateQuery(host.Id, other.Id, hostPlayerId, other.Token), "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("second take over: got %d", w.Code)
+	}
+	info := decode[LobbyInfo](t, request(t, s, http.MethodGet, "/lobby/info", url.Values{"id": {host.Id}}, ""))
+	if info.HostId != player.Id {
+		t.Errorf("host is %d, want %d", info.HostId, player.Id)
+	}
+}
+
+func TestMigrateAfterHostExpires(t *testing.T) {
+	s := NewServer()
+	host, player, _ := threePlayerLobby(t, s)
+	silenceHost(s, host.Id)
+
+	w := request(t, s, http.MethodGet, "/lobby/migrate", migrateQuery(host.Id, player.Id, hostPlayerId, player.Token), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("taking over from a quiet host: %d %s", w.Code, w.Body.String())
+	}
+	// the old host's token is no good any more, the new host's is
+	waiting := url.Values{"id": {host.Id}, "token": {host.Token}}
+	if w := request(t, s, http.MethodGet, "/lobby/unregisteredPlayers", waiting, ""); w.Code != http.StatusForbidden {
+		t.Errorf("old host listing players: got %d", w.Code)
+	}
+	waiting.Set("token", player.Token)
+	if w := request(t, s, http.MethodGet, "/lobby/unregisteredPlayers", waiting, ""); w.Code != http.StatusOK {
+		t.Errorf("new host listing players: got %d", w.Code)
+	}
+}
+
+func TestMigrateNeedsOwnSlot(t *testing.T) {
+	s := NewServer()
+	host, player, other := threePlayerLobby(t, s)
+	spectator := joinLobby(t, s, host.Id, url.Values{"spectate": {"true"}})
+	silenceHost(s, host.Id)
+
+	for _, c := range []struct {
+		name  string
+		query url.Values
+		want  int
+	}{
+		{"no token", migrateQuery(host.Id, player.Id, hostPlayerId, ""), http.StatusForbidden},
+		{"made up token", migrateQuery(host.Id, player.Id, hostPlayerId, "0123"), http.StatusForbidden},
+		// a token only works for its own slot
+		{"someone else's slot", migrateQuery(host.Id, player.Id, hostPlayerId, other.Token), http.StatusForbidden},
+		{"the old host's token", migrateQuery(host.Id, player.Id, hostPlayerId, host.Token), http.StatusForbidden},
+		{"spectator", migrateQuery(host.Id, spectator.Id, hostPlayerId, spectator.Token), http.StatusNotFound},
+		{"not the host", migrateQuery(host.Id, player.Id, other.Id, player.Token), http.StatusConflict},
+	} {
+		if w := request(t, s, http.MethodGet, "/lobby/migrate", c.query, ""); w.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, w.Code, c.want)
+		}
+	}
+	info := decode[LobbyInfo](t, request(t, s, http.MethodGet, "/lobby/info", url.Values{"id": {host.Id}}, ""))
+	if info.HostId != hostPlayerId {
+		t.Errorf("host changed to %d", info.HostId)
+	}
+}
+
+func TestOnlyHostCanDelete(t *testing.T) {
+	s := NewServer()
+	host, player, _ := threePlayerLobby(t, s)
+
+	if w := request(t, s, http.MethodGet, "/lobby/delete", url.Values{"id": {host.Id}, "token": {player.Token}}, ""); w.Code != http.StatusForbidden {
+		t.Errorf("player deleting the lobby: got %d", w.Code)
+	}
+	if w := request(t, s, http.MethodGet, "/lobby/delete", url.Values{"id": {host.Id}, "token": {host.Token}}, ""); w.Code != http.StatusOK {
+		t.Errorf("host deleting the lobby: got %d", w.Code)
+	}
+	if w := request(t, s, http.MethodGet, "/lobby/info", url.Values{"id": {host.Id}}, ""); w.Code != http.StatusNotFound {
+		t.Errorf("deleted lobby: got %d", w.Code)
+	}
+}
+
+func TestLeaveNeedsOwnToken(t *testing.T) {
+	s := NewServer()
+	host, player, other := threePlayerLobby(t, s)
+	leave := url.Values{"id": {host.Id}, "player_id": {strconv.Itoa(player.Id)}, "token": {other.Token}}
+	if w := request(t, s, http.MethodGet, "/lobby/leave", leave, ""); w.Code != http.StatusForbidden {
+		t.Errorf("leaving someone else's slot: got %d", w.Code)
+	}
+	leave.Set("token", player.Token)
+	if w := request(t, s, http.MethodGet, "/lobby/leave", leave, ""); w.Code != http.StatusOK {
+		t.Errorf("leaving: got %d", w.Code)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	s := NewServer()
+	host, player, other := threePlayerLobby(t, s)
+
+	// everyone but the player who sent a heartbeat went quiet a while ago
+	silenceHost(s, host.Id)
+	s.mu.Lock()
+	s.lobbies[host.Id].players[other.Id].lastSeen = time.Now().Add(-2 * SlotTimeout)
+	s.mu.Unlock()
+	heartbeat := url.Values{"id": {host.Id}, "player_id": {strconv.Itoa(player.Id)}, "token": {player.Token}}
+	if w := request(t, s, http.MethodGet, "/lobby/heartbeat", heartbeat, ""); w.Code != http.StatusOK {
+		t.Fatalf("heartbeat: got %d", w.Code)
+	}
+
+	s.Expire(time.Now())
+	info := decode[LobbyInfo](t, request(t, s, http.MethodGet, "/lobby/info", url.Values{"id": {host.Id}}, ""))
+	if info.Players != 1 {
+		t.Errorf("%d players left, want only the one that sent a heartbeat", info.Players)
+	}
+	// a slot that expired can't be used any more
+	waiting := url.Values{"id": {host.Id}, "token": {host.Token}}
+	if w := request(t, s, http.MethodGet, "/lobby/unregisteredPlayers", waiting, ""); w.Code != http.StatusForbidden {
+		t.Errorf("expired host listing players: got %d", w.Code)
+	}
+	// and whoever is left can take over from the expired host
+	w := request(t, s, http.MethodGet, "/lobby/migrate", migrateQuery(host.Id, player.Id, hostPlayerId, player.Token), "")
+	if w.Code != http.StatusOK {
+		t.Errorf("taking over from an expired host: %d %s", w.Code, w.Body.String())
+	}
+
+	s.Expire(time.Now().Add(2 * SlotTimeout))
+	if w := request(t, s, http.MethodGet, "/lobby/info", url.Values{"id": {host.Id}}, ""); w.Code != http.StatusNotFound {
+		t.Errorf("empty lobby: got %d", w.Code)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// positions are sent in 1/16ths of a pixel and velocities in 1/64ths of a pixel per tick
+const (
+	positionScale = 16
+	velocityScale = 64
+)
+
+// how many bits every quantized field takes when sent in full, signed
+// enough for ±32768 pixels and ±32 pixels per tick
+const (
+	positionBits = 20
+	velocityBits = 12
+)
+
+// changes that fit in this many bits, zigzag encoded, are sent as small deltas
+const smallDeltaBits = 7
+
+// how many snapshots are remembered on each side, deltas are never against anything older
+const snapshotHistory = 256
+
+// one thing in the match that moves
+type EntityState struct {
+	// the player whose machine it's played on
+	Id int
+	// which of the players at that machine plays it, 0 for the player themselves
+	// and counting up from 1 for the guests sharing their machine
+	Slot  uint8
+	Pos_x float64
+	Pos_y float64
+	Vel_x float64
+	Vel_y float64
+}
+
+// an EntityState the way it goes over the wire, in fixed point
+type quantizedEntity struct {
+	id     uint16
+	slot   uint8
+	fields [4]int32
+}
+
+// full width of each of quantizedEntity's fields
+var fieldBits = [4]uint{positionBits, positionBits, velocityBits, velocityBits}
+
+func quantize(value float64, scale float64, bits uint) int32 {
+	limit := float64(int32(1)<<(bits-1) - 1)
+	return int32(max(-limit, min(limit, math.Round(value*scale))))
+}
+
+func quantizeEntity(e EntityState) quantizedEntity {
+	return quantizedEntity{
+		id:   uint16(e.Id),
+		slot: e.Slot,
+		fields: [4]int32{
+			quantize(e.Pos_x, positionScale, positionBits),
+			quantize(e.Pos_y, positionScale, positionBits),
+			quantize(e.Vel_x, velocityScale, velocityBits),
+			quantize(e.Vel_y, velocityScale, velocityBits),
+		},
+	}
+}
+
+func (q quantizedEntity) state() EntityState {
+	return EntityState{
+		Id:    int(q.id),
+		Slot:  q.slot,
+		Pos_x: float64(q.fields[0]) / positionScale,
+		Pos_y: float64(q.fields[1]) / positionScale,
+		Vel_x: float64(q.fields[2]) / velocityScale,
+		Vel_y: float64(q.fields[3]) / velocityScale,
+	}
+}
+
+// packs values into as few bits as they need, most significant bit first
+type bitWriter struct {
+	buf  []byte
+	bits uint
+}
+
+func (w *bitWriter) write(value uint64, bits uint) {
+	for i := int(bits) - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if value>>uint(i)&1 == 1 {
+			w.buf[len(w.buf)-1] |= 0x80 >> (w.bits % 8)
+		}
+		w.bits++
+	}
+}
+
+func (w *bitWriter) writeBool(b bool) {
+	if b {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+}
+
+var errSnapshotTooShort = errors.New("snapshot is cut short")
+
+type bitReader struct {
+	buf  []byte
+	bits uint
+}
+
+func (r *bitReader) read(bits uint) (uint64, error) {
+	if r.bits+bits > uint(len(r.buf))*8 {
+		return 0, errSnapshotTooShort
+	}
+	var value uint64
+	for range bits {
+		value = value<<1 | uint64(r.buf[r.bits/8]>>(7-r.bits%8)&1)
+		r.bits++
+	}
+	return value, nil
+}
+
+func (r *bitReader) readBool() (bool, error) {
+	bit, err := r.read(1)
+	return bit == 1, err
+}
+
+// signed values are written in two's complement, cut down to bits
+func signedBits(value int32, bits uint) uint64 {
+	return uint64(value) & (1<<bits - 1)
+}
+
+func fromSignedBits(value uint64, bits uint) int32 {
+	return int32(int64(value<<(64-bits)) >> (64 - bits))
+}
+
+// small deltas are zigzag encoded, so -1 is 1 and 1 is 2
+func zigzag(value int32) uint64 {
+	return uint64(uint32(value<<1 ^ value>>31))
+}
+
+func unzigzag(value uint64) int32 {
+	return int32(value>>1) ^ -int32(value&1)
+}
+
+// tick counters wrap around, so a is newer than b if it is less than half the range ahead
+func tickNewer(a uint16, b uint16) bool {
+	return int16(a-b) > 0
+}
+
+type sentSnapshot struct {
+	tick     uint16
+	valid    bool
+	entities []quantizedEntity
+	// the checksum of the game state it was taken from, or on the receiving end the state
+	// it was applied to, see stateChecksum
+	checksum uint32
+	// whether checksum has been set, a snapshot can be decoded a moment before it's applied
+	hasChecksum bool
+}
+
+// the sending and receiving ends of the snapshots going over one channel
+// each end sends its own snapshots, deltas against the last one the other end has acked,
+// and acks the other end's snapshots in the header of its own
+type snapshotCodec struct {
+	mu sync.Mutex
+
+	// our snapshots, by tick modulo snapshotHistory
+	tick  uint16
+	sent  [snapshotHistory]sentSnapshot
+	acked uint16
+	// whether the other end has acked anything yet
+	hasAcked bool
+
+	// the other end's snapshots, which theirs are deltas against
+	received  [snapshotHistory]sentSnapshot
+	latest    uint16
+	hasLatest bool
+	// the tick of the snapshot decoded last, which can be older than latest
+	decoded uint16
+
+	// smoothed fraction of the other end's snapshots that never turned up
+	loss float64
+}
+
+// how much of every new measurement goes into the smoothed loss
+const snapshotSmoothing = 0.125
+
+// the layout of a snapshot message, after the message kind:
+//
+//	16 bits  tick
+//	16 bits  newest tick of the other end's we have received, the ack
+//	8 bits   how many ticks back the baseline is, 0 if there is none
+//	8 bits   entity count
+//
+// then for every entity its 16 bit id, 1 bit for whether it's a guest and if so its 8 bit slot, and
+//   - if it isn't in the baseline: every field in full
+//   - otherwise: 1 bit for whether it changed, and if it did, for every field
+//     1 bit for whether it changed, 1 bit for whether it's a small delta,
+//     and then the zigzagged delta or the field in full
+func (c *snapshotCodec) encode(entities []EntityState) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tick++
+	if c.tick == 0 {
+		// 0 is never sent, so a zero ack from a fresh codec never matches anything
+		c.tick++
+	}
+	quantized := make([]quantizedEntity, 0, min(len(entities), math.MaxUint8))
+	for _, e := range entities[:min(len(entities), math.MaxUint8)] {
+		quantized = append(quantized, quantizeEntity(e))
+	}
+
+	var baseline *sentSnapshot
+	if c.hasAcked {
+		back := c.tick - c.acked
+		candidate := &c.sent[c.acked%snapshotHistory]
+		if back > 0 && back <= math.MaxUint8 && candidate.valid && candidate.tick == c.acked {
+			baseline = candidate
+		}
+	}
+
+	w := &bitWriter{buf: make([]byte, 0, 8+len(quantized)*4)}
+	w.write(uint64(c.tick), 16)
+	w.write(uint64(c.latest), 16)
+	if baseline != nil {
+		w.write(uint64(c.tick-baseline.tick), 8)
+	} else {
+		w.write(0, 8)
+	}
+	w.write(uint64(len(quantized)), 8)
+
+	for _, e := range quantized {
+		w.write(uint64(e.id), 16)
+		w.writeBool(e.slot != 0)
+		if e.slot != 0 {
+			w.write(uint64(e.slot), 8)
+		}
+		var base *quantizedEntity
+		if baseline != nil {
+			base = findEntity(baseline.entities, e.id, e.slot)
+		}
+		if base == nil {
+			for i, value := range e.fields {
+				w.write(signedBits(value, fieldBits[i]), fieldBits[i])
+			}
+			continue
+		}
+		changed := e.fields != base.fields
+		w.writeBool(changed)
+		if !changed {
+			continue
+		}
+		for i, value := range e.fields {
+			delta := value - base.fields[i]
+			w.writeBool(delta != 0)
+			if delta == 0 {
+				continue
+			}
+			small := zigzag(delta) < 1<<smallDeltaBits
+			w.writeBool(small)
+			if small {
+				w.write(zigzag(delta), smallDeltaBits)
+			} else {
+				w.write(signedBits(value, fieldBits[i]), fieldBits[i])
+			}
+		}
+	}
+
+	c.sent[c.tick%snapshotHistory] = sentSnapshot{tick: c.tick, valid: true, entities: quantized}
+	return w.buf
+}
+
+// decodes a snapshot from the other end, noting its ack of ours
+// snapshots older than one already decoded are still decoded, but not acked
+func (c *snapshotCodec) decode(message []byte) ([]EntityState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &bitReader{buf: message}
+	header := [4]uint64{}
+	for i, bits := range []uint{16, 16, 8, 8} {
+		value, err := r.read(bits)
+		if err != nil {
+			return nil, err
+		}
+		header[i] = value
+	}
+	tick, ack, back, count := uint16(header[0]), uint16(header[1]), uint16(header[2]), int(header[3])
+
+	var baseline *sentSnapshot
+	if back > 0 {
+		baseline = &c.received[(tick-back)%snapshotHistory]
+		if !baseline.valid || baseline.tick != tick-back {
+			return nil, errors.New("snapshot is a delta against one we never got")
+		}
+	}
+
+	quantized := make([]quantizedEntity, count)
+	for n := range quantized {
+		id, err := r.read(16)
+		if err != nil {
+			return nil, err
+		}
+		e := quantizedEntity{id: uint16(id)}
+		guest, err := r.readBool()
+		if err != nil {
+			return nil, err
+		}
+		if guest {
+			slot, err := r.read(8)
+			if err != nil {
+				return nil, err
+			}
+			e.slot = uint8(slot)
+		}
+		var base *quantizedEntity
+		if baseline != nil {
+			base = findEntity(baseline.entities, e.id, e.slot)
+		}
+		if base == nil {
+			for i, bits := range fieldBits {
+				value, err := r.read(bits)
+				if err != nil {
+					return nil, err
+				}
+				e.fields[i] = fromSignedBits(value, bits)
+			}
+			quantized[n] = e
+			continue
+		}
+
+		e.fields = base.fields
+		changed, err := r.readBool()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; changed && i < len(e.fields); i++ {
+			fieldChanged, err := r.readBool()
+			if err != nil {
+				return nil, err
+			}
+			if !fieldChanged {
+				continue
+			}
+			small, err := r.readBool()
+			if err != nil {
+				return nil, err
+			}
+			if small {
+				delta, err := r.read(smallDeltaBits)
+				if err != nil {
+					return nil, err
+				}
+				e.fields[i] += unzigzag(delta)
+			} else {
+				value, err := r.read(fieldBits[i])
+				if err != nil {
+					return nil, err
+				}
+				e.fields[i] = fromSignedBits(value, fieldBits[i])
+			}
+		}
+		quantized[n] = e
+	}
+
+	c.received[tick%snapshotHistory] = sentSnapshot{tick: tick, valid: true, entities: quantized}
+	c.decoded = tick
+	if !c.hasLatest || tickNewer(tick, c.latest) {
+		if c.hasLatest {
+			missed := tick - c.latest - 1
+			if tick < c.latest {
+				// wrapped around, past the 0 that is never sent
+				missed--
+			}
+			c.loss += (float64(missed)/float64(missed+1) - c.loss) * snapshotSmoothing
+		}
+		c.latest = tick
+		c.hasLatest = true
+	}
+	if ack != 0 && (!c.hasAcked || tickNewer(ack, c.acked)) {
+		c.acked = ack
+		c.hasAcked = true
+	}
+
+	entities := make([]EntityState, len(quantized))
+	for i, e := range quantized {
+		entities[i] = e.state()
+	}
+	return entities, nil
+}
+
+// the smoothed fraction of the other end's snapshots that never turned up
+// only the state channel ever loses any, see stateChannelLabel
+func (c *snapshotCodec) lossRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loss
+}
+
+// whether the snapshot decoded last is older than one decoded before it,
+// which snapshots overtaking each other on the state channel can make it
+func (c *snapshotCodec) stale() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.decoded != c.latest
+}
+
+// notes the checksum of the game state the snapshot we encoded last was taken from,
+// or the one the snapshot we decoded last was applied to, returning its tick
+func (c *snapshotCodec) setChecksum(sent bool, checksum uint32) uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sent {
+		c.sent[c.tick%snapshotHistory].checksum = checksum
+		c.sent[c.tick%snapshotHistory].hasChecksum = true
+		return c.tick
+	}
+	c.received[c.decoded%snapshotHistory].checksum = checksum
+	c.received[c.decoded%snapshotHistory].hasChecksum = true
+	return c.decoded
+}
+
+// a snapshot we sent, or one we received from the other end, if it's still remembered
+// and its checksum has been noted
+func (c *snapshotCodec) lookup(tick uint16, sent bool) ([]EntityState, uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := c.received[tick%snapshotHistory]
+	if sent {
+		snapshot = c.sent[tick%snapshotHistory]
+	}
+	if !snapshot.valid || snapshot.tick != tick || !snapshot.hasChecksum {
+		return nil, 0, false
+	}
+	entities := make([]EntityState, len(snapshot.entities))
+	for i, e := range snapshot.entities {
+		entities[i] = e.state()
+	}
+	return entities, snapshot.checksum, true
+}
+
+func findEntity(entities []quantizedEntity, id uint16, slot uint8) *quantizedEntity {
+	for i := range entities {
+		if entities[i].id == id && entities[i].slot == slot {
+			return &entities[i]
+		}
+	}
+	return nil
+}
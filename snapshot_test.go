@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/kelindar/binary"
+)
+
+// a message on its way over the simulated link
+type benchMessage struct {
+	arrives int
+	message []byte
+}
+
+// a gopher wandering around the way players do, holding a direction for a while
+type benchGopher struct {
+	state  EntityState
+	moving bool
+}
+
+// a link between a sender and a receiver, each with their own codec
+// it only delays and drops messages, it never reorders them
+type snapshotLink struct {
+	rng     *rand.Rand
+	gophers []benchGopher
+	latency int
+	loss    float64
+
+	sender, receiver     snapshotCodec
+	toReceiver, toSender []benchMessage
+	// what every tick we sent should decode to
+	expected map[uint16][]EntityState
+	// every byte that went out from the sender, with the message kind
+	sentBytes int
+	// snapshots that got through and were checked
+	delivered int
+	tick      int
+}
+
+func newSnapshotLink(seed uint64, entities int, latency int, loss float64) *snapshotLink {
+	l := &snapshotLink{
+		rng:      rand.New(rand.NewPCG(seed, 2)),
+		gophers:  make([]benchGopher, entities),
+		latency:  latency,
+		loss:     loss,
+		expected: make(map[uint16][]EntityState),
+	}
+	for i := range l.gophers {
+		l.gophers[i].state = EntityState{Id: i, Pos_x: l.rng.Float64() * 640, Pos_y: l.rng.Float64() * 480}
+	}
+	return l
+}
+
+// moves every gopher on a tick, sends a snapshot each way and delivers whatever has arrived
+// everything received is checked against what was sent
+func (l *snapshotLink) step() error {
+	states := make([]EntityState, len(l.gophers))
+	for i := range l.gophers {
+		g := &l.gophers[i]
+		if l.rng.Float64() < 0.02 {
+			g.moving = !g.moving
+			g.state.Vel_x = float64(l.rng.IntN(3) - 1)
+			g.state.Vel_y = float64(l.rng.IntN(3) - 1)
+		}
+		if g.moving {
+			g.state.Pos_x += g.state.Vel_x
+			g.state.Pos_y += g.state.Vel_y
+		}
+		states[i] = g.state
+	}
+
+	snapshot := l.sender.encode(states)
+	l.sentBytes += 1 + len(snapshot)
+	l.expected[l.sender.tick] = quantizedStates(states)
+	if l.rng.Float64() >= l.loss {
+		l.toReceiver = append(l.toReceiver, benchMessage{l.tick + l.latency, snapshot})
+	}
+	// the receiver sends snapshots of its own, which is how acks get back
+	if ack := l.receiver.encode(nil); l.rng.Float64() >= l.loss {
+		l.toSender = append(l.toSender, benchMessage{l.tick + l.latency, ack})
+	}
+
+	for len(l.toReceiver) > 0 && l.toReceiver[0].arrives <= l.tick {
+		message := l.toReceiver[0].message
+		l.toReceiver = l.toReceiver[1:]
+		snapshotTick := uint16(message[0])<<8 | uint16(message[1])
+		decoded, err := l.receiver.decode(message)
+		if err != nil {
+			return fmt.Errorf("tick %d: %w", snapshotTick, err)
+		}
+		if !equalStates(decoded, l.expected[snapshotTick]) {
+			return fmt.Errorf("tick %d decoded to %v, want %v", snapshotTick, decoded, l.expected[snapshotTick])
+		}
+		delete(l.expected, snapshotTick)
+		l.delivered++
+	}
+	for len(l.toSender) > 0 && l.toSender[0].arrives <= l.tick {
+		message := l.toSender[0].message
+		l.toSender = l.toSender[1:]
+		if _, err := l.sender.decode(message); err != nil {
+			return err
+		}
+	}
+	l.tick++
+	return nil
+}
+
+// states the way they come out the other end
+func quantizedStates(states []EntityState) []EntityState {
+	quantized := make([]EntityState, len(states))
+	for i, state := range states {
+		quantized[i] = quantizeEntity(state).state()
+	}
+	return quantized
+}
+
+func equalStates(a []EntityState, b []EntityState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQuantize(t *testing.T) {
+	tests := []struct {
+		value float64
+		scale float64
+		bits  uint
+		want  int32
+	}{
+		{0, positionScale, positionBits, 0},
+		{1.5, positionScale, positionBits, 24},
+		{-1.5, positionScale, positionBits, -24},
+		// rounds to the nearest step
+		{0.03, positionScale, positionBits, 0},
+		{0.04, positionScale, positionBits, 1},
+		{-0.04, positionScale, positionBits, -1},
+		// clamped to what fits in the bits
+		{1e9, positionScale, positionBits, 1<<19 - 1},
+		{-1e9, positionScale, positionBits, -(1<<19 - 1)},
+		{100, velocityScale, velocityBits, 1<<11 - 1},
+		{-100, velocityScale, velocityBits, -(1<<11 - 1)},
+	}
+	for _, test := range tests {
+		if got := quantize(test.value, test.scale, test.bits); got != test.want {
+			t.Errorf("quantize(%v, %v, %v) = %d, want %d", test.value, test.scale, test.bits, got, test.want)
+		}
+	}
+}
+
+func TestQuantizeError(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 1000 {
+		e := EntityState{
+			Id:    rng.IntN(math.MaxUint16),
+			Slot:  uint8(rng.IntN(4)),
+			Pos_x: (rng.Float64() - 0.5) * 4000,
+			Pos_y: (rng.Float64() - 0.5) * 4000,
+			Vel_x: (rng.Float64() - 0.5) * 40,
+			Vel_y: (rng.Float64() - 0.5) * 40,
+		}
+		q := quantizeEntity(e).state()
+		if q.Id != e.Id ||
+			math.Abs(q.Pos_x-e.Pos_x) > 0.5/positionScale || math.Abs(q.Pos_y-e.Pos_y) > 0.5/positionScale ||
+			math.Abs(q.Vel_x-e.Vel_x) > 0.5/velocityScale || math.Abs(q.Vel_y-e.Vel_y) > 0.5/velocityScale {
+			t.Fatalf("%+v came back as %+v", e, q)
+		}
+		// quantizing again changes nothing
+		if again := quantizeEntity(q).state(); again != q {
+			t.Fatalf("%+v came back as %+v the second time", q, again)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, entities := range []int{0, 1, 8, 255} {
+		l := newSnapshotLink(1, entities, 3, 0)
+		for range 500 {
+			if err := l.step(); err != nil {
+				t.Fatalf("%d entities: %v", entities, err)
+			}
+		}
+		if l.delivered != 500-3 {
+			t.Errorf("%d entities: %d snapshots delivered", entities, l.delivered)
+		}
+	}
+}
+
+func TestSnapshotDeltas(t *testing.T) {
+	var sender, receiver snapshotCodec
+	still := []EntityState{{Id: 1, Pos_x: 100, Pos_y: 200}, {Id: 2, Pos_x: 300, Pos_y: 200}}
+	full := sender.encode(still)
+	if _, err := receiver.decode(full); err != nil {
+		t.Fatal(err)
+	}
+	// the ack goes back, so the next snapshot is a delta
+	if _, err := sender.decode(receiver.encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+	delta := sender.encode(still)
+	if len(delta) >= len(full) {
+		t.Errorf("nothing moved, but the delta is %d bytes against %d in full", len(delta), len(full))
+	}
+	decoded, err := receiver.decode(delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStates(decoded, still) {
+		t.Errorf("got %v", decoded)
+	}
+
+	// a small move, a big jump, and a gopher that wasn't in the baseline
+	moved := []EntityState{{Id: 1, Pos_x: 101, Pos_y: 200, Vel_x: 1}, {Id: 2, Pos_x: -3000, Pos_y: 200}, {Id: 3, Pos_x: 50}}
+	decoded, err = receiver.decode(sender.encode(moved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStates(decoded, moved) {
+		t.Errorf("got %v, want %v", decoded, moved)
+	}
+}
+
+// player 1's first guest and player 257 used to share a gopher id
+func TestSnapshotGuests(t *testing.T) {
+	var sender, receiver snapshotCodec
+	gophers := []EntityState{{Id: 1, Pos_x: 10}, {Id: 1, Slot: 1, Pos_x: 20}, {Id: 257, Pos_x: 30}, {Id: 257, Slot: 3, Pos_x: 40}}
+	decoded, err := receiver.decode(sender.encode(gophers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStates(decoded, gophers) {
+		t.Errorf("got %v, want %v", decoded, gophers)
+	}
+
+	// deltas are against the same slot's gopher, not just the same player's
+	sender.decode(receiver.encode(nil))
+	gophers[1].Pos_x = 21
+	decoded, err = receiver.decode(sender.encode(gophers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStates(decoded, gophers) {
+		t.Errorf("got %v, want %v", decoded, gophers)
+	}
+}
+
+func TestSnapshotCutShort(t *testing.T) {
+	var sender snapshotCodec
+	snapshot := sender.encode([]EntityState{{Id: 1, Pos_x: 100, Pos_y: 200}})
+	for n := range len(snapshot) {
+		var receiver snapshotCodec
+		if _, err := receiver.decode(snapshot[:n]); err == nil {
+			t.Errorf("decoded %d of %d bytes without an error", n, len(snapshot))
+		}
+	}
+}
+
+func TestSnapshotMissingBaseline(t *testing.T) {
+	var sender, receiver, other snapshotCodec
+	receiver.decode(sender.encode([]EntityState{{Id: 1}}))
+	sender.decode(receiver.encode(nil))
+	// a delta against a snapshot this codec never got
+	if _, err := other.decode(sender.encode([]EntityState{{Id: 1, Pos_x: 1}})); err == nil {
+		t.Error("decoded a delta without its baseline")
+	}
+}
+
+func TestSnapshotPacketLoss(t *testing.T) {
+	l := newSnapshotLink(1, 4, 3, 0.2)
+	for range 3000 {
+		if err := l.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// deltas are only ever against what got through, so everything that arrived decoded
+	if l.delivered < 3000*7/10 || l.delivered > 3000*9/10 {
+		t.Errorf("%d of 3000 snapshots delivered", l.delivered)
+	}
+	// the loss is smoothed over every snapshot that arrives, so it reads under the share dropped
+	if loss := l.receiver.lossRate(); loss <= 0.02 || loss >= 0.2 {
+		t.Errorf("loss %v with a fifth of snapshots dropped", loss)
+	}
+	lossless := newSnapshotLink(1, 4, 3, 0)
+	for range 300 {
+		lossless.step()
+	}
+	if loss := lossless.receiver.lossRate(); loss != 0 {
+		t.Errorf("loss %v with nothing dropped", loss)
+	}
+}
+
+func TestSnapshotLossAcrossWrap(t *testing.T) {
+	// one miss counts for half, and every snapshot after it shrinks the loss again
+	missedOne := func(after int) float64 {
+		return 0.5 * snapshotSmoothing * math.Pow(1-snapshotSmoothing, float64(after))
+	}
+	// the sender goes 65533, 65534, 65535, 1, 2, 3, 4, 5, there is no tick 0
+	tests := []struct {
+		name string
+		drop uint16
+		want float64
+	}{
+		{"nothing lost", 0, 0},
+		{"lost before the wrap", math.MaxUint16, missedOne(4)},
+		{"lost after the wrap", 1, missedOne(3)},
+	}
+	for _, test := range tests {
+		var sender, receiver snapshotCodec
+		sender.tick = math.MaxUint16 - 3
+		for range 8 {
+			snapshot := sender.encode([]EntityState{{Id: 1}})
+			if sender.tick == test.drop {
+				continue
+			}
+			if _, err := receiver.decode(snapshot); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if loss := receiver.lossRate(); math.Abs(loss-test.want) > 1e-12 {
+			t.Errorf("%s: loss %v, want %v", test.name, loss, test.want)
+		}
+	}
+}
+
+// a snapshot overtaken on the state channel is still decoded, but counts as stale
+// and never has a checksum noted to check a desync against
+func TestSnapshotOvertaken(t *testing.T) {
+	var sender, receiver snapshotCodec
+	snapshots := make([][]byte, 3)
+	for i := range snapshots {
+		snapshots[i] = sender.encode([]EntityState{{Id: 1, Pos_x: float64(i)}})
+	}
+	for _, i := range []int{0, 2, 1} {
+		if _, err := receiver.decode(snapshots[i]); err != nil {
+			t.Fatal(err)
+		}
+		if stale := receiver.stale(); stale != (i == 1) {
+			t.Errorf("snapshot %d stale: %v", i+1, stale)
+		}
+		if i != 1 {
+			receiver.setChecksum(false, uint32(i))
+		}
+	}
+	if _, _, ok := receiver.lookup(2, false); ok {
+		t.Error("found a checksum for the stale snapshot")
+	}
+	if _, checksum, ok := receiver.lookup(3, false); !ok || checksum != 2 {
+		t.Errorf("checksum %d for the latest snapshot, found %v", checksum, ok)
+	}
+}
+
+func benchmarkSnapshots(b *testing.B, entities int, latency int, loss float64) {
+	// what every gopher's position used to go over the data channel as, once a tick
+	original, err := binary.Marshal(&Packet{100, 200})
+	if err != nil {
+		b.Fatal(err)
+	}
+	l := newSnapshotLink(1, entities, latency, loss)
+	for b.Loop() {
+		if err := l.step(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(l.sentBytes)/float64(l.tick), "bytes/tick")
+	b.ReportMetric(float64(entities*len(original)), "original-bytes/tick")
+}
+
+// how many bytes a tick of snapshots takes, next to sending every gopher as its own packet
+func BenchmarkSnapshots(b *testing.B) {
+	for _, entities := range []int{1, 2, 8, 32, 128} {
+		b.Run(fmt.Sprintf("entities=%d", entities), func(b *testing.B) {
+			benchmarkSnapshots(b, entities, 5, 0)
+		})
+	}
+}
+
+func BenchmarkSnapshotsLossy(b *testing.B) {
+	for _, entities := range []int{1, 2, 8, 32, 128} {
+		b.Run(fmt.Sprintf("entities=%d", entities), func(b *testing.B) {
+			benchmarkSnapshots(b, entities, 5, 0.1)
+		})
+	}
+}
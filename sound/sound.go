@@ -0,0 +1,275 @@
+// Package sound plays the game's sound effects and music. Each is loaded from
+// the audio directory as name.ogg or name.wav, and anything missing is made up
+// on the spot, so the game runs without any of them.
+package sound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"github.com/hajimehoshi/ebiten/v2/audio/vorbis"
+	"github.com/hajimehoshi/ebiten/v2/audio/wav"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+const sampleRate = 48000
+
+// where sounds and music are looked for
+const (
+	soundDir = "audio/"
+	musicDir = "audio/music/"
+)
+
+// how long sounds played by the simulation are remembered for, so a frame
+// simulated again after a rollback doesn't play its sounds a second time
+const soundMemoryFrames = 120
+
+// Effect is a sound effect
+type Effect int
+
+const (
+	Hit Effect = iota
+	Block
+	KO
+	// setting off walking, there is no jumping yet
+	Step
+	effects
+)
+
+// a sound effect, and what to make up in its place if its file is missing:
+// a tone sliding between two pitches, mixed with noise, fading out
+type effectDef struct {
+	Name   string
+	From   float64
+	To     float64
+	Noise  float64
+	Gain   float64
+	Length time.Duration
+}
+
+var effectDefs = [effects]effectDef{
+	Hit:   {"hit", 220, 80, 0.5, 0.8, 120 * time.Millisecond},
+	Block: {"block", 900, 700, 0.2, 0.5, 70 * time.Millisecond},
+	KO:    {"ko", 160, 40, 0.7, 1, 600 * time.Millisecond},
+	Step:  {"step", 120, 100, 0.8, 0.2, 50 * time.Millisecond},
+}
+
+// 0 is silent, 1 is full volume, set from the settings
+var (
+	musicVolume  = 0.5
+	effectVolume = 0.8
+)
+
+var (
+	audioContext *audio.Context
+	// decoded sound effects, by effect
+	effectPCM [effects][]byte
+
+	musicPlayer *audio.Player
+	musicTrack  string
+
+	// sounds the simulation has played recently, see soundMemoryFrames
+	playedEffects = make(map[event]struct{})
+)
+
+// a sound played by the simulation, source tells apart the things that can play the same sound on a frame
+type event struct {
+	Frame  int
+	Source int
+	Effect Effect
+}
+
+// Init starts up audio and loads the sound effects, only the game itself needs this
+// until it's called nothing plays
+func Init() {
+	audioContext = audio.NewContext(sampleRate)
+	for i, def := range effectDefs {
+		pcm, err := loadPCM(soundDir + def.Name)
+		if err != nil {
+			pcm = synthEffect(def)
+		}
+		effectPCM[i] = pcm
+	}
+}
+
+// opens name.ogg or name.wav, decoded to the context's sample rate
+func openAudio(name string) (io.ReadSeeker, int64, error) {
+	if f, err := ebitenutil.OpenFile(name + ".ogg"); err == nil {
+		stream, err := vorbis.DecodeWithSampleRate(sampleRate, f)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s.ogg: %w", name, err)
+		}
+		return stream, stream.Length(), nil
+	}
+	f, err := ebitenutil.OpenFile(name + ".wav")
+	if err != nil {
+		return nil, 0, err
+	}
+	stream, err := wav.DecodeWithSampleRate(sampleRate, f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s.wav: %w", name, err)
+	}
+	return stream, stream.Length(), nil
+}
+
+func loadPCM(name string) ([]byte, error) {
+	stream, _, err := openAudio(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(stream)
+}
+
+// Play plays a sound effect straight away, for things that only happen once like menu clicks
+func Play(e Effect) {
+	if audioContext == nil {
+		return
+	}
+	player := audioContext.NewPlayerFromBytes(effectPCM[e])
+	player.SetVolume(effectVolume)
+	player.Play()
+}
+
+// PlayEvent plays a sound the simulation made on a frame, unless it already has
+// nothing in the simulation depends on whether it played, it only ever calls this
+func PlayEvent(frame int, source int, e Effect) {
+	played := event{frame, source, e}
+	if _, ok := playedEffects[played]; ok {
+		return
+	}
+	playedEffects[played] = struct{}{}
+	for old := range playedEffects {
+		if old.Frame < frame-soundMemoryFrames || old.Frame > frame+soundMemoryFrames {
+			delete(playedEffects, old)
+		}
+	}
+	Play(e)
+}
+
+// ResetEvents forgets the sounds already played, for when the frame count starts over
+func ResetEvents() {
+	clear(playedEffects)
+}
+
+// PlayMusic loops a music track, carrying on if it's already the one playing
+// a track that can't be loaded is made up instead, so only failing to play at all is an error
+func PlayMusic(track string) error {
+	if audioContext == nil || track == musicTrack {
+		return nil
+	}
+	StopMusic()
+
+	var loop *audio.InfiniteLoop
+	if stream, length, err := openAudio(musicDir + track); err == nil {
+		loop = audio.NewInfiniteLoop(stream, length)
+	} else {
+		pcm := synthMusic(track)
+		loop = audio.NewInfiniteLoop(bytes.NewReader(pcm), int64(len(pcm)))
+	}
+	player, err := audioContext.NewPlayer(loop)
+	if err != nil {
+		return fmt.Errorf("cannot play %s: %w", track, err)
+	}
+	musicTrack = track
+	musicPlayer = player
+	musicPlayer.SetVolume(musicVolume)
+	musicPlayer.Play()
+	return nil
+}
+
+// StopMusic stops whatever track is playing
+func StopMusic() {
+	if musicPlayer != nil {
+		musicPlayer.Close()
+		musicPlayer = nil
+	}
+	musicTrack = ""
+}
+
+// MusicVolume is how loud music plays, from 0 for silent to 1 for full volume
+func MusicVolume() float64 {
+	return musicVolume
+}
+
+func SetMusicVolume(volume float64) {
+	musicVolume = volume
+	if musicPlayer != nil {
+		musicPlayer.SetVolume(volume)
+	}
+}
+
+// Volume is how loud sound effects play, like MusicVolume
+func Volume() float64 {
+	return effectVolume
+}
+
+// SetVolume only changes the sound effects played from now on
+func SetVolume(volume float64) {
+	effectVolume = volume
+}
+
+// 16 bit stereo, which is what the audio context plays
+func appendSample(pcm []byte, value float64) []byte {
+	sample := int16(max(-1, min(1, value)) * math.MaxInt16)
+	pcm = binary.LittleEndian.AppendUint16(pcm, uint16(sample))
+	return binary.LittleEndian.AppendUint16(pcm, uint16(sample))
+}
+
+// makes up a sound effect from its definition
+func synthEffect(def effectDef) []byte {
+	samples := int(def.Length.Seconds() * sampleRate)
+	rng := rand.New(rand.NewPCG(uint64(len(def.Name)), uint64(def.From)))
+	pcm := make([]byte, 0, samples*4)
+	phase := 0.0
+	for i := range samples {
+		t := float64(i) / float64(samples)
+		phase += (def.From + (def.To-def.From)*t) / sampleRate
+		tone := math.Sin(2 * math.Pi * phase)
+		noise := rng.Float64()*2 - 1
+		value := (tone*(1-def.Noise) + noise*def.Noise) * def.Gain * (1 - t) * (1 - t)
+		pcm = appendSample(pcm, value)
+	}
+	return pcm
+}
+
+// makes up a music track: arpeggios over four chords, in a key picked from the track's name
+func synthMusic(track string) []byte {
+	const (
+		noteLength    = sampleRate / 8
+		notesPerChord = 16
+	)
+	hash := fnv.New32a()
+	hash.Write([]byte(track))
+	root := 110 * math.Pow(2, float64(hash.Sum32()%7)/12)
+	// I V vi IV, as semitones above the root, each a major or minor triad
+	chords := [][3]float64{{0, 4, 7}, {7, 11, 14}, {9, 12, 16}, {5, 9, 12}}
+	arpeggio := []int{0, 1, 2, 1}
+
+	pcm := make([]byte, 0, len(chords)*notesPerChord*noteLength*4)
+	// the bass carries on across notes, so it doesn't click at every one
+	bassPhase := 0.0
+	for _, chord := range chords {
+		for note := range notesPerChord {
+			octave := float64(note / 8)
+			freq := root * math.Pow(2, chord[arpeggio[note%len(arpeggio)]]/12+octave)
+			bass := root / 2 * math.Pow(2, chord[0]/12)
+			for i := range noteLength {
+				t := float64(i) / sampleRate
+				envelope := math.Exp(-t * 12)
+				// a square wave for the lead, a sine underneath
+				lead := math.Copysign(1, math.Sin(2*math.Pi*freq*t)) * envelope * 0.12
+				bassPhase += bass / sampleRate
+				low := math.Sin(2*math.Pi*bassPhase) * 0.15
+				pcm = appendSample(pcm, lead+low)
+			}
+		}
+	}
+	return pcm
+}
@@ -0,0 +1,46 @@
+package sound
+
+import "testing"
+
+// without Init nothing plays, but which sounds have played is still kept track of
+func TestPlayEventOnce(t *testing.T) {
+	defer ResetEvents()
+	PlayEvent(10, 0, Hit)
+	PlayEvent(10, 0, Hit)
+	// a different source, or a different sound, is a different event
+	PlayEvent(10, 1, Hit)
+	PlayEvent(10, 0, Step)
+	if len(playedEffects) != 3 {
+		t.Errorf("%d events remembered, want 3", len(playedEffects))
+	}
+
+	// long enough after, the old ones are forgotten
+	PlayEvent(11+soundMemoryFrames, 0, Hit)
+	if len(playedEffects) != 1 {
+		t.Errorf("%d events remembered, want 1", len(playedEffects))
+	}
+
+	ResetEvents()
+	if len(playedEffects) != 0 {
+		t.Errorf("%d events remembered after a reset", len(playedEffects))
+	}
+}
+
+func TestPlayMusicWithoutInit(t *testing.T) {
+	if err := PlayMusic("menu"); err != nil {
+		t.Error(err)
+	}
+	if musicPlayer != nil || musicTrack != "" {
+		t.Errorf("playing %q without an audio context", musicTrack)
+	}
+}
+
+func TestSynthEffectLength(t *testing.T) {
+	for _, def := range effectDefs {
+		// 16 bit stereo
+		want := int(def.Length.Seconds()*sampleRate) * 4
+		if got := len(synthEffect(def)); got != want {
+			t.Errorf("%s is %d bytes, want %d", def.Name, got, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelindar/binary"
+)
+
+// label of the data channel a spectator opens to the host
+const spectatorChannelLabel = "spectate"
+
+// how far behind the live game spectators are kept
+var spectatorDelay = 2 * time.Second
+
+// number of spectators currently watching the lobby
+var spectatorCount atomic.Int32
+
+// state of the match as seen by spectators
+type SpectatorPacket struct {
+	Spectators int32
+	// every gopher in the match, encoded by the spectator's own snapshotCodec
+	// spectators never ack, so every snapshot is sent in full
+	Snapshot []byte
+}
+
+type delayedSpectatorPacket struct {
+	sentAt   time.Time
+	entities []EntityState
+}
+
+// SpectatorWriteLoop streams the host's view of the match to a spectator,
+// holding every snapshot back by spectatorDelay, until the session ends or the spectator leaves
+func SpectatorWriteLoop(ctx context.Context, d io.Writer) {
+	spectatorCount.Add(1)
+	defer spectatorCount.Add(-1)
+
+	ticker := time.NewTicker(time.Millisecond * 20)
+	defer ticker.Stop()
+
+	var snapshots snapshotCodec
+	var pending []delayedSpectatorPacket
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+		// the host's own gophers and everyone else's, the same ones the players get
+		entities := append(localEntities(), remoteEntities()...)
+		pending = append(pending, delayedSpectatorPacket{sentAt: now, entities: entities})
+
+		// send everything that has been held back long enough
+		sent := 0
+		for _, delayed := range pending {
+			if now.Sub(delayed.sentAt) < spectatorDelay {
+				break
+			}
+			packet := SpectatorPacket{spectatorCount.Load(), snapshots.encode(delayed.entities)}
+			encoded, err := binary.Marshal(&packet)
+			if err != nil {
+				fmt.Println("Cannot encode a spectator packet; Exit the spectator writeloop:", err)
+				return
+			}
+			if _, err := d.Write(encoded); err != nil {
+				fmt.Println("Spectator left; Exit the spectator writeloop:", err)
+				return
+			}
+			sent++
+		}
+		pending = pending[sent:]
+	}
+}
+
+// SpectatorReadLoop applies the delayed stream from the host
+// the host's gopher is the local one, everyone else's are remote gophers
+func SpectatorReadLoop(d io.Reader) {
+	var snapshots snapshotCodec
+	buffer := make([]byte, maxMessageSize)
+	for {
+		n, err := d.Read(buffer)
+		if err != nil {
+			fmt.Println("Datachannel closed; Exit the spectator readloop:", err)
+			return
+		}
+
+		var packet SpectatorPacket
+		if err := binary.Unmarshal(buffer[:n], &packet); err != nil {
+			fmt.Println("Dropping spectator packet:", err)
+			continue
+		}
+		entities, err := snapshots.decode(packet.Snapshot)
+		if err != nil {
+			fmt.Println("Dropping spectator snapshot:", err)
+			continue
+		}
+
+		for _, entity := range entities {
+			if entity.gopher() == (gopherId{host_player_id, 0}) {
+				pos_x = entity.Pos_x
+				pos_y = entity.Pos_y
+				continue
+			}
+			setRemoteGopher(entity.gopher(), entity.Pos_x, entity.Pos_y)
+		}
+		spectatorCount.Store(packet.Spectators)
+	}
+}
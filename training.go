@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"valorzard/gopher-combat/sound"
+)
+
+// where the gophers start, and go back to on reset
+const (
+	trainingPlayerX = 40.0
+	trainingDummyX  = 300.0
+	trainingY       = 120.0
+)
+
+var (
+	hurtboxColor = color.NRGBA{0x40, 0xff, 0x60, 0xff}
+	hitboxColor  = color.NRGBA{0xff, 0x30, 0x30, 0x80}
+)
+
+// what the dummy does when nobody is controlling it
+type dummyMode int
+
+const (
+	dummyStand dummyMode = iota
+	dummyBlock
+	// the player controls the dummy while their inputs are recorded
+	dummyRecord
+	// the dummy loops the recorded inputs
+	dummyPlayback
+	// the dummy fights back like a normal CPU
+	dummyCpu
+)
+
+var dummyModeNames = []string{"standing", "blocking", "recording", "playing back", "CPU"}
+
+// reads the local player's keyboard into input bits
+// offline modes always use the arrows, whatever the local player is bound to in matches
+func keyboardInput() uint8 {
+	return inputBindings[0].read()
+}
+
+// practice against a dummy, entirely offline
+// P pauses, N advances a frame while paused, H toggles the boxes, R resets,
+// C changes character, B makes the dummy block, 1 records the dummy, 2 plays it back
+// and 3 lets the CPU control it
+type TrainingScene struct {
+	character int
+	player    Fighter
+	dummy     Fighter
+
+	paused    bool
+	showBoxes bool
+	frame     int
+
+	mode      dummyMode
+	recording []uint8
+	playhead  int
+	cpu       *cpuPlayer
+
+	// the last time a move connected, either way
+	lastHit    hitResult
+	lastHitter string
+
+	camera  *Camera
+	effects *particleSystem
+	hud     *Hud
+	// whether the player and the dummy were walking last tick, for the dust
+	moving [2]bool
+}
+
+func newTrainingScene(g *Game) *TrainingScene {
+	s := &TrainingScene{showBoxes: true, cpu: newCpuPlayer(cpuDifficulties[1], 1), camera: newCamera(), effects: newParticleSystem(), hud: newHud(g)}
+	s.reset()
+	return s
+}
+
+func (s *TrainingScene) reset() {
+	s.player = newFighter(trainingPlayerX, trainingY, characters[s.character].Attack)
+	s.dummy = newFighter(trainingDummyX, trainingY, characters[0].Attack)
+	s.dummy.Facing = -1
+	s.playhead = 0
+	s.frame = 0
+	s.moving = [2]bool{}
+	s.effects.clear()
+	sound.ResetEvents()
+}
+
+func (s *TrainingScene) Enter(g *Game) {
+	playMusic(stageTrack(stages[0]))
+}
+
+func (s *TrainingScene) Exit(g *Game) {}
+
+func (s *TrainingScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		s.paused = !s.paused
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyH) {
+		s.showBoxes = !s.showBoxes
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		s.reset()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyC) {
+		s.character = (s.character + 1) % len(characters)
+		s.reset()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyB) {
+		if s.mode == dummyBlock {
+			s.mode = dummyStand
+		} else {
+			s.mode = dummyBlock
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.Key1) {
+		if s.mode == dummyRecord {
+			s.mode = dummyStand
+		} else {
+			s.mode = dummyRecord
+			s.recording = nil
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.Key2) && len(s.recording) > 0 {
+		if s.mode == dummyPlayback {
+			s.mode = dummyStand
+		} else {
+			s.mode = dummyPlayback
+			s.playhead = 0
+		}
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.Key3) {
+		if s.mode == dummyCpu {
+			s.mode = dummyStand
+		} else {
+			s.mode = dummyCpu
+		}
+	}
+
+	if s.paused && !inpututil.IsKeyJustPressed(ebiten.KeyN) {
+		return nil
+	}
+	s.step()
+	s.camera.follow(spriteAt(s.player.X, s.player.Y), spriteAt(s.dummy.X, s.dummy.Y))
+	return nil
+}
+
+// advances the simulation by one tick
+func (s *TrainingScene) step() {
+	s.frame++
+	playerInput := keyboardInput()
+	var dummyInput uint8
+	switch s.mode {
+	case dummyBlock:
+		dummyInput = inputBlock
+	case dummyRecord:
+		dummyInput = playerInput
+		playerInput = 0
+		s.recording = append(s.recording, dummyInput)
+	case dummyPlayback:
+		dummyInput = s.recording[s.playhead]
+		s.playhead = (s.playhead + 1) % len(s.recording)
+	case dummyCpu:
+		dummyInput = s.cpu.nextInput(&s.dummy, &s.player)
+	}
+
+	fighters := [2]*Fighter{&s.player, &s.dummy}
+	last := [2][2]float64{{s.player.X, s.player.Y}, {s.dummy.X, s.dummy.Y}}
+	results, hits := stepFighters(fighters, [2]uint8{playerInput, dummyInput})
+	for i, f := range fighters {
+		other := fighters[1-i]
+		if hits[i] {
+			s.lastHit, s.lastHitter = results[i], []string{"You", "Dummy"}[i]
+			s.camera.hit(results[i])
+			s.effects.hit(f, other, results[i])
+			sound.PlayEvent(s.frame, i, hitSound(results[i], other))
+		}
+		moving := s.effects.footsteps(f, last[i][0], last[i][1], s.moving[i])
+		if moving && !s.moving[i] {
+			sound.PlayEvent(s.frame, i, sound.Step)
+		}
+		s.moving[i] = moving
+	}
+	// effects only move with the simulation, so they freeze while paused and advance with it a frame at a time
+	s.effects.update()
+	s.hud.update(s.hudPlayers())
+}
+
+// the player and the dummy, as the HUD shows them
+func (s *TrainingScene) hudPlayers() []hudPlayer {
+	return []hudPlayer{
+		{Name: characters[s.character].Name, Tint: characters[s.character].Tint, Fighter: true, Health: s.player.Health, Ping: -1},
+		{Name: "Dummy", Tint: characters[0].Tint, Fighter: true, Health: s.dummy.Health, Ping: -1},
+	}
+}
+
+func (s *TrainingScene) Draw(screen *ebiten.Image) {
+	screen.Fill(stages[0].Background)
+
+	view := s.camera.view()
+	drawFacing(screen, view, &s.player, characters[s.character].Tint)
+	drawFacing(screen, view, &s.dummy, characters[0].Tint)
+	s.effects.draw(screen, view)
+	if s.showBoxes {
+		for _, f := range []*Fighter{&s.player, &s.dummy} {
+			strokeBox(screen, view, f.hurtbox(), hurtboxColor)
+			if hitbox, ok := f.hitbox(); ok {
+				fillBox(screen, view, hitbox, hitboxColor)
+			}
+		}
+	}
+
+	// no timer or rounds, training goes on for as long as it takes
+	players := s.hudPlayers()
+	s.hud.draw(screen, players, -1, 0, 0)
+
+	move := s.player.Move
+	status := fmt.Sprintf("Training - %s\n", characters[s.character].Name)
+	status += fmt.Sprintf("%s: startup %d, active %d, recovery %d, total %d\n", move.Name, move.Startup+1, move.Active, move.Recovery, move.totalFrames())
+	status += fmt.Sprintf("On hit %+d, on block %+d\n", move.advantage(move.Hitstun), move.advantage(move.Blockstun))
+	if s.lastHitter != "" {
+		how := "hit"
+		if s.lastHit.Blocked {
+			how = "blocked"
+		}
+		status += fmt.Sprintf("Last: %s %s, %s, %+d\n", s.lastHitter, how, s.lastHit.Move.Name, s.lastHit.Advantage)
+	}
+	status += fmt.Sprintf("Health: you %d, dummy %d\n", s.player.Health, s.dummy.Health)
+	status += fmt.Sprintf("Dummy: %s", dummyModeNames[s.mode])
+	if len(s.recording) > 0 {
+		status += fmt.Sprintf(" (%d frames recorded)", len(s.recording))
+	}
+	status += fmt.Sprintf("\nFrame %d", s.frame)
+	if s.paused {
+		status += " - paused, N to advance"
+	}
+	status += "\nArrows move, Z attacks, X blocks\nP pause, H boxes, R reset, C character\nB dummy blocks, 1 record dummy, 2 play back, 3 CPU dummy\nEscape leaves"
+	ebitenutil.DebugPrintAt(screen, status, 0, hudHeight(len(players)))
+}
+
+func (s *TrainingScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	s.camera.resize(outsideWidth, outsideHeight)
+	return outsideWidth, outsideHeight
+}
+
+// draws a fighter through the camera's view, mirrored when facing left
+func drawFacing(screen *ebiten.Image, view ebiten.GeoM, f *Fighter, tint color.Color) {
+	op := &ebiten.DrawImageOptions{}
+	if f.Facing < 0 {
+		op.GeoM.Scale(-1, 1)
+		op.GeoM.Translate(float64(img.Bounds().Dx()), 0)
+	}
+	op.GeoM.Translate(f.X, f.Y)
+	op.GeoM.Concat(view)
+	op.Filter = ebiten.FilterLinear
+	op.ColorScale.ScaleWithColor(tint)
+	screen.DrawImage(img, op)
+}
+
+// where a box in the world ends up on screen, the camera only ever scales and moves things
+func screenBox(view ebiten.GeoM, r image.Rectangle) (float32, float32, float32, float32) {
+	x0, y0 := view.Apply(float64(r.Min.X), float64(r.Min.Y))
+	x1, y1 := view.Apply(float64(r.Max.X), float64(r.Max.Y))
+	return float32(x0), float32(y0), float32(x1 - x0), float32(y1 - y0)
+}
+
+func strokeBox(screen *ebiten.Image, view ebiten.GeoM, r image.Rectangle, c color.Color) {
+	x, y, width, height := screenBox(view, r)
+	vector.StrokeRect(screen, x, y, width, height, 2, c, false)
+}
+
+func fillBox(screen *ebiten.Image, view ebiten.GeoM, r image.Rectangle, c color.Color) {
+	x, y, width, height := screenBox(view, r)
+	vector.DrawFilledRect(screen, x, y, width, height, c, false)
+}
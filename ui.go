@@ -0,0 +1,151 @@
+package main
+
+import (
+	"image/color"
+
+	"github.com/ebitenui/ebitenui/image"
+	"github.com/ebitenui/ebitenui/widget"
+)
+
+var uiTextColor = color.NRGBA{0xdf, 0xf4, 0xff, 0xff}
+
+var uiBackground = color.NRGBA{0x13, 0x1a, 0x22, 0xff}
+
+// a plain root container for a scene's UI
+func newRootContainer(layout widget.Layouter) *widget.Container {
+	return widget.NewContainer(
+		// the container will use a plain color as its background
+		widget.ContainerOpts.BackgroundImage(image.NewNineSliceColor(uiBackground)),
+
+		widget.ContainerOpts.Layout(layout),
+	)
+}
+
+// a vertical stack of widgets, used by most of the menus
+func newColumnLayout() widget.Layouter {
+	return widget.NewRowLayout(
+		widget.RowLayoutOpts.Direction(widget.DirectionVertical),
+		widget.RowLayoutOpts.Padding(widget.NewInsetsSimple(20)),
+		widget.RowLayoutOpts.Spacing(10),
+	)
+}
+
+// two columns of widgets that fill their cells, for menus too long to fit the screen in one
+func newGrid() *widget.Container {
+	return widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewGridLayout(
+			widget.GridLayoutOpts.Columns(2),
+			widget.GridLayoutOpts.Spacing(10, 10),
+		)),
+	)
+}
+
+// a row of a grid with a label on the left of what it labels
+func addLabeled(g *Game, grid *widget.Container, label string, input widget.PreferredSizeLocateableWidget) {
+	text := newLabel(g, label)
+	text.GetWidget().LayoutData = widget.GridLayoutData{VerticalPosition: widget.GridLayoutPositionCenter}
+	grid.AddChild(text, input)
+}
+
+func newButton(g *Game, label string, handler widget.ButtonClickedHandlerFunc, opts ...widget.WidgetOpt) *widget.Button {
+	return widget.NewButton(
+		// set general widget options
+		widget.ButtonOpts.WidgetOpts(opts...),
+
+		// specify the images to use
+		widget.ButtonOpts.Image(g.buttonImage),
+
+		// specify the button's text, the font face, and the color
+		widget.ButtonOpts.Text(label, g.face, &widget.ButtonTextColor{
+			Idle:     color.NRGBA{0xdf, 0xf4, 0xff, 0xff},
+			Hover:    color.NRGBA{0, 255, 128, 255},
+			Pressed:  color.NRGBA{255, 0, 0, 255},
+			Disabled: color.NRGBA{0x80, 0x80, 0x80, 0xff},
+		}),
+		widget.ButtonOpts.TextProcessBBCode(true),
+		// specify that the button's text needs some padding for correct display
+		widget.ButtonOpts.TextPadding(widget.Insets{
+			Left:   30,
+			Right:  30,
+			Top:    5,
+			Bottom: 5,
+		}),
+
+		// add a handler that reacts to clicking the button
+		widget.ButtonOpts.ClickedHandler(handler),
+
+		// Indicate that this button should not be submitted when enter or space are pressed
+		widget.ButtonOpts.DisableDefaultKeys(),
+	)
+}
+
+// extra options are applied after the defaults, so they can override them
+func newTextInput(g *Game, placeholder string, changed func(text string), extra ...widget.TextInputOpt) *widget.TextInput {
+	opts := []widget.TextInputOpt{
+		widget.TextInputOpts.WidgetOpts(widget.WidgetOpts.MinSize(150, 30)),
+
+		//Set the Idle and Disabled background image for the text input
+		//If the NineSlice image has a minimum size, the widget will use that or
+		// widget.WidgetOpts.MinSize; whichever is greater
+		widget.TextInputOpts.Image(&widget.TextInputImage{
+			Idle:     image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
+			Disabled: image.NewNineSliceColor(color.NRGBA{R: 100, G: 100, B: 100, A: 255}),
+		}),
+
+		//Set the font face and size for the widget
+		widget.TextInputOpts.Face(g.face),
+
+		//Set the colors for the text and caret
+		widget.TextInputOpts.Color(&widget.TextInputColor{
+			Idle:          color.NRGBA{254, 255, 255, 255},
+			Disabled:      color.NRGBA{R: 200, G: 200, B: 200, A: 255},
+			Caret:         color.NRGBA{254, 255, 255, 255},
+			DisabledCaret: color.NRGBA{R: 200, G: 200, B: 200, A: 255},
+		}),
+
+		//Set how much padding there is between the edge of the input and the text
+		widget.TextInputOpts.Padding(widget.NewInsetsSimple(5)),
+
+		//Set the font and width of the caret
+		widget.TextInputOpts.CaretOpts(
+			widget.CaretOpts.Size(g.face, 2),
+		),
+
+		//This text is displayed if the input is empty
+		widget.TextInputOpts.Placeholder(placeholder),
+
+		//This is called whenever there is a change to the text
+		widget.TextInputOpts.ChangedHandler(func(args *widget.TextInputChangedEventArgs) {
+			if changed != nil {
+				changed(args.InputText)
+			}
+		}),
+	}
+	return widget.NewTextInput(append(opts, extra...)...)
+}
+
+// a checkbox with a label next to it
+func newToggle(g *Game, label string, changed func(on bool)) *widget.Container {
+	container := widget.NewContainer(
+		widget.ContainerOpts.Layout(widget.NewRowLayout(
+			widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+			widget.RowLayoutOpts.Spacing(10),
+		)),
+	)
+	container.AddChild(widget.NewCheckbox(
+		widget.CheckboxOpts.ButtonOpts(
+			widget.ButtonOpts.Image(g.buttonImage),
+			widget.ButtonOpts.DisableDefaultKeys(),
+		),
+		widget.CheckboxOpts.Image(g.checkboxImage),
+		widget.CheckboxOpts.StateChangedHandler(func(args *widget.CheckboxChangedEventArgs) {
+			changed(args.State == widget.WidgetChecked)
+		}),
+	))
+	container.AddChild(newLabel(g, label))
+	return container
+}
+
+func newLabel(g *Game, label string) *widget.Text {
+	return widget.NewText(widget.TextOpts.Text(label, g.face, uiTextColor))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ebitenui/ebitenui"
+)
+
+func newTestGame(t *testing.T) *Game {
+	t.Helper()
+	buttonImage, err := loadButtonImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := loadFont(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &Game{face: face, buttonImage: buttonImage, checkboxImage: loadCheckboxImage()}
+	g.scenes = newSceneManager(g)
+	return g
+}
+
+// the longest menus still fit the logical screen, nothing is cut off at the bottom
+func TestMenusFitTheScreen(t *testing.T) {
+	g := newTestGame(t)
+	menus := []struct {
+		name string
+		ui   *ebitenui.UI
+	}{
+		{"main menu", newMainMenuScene(g).ui},
+		{"host", newHostScene(g, false).ui},
+		{"settings", newSettingsScene(g).ui},
+		{"copy-paste connect", newManualScene(g).ui},
+	}
+	for _, menu := range menus {
+		w, h := menu.ui.Container.PreferredSize()
+		if w > logicalWidth || h > logicalHeight {
+			t.Errorf("%s is %dx%d, the screen is %dx%d", menu.name, w, h, logicalWidth, logicalHeight)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ebitenui/ebitenui"
+	"github.com/ebitenui/ebitenui/widget"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"valorzard/gopher-combat/sound"
+)
+
+// picks how hard the CPU is before an offline match
+type CpuScene struct {
+	ui *ebitenui.UI
+}
+
+func newCpuScene(g *Game) *CpuScene {
+	s := &CpuScene{}
+
+	rootContainer := newRootContainer(newColumnLayout())
+	s.ui = &ebitenui.UI{
+		Container: rootContainer,
+	}
+
+	rootContainer.AddChild(newLabel(g, "Versus CPU"))
+	for _, difficulty := range cpuDifficulties {
+		rootContainer.AddChild(newButton(g, difficulty.Name, func(args *widget.ButtonClickedEventArgs) {
+			g.scenes.Push(newVersusScene(g, difficulty))
+		}))
+	}
+
+	rootContainer.AddChild(newButton(g, "Back", func(args *widget.ButtonClickedEventArgs) {
+		g.scenes.Pop()
+	}))
+
+	return s
+}
+
+func (s *CpuScene) Enter(g *Game) {}
+
+func (s *CpuScene) Exit(g *Game) {}
+
+func (s *CpuScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	s.ui.Update()
+	return nil
+}
+
+func (s *CpuScene) Draw(screen *ebiten.Image) {
+	s.ui.Draw(screen)
+}
+
+func (s *CpuScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
+}
+
+// one side of an offline match
+type versusSide struct {
+	fighter   Fighter
+	character Character
+	source    inputSource
+}
+
+// rounds it takes to win a match
+const roundsToWin = 2
+
+const (
+	// ticks the round number is up before anyone can move
+	roundIntroFrames = 60
+	// ticks a round's result is up before the next one starts
+	roundOverFrames = 120
+)
+
+// an offline match against the CPU, which goes through the same inputs and simulation as the player
+// first to win roundsToWin rounds takes the match, R starts over, Escape leaves
+type VersusScene struct {
+	difficulty cpuDifficulty
+	sides      [2]versusSide
+	// frame within the round, which the round timer runs off
+	frame int
+	round int
+	// rounds won by each side
+	rounds [2]int
+	// ticks left of the round's intro, and of its result once it's over
+	intro     int
+	roundOver int
+	// how the last round ended, shown while the next one waits
+	result  string
+	winner  string
+	camera  *Camera
+	effects *particleSystem
+	hud     *Hud
+	// whether each side was walking last tick, for the dust
+	moving [2]bool
+}
+
+func newVersusScene(g *Game, difficulty cpuDifficulty) *VersusScene {
+	s := &VersusScene{difficulty: difficulty, camera: newCamera(), effects: newParticleSystem(), hud: newHud(g)}
+	s.reset()
+	return s
+}
+
+// starts the whole match over
+func (s *VersusScene) reset() {
+	s.round = 0
+	s.rounds = [2]int{}
+	s.winner = ""
+	s.nextRound()
+}
+
+// puts both fighters back where they started for the next round
+func (s *VersusScene) nextRound() {
+	cpuCharacter := characters[len(characters)-1]
+	s.sides = [2]versusSide{
+		{newFighter(trainingPlayerX, trainingY, characters[0].Attack), characters[0], keyboardSource{}},
+		{newFighter(trainingDummyX, trainingY, cpuCharacter.Attack), cpuCharacter, newCpuPlayer(s.difficulty, uint64(time.Now().UnixNano()))},
+	}
+	s.sides[1].fighter.Facing = -1
+	s.frame = 0
+	s.round++
+	s.intro = roundIntroFrames
+	s.roundOver = 0
+	s.result = ""
+	s.moving = [2]bool{}
+	s.effects.clear()
+	sound.ResetEvents()
+}
+
+func (s *VersusScene) Enter(g *Game) {
+	playMusic(stageTrack(stages[0]))
+}
+
+func (s *VersusScene) Exit(g *Game) {}
+
+func (s *VersusScene) Update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		s.reset()
+	}
+	if s.winner != "" {
+		s.animate()
+		return nil
+	}
+	if s.roundOver > 0 {
+		s.animate()
+		s.roundOver--
+		if s.roundOver == 0 {
+			s.endRound()
+		}
+		return nil
+	}
+	if s.intro > 0 {
+		s.animate()
+		s.intro--
+		return nil
+	}
+
+	// inputs are all read before anyone moves, so neither side sees the other's input early
+	var inputs [2]uint8
+	for i := range s.sides {
+		inputs[i] = s.sides[i].source.nextInput(&s.sides[i].fighter, &s.sides[1-i].fighter)
+	}
+	fighters := [2]*Fighter{&s.sides[0].fighter, &s.sides[1].fighter}
+	last := [2][2]float64{{fighters[0].X, fighters[0].Y}, {fighters[1].X, fighters[1].Y}}
+	results, hits := stepFighters(fighters, inputs)
+	for i, fighter := range fighters {
+		opponent := fighters[1-i]
+		if hits[i] {
+			s.camera.hit(results[i])
+			s.effects.hit(fighter, opponent, results[i])
+			sound.PlayEvent(s.frame, i, hitSound(results[i], opponent))
+		}
+		moving := s.effects.footsteps(fighter, last[i][0], last[i][1], s.moving[i])
+		if moving && !s.moving[i] {
+			sound.PlayEvent(s.frame, i, sound.Step)
+		}
+		s.moving[i] = moving
+	}
+	s.frame++
+	s.animate()
+
+	player, cpu := &s.sides[0].fighter, &s.sides[1].fighter
+	switch {
+	case player.Health == 0 || cpu.Health == 0:
+		s.result = "K.O."
+	case s.frame >= matchFrames:
+		s.result = "Time"
+	default:
+		return nil
+	}
+	// whoever has more health left takes the round, nobody does on a draw
+	switch {
+	case player.Health > cpu.Health:
+		s.rounds[0]++
+	case player.Health < cpu.Health:
+		s.rounds[1]++
+	default:
+		s.result += "\nDraw"
+	}
+	s.roundOver = roundOverFrames
+	return nil
+}
+
+// once a round's result has been up long enough, either someone has won the match or the next round starts
+func (s *VersusScene) endRound() {
+	switch {
+	case s.rounds[0] >= roundsToWin:
+		s.winner = "You win!"
+	case s.rounds[1] >= roundsToWin:
+		s.winner = "The CPU wins"
+	default:
+		s.nextRound()
+	}
+}
+
+// keeps both fighters in view and moves the effects on, which carries on after the match is over
+// so a shake or a KO burst gets to finish
+func (s *VersusScene) animate() {
+	s.camera.follow(spriteAt(s.sides[0].fighter.X, s.sides[0].fighter.Y), spriteAt(s.sides[1].fighter.X, s.sides[1].fighter.Y))
+	s.effects.update()
+	s.hud.update(s.hudPlayers())
+}
+
+// both sides, as the HUD shows them
+func (s *VersusScene) hudPlayers() []hudPlayer {
+	players := make([]hudPlayer, len(s.sides))
+	names := [2]string{playerName, "CPU (" + s.difficulty.Name + ")"}
+	for i, side := range s.sides {
+		players[i] = hudPlayer{Name: names[i], Tint: side.character.Tint, Fighter: true, Health: side.fighter.Health, Rounds: s.rounds[i], Ping: -1}
+	}
+	return players
+}
+
+func (s *VersusScene) Draw(screen *ebiten.Image) {
+	screen.Fill(stages[0].Background)
+	view := s.camera.view()
+	for i := range s.sides {
+		drawFacing(screen, view, &s.sides[i].fighter, s.sides[i].character.Tint)
+	}
+	s.effects.draw(screen, view)
+
+	secondsLeft := max(matchFrames-s.frame, 0) / 60
+	s.hud.draw(screen, s.hudPlayers(), secondsLeft, s.round, roundsToWin)
+	hint := "Arrows move, Z attacks, X blocks"
+	switch {
+	case s.winner != "":
+		s.hud.banner(screen, s.winner)
+		hint = "R for a rematch, Escape leaves"
+	case s.roundOver > 0:
+		s.hud.banner(screen, s.result)
+	case s.intro > 0:
+		s.hud.banner(screen, fmt.Sprintf("Round %d", s.round))
+	}
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Versus CPU (%s) - %s", s.difficulty.Name, hint), 0, logicalHeight-debugLineHeight)
+}
+
+func (s *VersusScene) Layout(outsideWidth int, outsideHeight int) (int, int) {
+	s.camera.resize(outsideWidth, outsideHeight)
+	return outsideWidth, outsideHeight
+}